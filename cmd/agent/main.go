@@ -3,7 +3,9 @@ package main
 import (
 	"context"
 	"fmt"
-	"log"
+	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/go-resty/resty/v2"
 
@@ -16,6 +18,12 @@ const (
 )
 
 func main() {
+	os.Exit(run())
+}
+
+// run starts the agent and returns the exit code, after the deferred
+// cleanup has closed the log
+func run() int {
 	err := logger.New(agentLogFile)
 	if err != nil {
 		logger.Log.WithError(err).Error("Failed to initialize logger")
@@ -27,10 +35,16 @@ func main() {
 		}
 	}()
 
-	ctx := context.Background()
+	// Stop on SIGINT/SIGTERM, the agent reports once more before it exits
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
 	client := resty.New()
 	err = appagent.NewAgent(ctx, client)
 	if err != nil {
-		log.Fatalf("Failed to start the agent: %v", err)
+		logger.Log.WithError(err).Error("Agent stopped without the final report")
+		fmt.Fprintf(os.Stderr, "Agent stopped without the final report: %v\n", err)
+		return 1
 	}
+	return 0
 }
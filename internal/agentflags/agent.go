@@ -6,6 +6,8 @@ import (
 	"strconv"
 	"strings"
 
+	"Vova4o/metrix/internal/flagargs"
+
 	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
 )
@@ -17,20 +19,44 @@ func init() {
 	flags.StringP("ServerAddress", "a", "localhost:8080", "HTTP server network address")
 	flags.IntP("ReportInterval", "r", 10, "Interval between fetching reportable metrics in seconds")
 	flags.IntP("PollInterval", "p", 2, "Interval between polling metrics in seconds")
+	flags.String("AgentID", "", "Agent name reported to the server, defaults to the host name")
+	flags.Int("ShutdownTimeout", 5, "Seconds the final report may take when the agent is stopped")
+	flags.StringP("Key", "k", "", "Key of the HMAC-SHA256 signatures of requests and responses")
+	flags.String("Token", "", "Bearer token the agent authenticates to the server with")
+	flags.String("crypto-key", "", "Path to the PEM file with the server's RSA public key to encrypt payloads with")
+	flags.String("TLSCA", "", "Path to the PEM bundle of CAs to verify the server with, the system roots by default")
+	flags.String("TLSCert", "", "Path to the PEM client certificate for servers that require mutual TLS")
+	flags.String("TLSKey", "", "Path to the PEM private key of the client certificate")
 
 	// Parse the command-line flags
-	flags.Parse(os.Args[1:])
+	flags.Parse(flagargs.Normalize(flags, os.Args[1:]))
 
 	// Bind the flags to viper
 	bindFlagToViper("ServerAddress")
 	bindFlagToViper("ReportInterval")
 	bindFlagToViper("PollInterval")
+	bindFlagToViper("AgentID")
+	bindFlagToViper("ShutdownTimeout")
+	bindFlagToViper("Key")
+	bindFlagToViper("Token")
+	bindFlagToViper("crypto-key")
+	bindFlagToViper("TLSCA")
+	bindFlagToViper("TLSCert")
+	bindFlagToViper("TLSKey")
 
 	// Set the environment variable names
 	viper.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
 	bindEnvToViper("ServerAddress", "ADDRESS")
 	bindEnvToViper("ReportInterval", "REPORT_INTERVAL")
 	bindEnvToViper("PollInterval", "POLL_INTERVAL")
+	bindEnvToViper("AgentID", "AGENT_ID")
+	bindEnvToViper("ShutdownTimeout", "SHUTDOWN_TIMEOUT")
+	bindEnvToViper("Key", "KEY")
+	bindEnvToViper("Token", "TOKEN")
+	bindEnvToViper("crypto-key", "CRYPTO_KEY")
+	bindEnvToViper("TLSCA", "TLS_CA")
+	bindEnvToViper("TLSCert", "TLS_CERT")
+	bindEnvToViper("TLSKey", "TLS_KEY")
 
 	// Read the environment variables
 	viper.AutomaticEnv()
@@ -69,3 +95,46 @@ func GetPollInterval() int {
 	}
 	return pollInterval
 }
+
+func GetAgentID() string {
+	if id := viper.GetString("AgentID"); id != "" {
+		return id
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		return "unknown"
+	}
+	return hostname
+}
+
+func GetShutdownTimeout() int {
+	timeout := viper.GetInt("ShutdownTimeout")
+	if timeout <= 0 {
+		timeout = 5
+	}
+	return timeout
+}
+
+func GetKey() string {
+	return viper.GetString("Key")
+}
+
+func GetToken() string {
+	return viper.GetString("Token")
+}
+
+func GetCryptoKey() string {
+	return viper.GetString("crypto-key")
+}
+
+func GetTLSCA() string {
+	return viper.GetString("TLSCA")
+}
+
+func GetTLSCert() string {
+	return viper.GetString("TLSCert")
+}
+
+func GetTLSKey() string {
+	return viper.GetString("TLSKey")
+}
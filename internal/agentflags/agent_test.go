@@ -129,3 +129,16 @@ func TestBindEnvToViper(t *testing.T) {
 		t.Errorf("expected %v, got %v", "value", value)
 	}
 }
+
+func TestGetAgentID(t *testing.T) {
+	os.Setenv("AGENT_ID", "web-1")
+	if id := GetAgentID(); id != "web-1" {
+		t.Errorf("expected %v, got %v", "web-1", id)
+	}
+	os.Unsetenv("AGENT_ID")
+
+	hostname, _ := os.Hostname()
+	if id := GetAgentID(); id != hostname {
+		t.Errorf("expected %v, got %v", hostname, id)
+	}
+}
@@ -2,8 +2,17 @@ package appagent
 
 import (
 	"context"
+	"crypto/rsa"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
 
+	"Vova4o/metrix/internal/agentflags"
+	"Vova4o/metrix/internal/certs"
 	"Vova4o/metrix/internal/clientmetrics"
+	"Vova4o/metrix/internal/encryption"
 	"Vova4o/metrix/internal/logger"
 
 	"github.com/go-resty/resty/v2"
@@ -11,12 +20,20 @@ import (
 )
 
 // NewAgent creates and starts a new Metrics agent.
-// It never returns, running a continuous loop to collect and send metrics.
+// It runs a continuous loop to collect and send metrics until ctx is
+// cancelled, then polls and reports one last time before it returns.
 //
 // Returns:
 //
-//	error: an error occurred while creating or running the agent.
+//	error: the final report failed or did not finish in time.
 func NewAgent(ctx context.Context, client *resty.Client) error {
+	// Let the server know which agent the metrics come from
+	client.SetHeader("X-Agent-ID", agentflags.GetAgentID())
+
+	if token := agentflags.GetToken(); token != "" {
+		client.SetAuthToken(token)
+	}
+
 	// Add a middleware logger
 	client.OnBeforeRequest(func(client *resty.Client, request *resty.Request) error {
 		logger.Log.WithFields(logrus.Fields{
@@ -35,26 +52,143 @@ func NewAgent(ctx context.Context, client *resty.Client) error {
 		return nil
 	})
 
+	// Encrypt the bodies for the server when it has a key pair
+	if path := agentflags.GetCryptoKey(); path != "" {
+		publicKey, err := encryption.LoadPublicKey(path)
+		if err != nil {
+			return fmt.Errorf("failed to load crypto key: %w", err)
+		}
+		client.OnBeforeRequest(encryptBody(publicKey))
+	}
+
 	metrics := clientmetrics.NewMetrics(client) // Create new Metrics
 
-	runMetricsLoop(ctx, metrics)
+	// Talk to the server over TLS, with a client certificate for mutual TLS
+	if agentflags.GetTLSCA() != "" || agentflags.GetTLSCert() != "" || agentflags.GetTLSKey() != "" {
+		store, err := certs.Load(certs.Files{
+			Cert: agentflags.GetTLSCert(),
+			Key:  agentflags.GetTLSKey(),
+			CA:   agentflags.GetTLSCA(),
+		})
+		if err != nil {
+			return err
+		}
+		client.SetTLSClientConfig(store.ClientConfig())
+		go func() {
+			if err := store.Watch(ctx); err != nil {
+				logger.Log.WithError(err).Warn("Certificates will not be reloaded")
+			}
+		}()
 
-	return nil
+		if !strings.Contains(metrics.BaseURL, "://") {
+			metrics.BaseURL = "https://" + metrics.BaseURL
+		}
+	}
+
+	// The server takes updates only from its trusted subnet
+	if ip, err := outboundIP(metrics.BaseURL); err != nil {
+		logger.Log.WithError(err).Warn("Failed to find the outbound address, X-Real-IP is not sent")
+	} else {
+		client.SetHeader("X-Real-IP", ip.String())
+	}
+
+	timeout := time.Duration(agentflags.GetShutdownTimeout()) * time.Second
+	return runMetricsLoop(ctx, metrics, timeout)
 }
 
-func runMetricsLoop(ctx context.Context, metrics *clientmetrics.Metrics) {
+// outboundIP returns the address of the interface the agent reaches the
+// server through. Connecting a UDP socket only picks the route, nothing is sent
+func outboundIP(baseURL string) (net.IP, error) {
+	if !strings.Contains(baseURL, "://") {
+		baseURL = "http://" + baseURL
+	}
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, err
+	}
+
+	port := u.Port()
+	if port == "" {
+		port = "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+	}
+	conn, err := net.Dial("udp", net.JoinHostPort(u.Hostname(), port))
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	return conn.LocalAddr().(*net.UDPAddr).IP, nil
+}
+
+// encryptBody seals the body of every request for the server's public key.
+// It runs before resty serializes the body, after the senders compressed it
+func encryptBody(key *rsa.PublicKey) resty.RequestMiddleware {
+	return func(_ *resty.Client, request *resty.Request) error {
+		var body []byte
+		switch b := request.Body.(type) {
+		case nil:
+			return nil
+		case []byte:
+			body = b
+		case string:
+			body = []byte(b)
+		default:
+			return fmt.Errorf("cannot encrypt a %T body", request.Body)
+		}
+		if request.Header.Get(encryption.Header) != "" {
+			// Already sealed on an earlier attempt
+			return nil
+		}
+
+		sealed, err := encryption.Encrypt(key, body)
+		if err != nil {
+			return fmt.Errorf("failed to encrypt request body: %w", err)
+		}
+		request.SetBody(sealed)
+		request.SetHeader(encryption.Header, encryption.Scheme)
+		return nil
+	}
+}
+
+func runMetricsLoop(ctx context.Context, metrics *clientmetrics.Metrics, timeout time.Duration) error {
 	for {
 		select {
 		case <-ctx.Done():
-			return
+			metrics.Stop()
+			return flush(metrics, timeout)
 		case <-metrics.PollTicker.C:
 			if err := metrics.PollMetrics(); err != nil {
 				logger.Log.WithError(err).Error("Failed to poll metrics")
 			}
 		case <-metrics.ReportTicker.C:
-			if err := metrics.ReportMetrics(metrics.BaseURL); err != nil {
+			if err := metrics.ReportMetrics(ctx, metrics.BaseURL); err != nil {
 				logger.Log.WithError(err).Error("Failed to report metrics")
 			}
 		}
 	}
 }
+
+// flush polls and reports the metrics one last time, so the values
+// collected since the previous report are not lost when the agent stops.
+// The requests still in flight at the deadline are cancelled, and flush
+// returns only after every sender has exited
+func flush(metrics *clientmetrics.Metrics, timeout time.Duration) error {
+	logger.Log.Info("Stopping the agent, sending the final report")
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if err := metrics.PollMetrics(); err != nil {
+		return fmt.Errorf("final poll: %w", err)
+	}
+	if err := metrics.ReportMetrics(ctx, metrics.BaseURL); err != nil {
+		if ctx.Err() != nil {
+			return fmt.Errorf("final report did not finish in %v: %w", timeout, err)
+		}
+		return fmt.Errorf("final report: %w", err)
+	}
+	return nil
+}
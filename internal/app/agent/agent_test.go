@@ -1,27 +1,78 @@
 package appagent_test
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	appagent "Vova4o/metrix/internal/app/agent"
+	"Vova4o/metrix/internal/encryption"
 	"Vova4o/metrix/internal/logger"
 
 	"github.com/go-resty/resty/v2"
 	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/spf13/viper"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
+// setFlags overrides agent settings for the duration of a test
+func setFlags(t *testing.T, values map[string]interface{}) {
+	t.Helper()
+	for key, value := range values {
+		previous := viper.Get(key)
+		viper.Set(key, value)
+		t.Cleanup(func() { viper.Set(key, previous) })
+	}
+}
+
+// newTestServer starts a server that records the paths the agent posts to
+// and answers them with status
+func newTestServer(t *testing.T, status int) (*httptest.Server, func() []string) {
+	var (
+		mu    sync.Mutex
+		paths []string
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		paths = append(paths, req.URL.Path)
+		mu.Unlock()
+		rw.WriteHeader(status)
+	}))
+	t.Cleanup(server.Close)
+
+	setFlags(t, map[string]interface{}{"ServerAddress": server.URL})
+
+	return server, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), paths...)
+	}
+}
+
 func TestNewAgent(t *testing.T) {
 	tests := []struct {
 		name     string
-		url      string
+		path     string
 		messages []string
 	}{
 		{
 			name:     "Test URL",
-			url:      "http://example.com",
+			path:     "/",
 			messages: []string{"Sending request", "Received response"},
 		},
 		// Add more test cases as needed
@@ -31,6 +82,8 @@ func TestNewAgent(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			// Create a new resty client
 			client := resty.New()
+			server, _ := newTestServer(t, http.StatusOK)
+			url := server.URL + tt.path
 
 			err := logger.New("test.log")
 			if err != nil {
@@ -55,18 +108,218 @@ func TestNewAgent(t *testing.T) {
 			if err != nil {
 				t.Fatalf("Failed to start the agent: %v", err)
 			}
+			// Forget the final report, only the request below is checked
+			hook.Reset()
 
 			// Make a request
-			_, _ = client.R().Get(tt.url)
+			_, err = client.R().Get(url)
+			require.NoError(t, err)
 
 			// Check if the request was logged
-			assert.Equal(t, len(tt.messages), len(hook.Entries))
+			require.Len(t, hook.Entries, len(tt.messages))
 			for i, message := range tt.messages {
 				assert.Equal(t, message, hook.Entries[i].Message)
 				if i == 0 { // Only the first log entry should have the URL
-					assert.Equal(t, tt.url, hook.Entries[i].Data["url"])
+					assert.Equal(t, url, hook.Entries[i].Data["url"])
 				}
 			}
 		})
 	}
 }
+
+func TestNewAgent_FinalReport(t *testing.T) {
+	_ = logger.New("test.log")
+
+	t.Run("reports once more when stopped", func(t *testing.T) {
+		_, paths := newTestServer(t, http.StatusOK)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		require.NoError(t, appagent.NewAgent(ctx, resty.New()))
+
+		got := strings.Join(paths(), "\n")
+		assert.Contains(t, got, "/update/counter/PollCount/1")
+		assert.Contains(t, got, "/update/gauge/Alloc/")
+	})
+
+	t.Run("fails when the report is rejected", func(t *testing.T) {
+		newTestServer(t, http.StatusInternalServerError)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		err := appagent.NewAgent(ctx, resty.New())
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "final report")
+	})
+
+	t.Run("fails when the server hangs", func(t *testing.T) {
+		block := make(chan struct{})
+		server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			<-block
+		}))
+		t.Cleanup(server.Close)
+		t.Cleanup(func() { close(block) })
+		setFlags(t, map[string]interface{}{"ServerAddress": server.URL, "ShutdownTimeout": 1})
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		start := time.Now()
+		require.Error(t, appagent.NewAgent(ctx, resty.New()))
+		assert.Less(t, time.Since(start), 3*time.Second)
+	})
+}
+
+func TestNewAgent_RealIP(t *testing.T) {
+	_ = logger.New("test.log")
+
+	var (
+		mu  sync.Mutex
+		ips []string
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		ips = append(ips, req.Header.Get("X-Real-IP"))
+		mu.Unlock()
+	}))
+	t.Cleanup(server.Close)
+	setFlags(t, map[string]interface{}{"ServerAddress": server.URL})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	require.NoError(t, appagent.NewAgent(ctx, resty.New()))
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.NotEmpty(t, ips)
+	for _, ip := range ips {
+		// The test server listens on the loopback interface
+		assert.Equal(t, "127.0.0.1", ip)
+	}
+}
+
+func TestNewAgent_Token(t *testing.T) {
+	_ = logger.New("test.log")
+
+	var (
+		mu      sync.Mutex
+		headers []string
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		headers = append(headers, req.Header.Get("Authorization"))
+		mu.Unlock()
+	}))
+	t.Cleanup(server.Close)
+	setFlags(t, map[string]interface{}{"ServerAddress": server.URL, "Token": "agent-secret"})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	require.NoError(t, appagent.NewAgent(ctx, resty.New()))
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.NotEmpty(t, headers)
+	for _, header := range headers {
+		assert.Equal(t, "Bearer agent-secret", header)
+	}
+}
+
+func TestNewAgent_Encrypted(t *testing.T) {
+	_ = logger.New("test.log")
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	require.NoError(t, err)
+	keyPath := filepath.Join(t.TempDir(), "public.pem")
+	require.NoError(t, os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0o600))
+
+	var (
+		mu      sync.Mutex
+		updates []map[string]interface{}
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		sealed, _ := io.ReadAll(req.Body)
+		if req.Header.Get(encryption.Header) != encryption.Scheme {
+			t.Errorf("request to %s is not encrypted", req.URL.Path)
+		}
+		body, err := encryption.Decrypt(key, sealed)
+		if err != nil {
+			t.Errorf("failed to decrypt: %v", err)
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if req.URL.Path == "/update/" {
+			gz, err := gzip.NewReader(bytes.NewReader(body))
+			if err != nil {
+				t.Errorf("failed to open gzip: %v", err)
+				http.Error(rw, err.Error(), http.StatusBadRequest)
+				return
+			}
+			var update map[string]interface{}
+			if err := json.NewDecoder(gz).Decode(&update); err != nil {
+				t.Errorf("failed to decode update: %v", err)
+				http.Error(rw, err.Error(), http.StatusBadRequest)
+				return
+			}
+			mu.Lock()
+			updates = append(updates, update)
+			mu.Unlock()
+		}
+	}))
+	defer server.Close()
+	setFlags(t, map[string]interface{}{"ServerAddress": server.URL, "crypto-key": keyPath})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	require.NoError(t, appagent.NewAgent(ctx, resty.New()))
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.NotEmpty(t, updates)
+
+	t.Run("missing key", func(t *testing.T) {
+		setFlags(t, map[string]interface{}{"crypto-key": filepath.Join(t.TempDir(), "missing.pem")})
+		assert.Error(t, appagent.NewAgent(ctx, resty.New()))
+	})
+}
+
+func TestNewAgent_TLS(t *testing.T) {
+	_ = logger.New("test.log")
+
+	var (
+		mu     sync.Mutex
+		served int
+	)
+	server := httptest.NewTLSServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		served++
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	caPath := filepath.Join(t.TempDir(), "ca.pem")
+	require.NoError(t, os.WriteFile(caPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0o600))
+
+	// The address has no scheme, with a CA bundle the agent uses https
+	setFlags(t, map[string]interface{}{
+		"ServerAddress": strings.TrimPrefix(server.URL, "https://"),
+		"TLSCA":         caPath,
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	require.NoError(t, appagent.NewAgent(ctx, resty.New()))
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.NotZero(t, served)
+
+	t.Run("system roots do not trust the server", func(t *testing.T) {
+		setFlags(t, map[string]interface{}{"ServerAddress": server.URL, "TLSCA": ""})
+		assert.Error(t, appagent.NewAgent(ctx, resty.New()))
+	})
+}
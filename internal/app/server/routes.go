@@ -0,0 +1,176 @@
+package appserver
+
+import (
+	"crypto/rsa"
+	"net"
+	"net/http"
+	"net/url"
+
+	"Vova4o/metrix/internal/audit"
+	"Vova4o/metrix/internal/auth"
+	"Vova4o/metrix/internal/expr"
+	"Vova4o/metrix/internal/handlers"
+	"Vova4o/metrix/internal/health"
+	mw "Vova4o/metrix/internal/middleware"
+	"Vova4o/metrix/internal/notifier"
+	"Vova4o/metrix/internal/openapi"
+	"Vova4o/metrix/internal/rules"
+	"Vova4o/metrix/internal/storage"
+	"Vova4o/metrix/internal/tenant"
+
+	"github.com/go-chi/chi/middleware"
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	tempFile       = "metrix.page.tmpl"
+	metricTempFile = "metric.page.tmpl"
+
+	// maxRequestBytes caps what a compressed request body may expand to
+	maxRequestBytes = 10 << 20
+)
+
+// routes are the components the HTTP routes are served by
+type routes struct {
+	storage  *storage.HistoryStorage
+	silencer *notifier.Silencer
+	receiver *notifier.Receiver // nil без настроенного нотификатора
+	rules    *rules.Manager     // nil без файла с правилами
+	health   *health.Checker
+	compress mw.CompressConfig
+	key      string // общий ключ подписи, пустой — без подписей
+
+	privateKey *rsa.PrivateKey // nil, если тела запросов не шифруются
+
+	trustedSubnet *net.IPNet // nil — обновления принимаются с любого адреса
+	restrictReads bool       // остальные маршруты, кроме проб, тоже только из подсети
+
+	tokens  *auth.Tokens     // nil — API открыт без токенов
+	tenants *tenant.Registry // nil — все запросы идут в storage
+	audit   *audit.Logger    // nil — изменения не записываются
+}
+
+// newRouter registers every route of the server. Each route must be
+// described in the OpenAPI document, which the tests check
+func newRouter(rt routes) (*chi.Mux, error) {
+	spec, err := openapi.Load()
+	if err != nil {
+		return nil, err
+	}
+
+	mux := chi.NewRouter()
+
+	mux.Use(mw.RequestLogger)
+	mux.Use(mw.Compress(rt.compress))
+	// mux.Use(middleware.Logger)
+	mux.Use(middleware.Recoverer)
+
+	// Agents encrypt the compressed bodies, which are decompressed before
+	// the validator and the handlers read them. Signatures cover the
+	// uncompressed bodies
+	body := chi.Chain(mw.Decrypt(rt.privateKey), mw.Decompress(maxRequestBytes), mw.Sign(rt.key)).Handler
+
+	// Requests that change something are only taken from the trusted
+	// subnet, reads when restrictReads is set. The probes stay open to the
+	// orchestrator, every other route needs a token with its scope.
+	// Requests are read and validated against the spec once they are let in
+	trusted := mw.TrustedSubnet(rt.trustedSubnet)
+	reads := mw.TrustedSubnet(nil)
+	if rt.restrictReads {
+		reads = trusted
+	}
+	scope := func(s auth.Scope) func(http.Handler) http.Handler {
+		return mw.RequireScope(rt.tokens, s)
+	}
+
+	probe := mux.With(body, spec.Validate)
+	read := mux.With(reads, scope(auth.ScopeRead), body, spec.Validate)
+	// Rules, alerts and silences are not kept per tenant
+	shared := mw.Shared(rt.tenants)
+	sharedRead := mux.With(reads, scope(auth.ScopeRead), shared, body, spec.Validate)
+	sharedWrite := mux.With(trusted, scope(auth.ScopeWrite), shared, body, spec.Validate)
+	sharedAdmin := mux.With(trusted, scope(auth.ScopeAdmin), shared, body, spec.Validate)
+	// Agents have to sign the metrics they send. Every change of metrics
+	// that succeeds goes to the audit log
+	update := mux.With(trusted, scope(auth.ScopeWrite), body, mw.RequireSignature(rt.key), spec.Validate, mw.Audit(rt.audit, rt.tenants))
+	remove := mux.With(trusted, scope(auth.ScopeAdmin), body, spec.Validate, mw.Audit(rt.audit, rt.tenants))
+
+	// Versioned resource API
+	read.Get("/api/v1/metrics", rt.perTenant(storageOnly(handlers.QueryMetrics)))
+	update.Post("/api/v1/metrics", rt.perTenant(storageOnly(handlers.BatchUpdate)))
+	read.Get("/api/v1/metrics/{metricType}/{metricName}", rt.perTenant(storageOnly(handlers.GetMetric)))
+	update.Put("/api/v1/metrics/{metricType}/{metricName}", rt.perTenant(storageOnly(handlers.PutMetric)))
+	remove.Delete("/api/v1/metrics/{metricType}/{metricName}", rt.perTenant(storageOnly(handlers.DeleteMetric)))
+
+	// Legacy routes stay for the agents already deployed
+	deprecated := mw.Deprecated(metricURL)
+	update.With(deprecated).Post("/update/{metricType}/{metricName}/{metricValue}", rt.perTenant(storageOnly(handlers.HandleUpdateText)))
+	update.With(deprecated).Post("/update/", rt.perTenant(storageOnly(handlers.HandleUpdateJSON)))
+	read.With(deprecated).Get("/value/{metricType}/{metricName}", rt.perTenant(storageOnly(handlers.MetricValue)))
+	read.With(deprecated).Post("/value/", rt.perTenant(storageOnly(handlers.MetricValueJSON)))
+
+	read.Get("/", rt.perTenant(func(s handlers.Storager, _ handlers.Historian) http.Handler {
+		return handlers.ShowMetrics(s, tempFile)
+	}))
+	read.Get("/metric/{metricType}/{metricName}", rt.perTenant(func(s handlers.Storager, h handlers.Historian) http.Handler {
+		return handlers.ShowMetric(s, h, metricTempFile)
+	}))
+
+	read.Get("/api/metrics", rt.perTenant(storageOnly(handlers.ExportMetrics)))
+	read.Get("/api/query", rt.perTenant(func(s handlers.Storager, h handlers.Historian) http.Handler {
+		return expr.HandleQuery(s, h)
+	}))
+
+	if rt.rules != nil {
+		sharedRead.Get("/api/rules", rt.rules.HandleList())
+	}
+
+	read.Get("/stream", rt.perTenant(storageOnly(handlers.StreamSSE)))
+	read.Get("/ws", rt.perTenant(storageOnly(handlers.StreamWS)))
+
+	if rt.receiver != nil {
+		sharedWrite.Post("/api/alerts/receiver", rt.receiver.HandleReceive())
+		sharedRead.Get("/api/alerts/receiver", rt.receiver.HandleList())
+	}
+
+	sharedAdmin.Post("/api/silences", rt.silencer.HandleCreate())
+	sharedRead.Get("/api/silences", rt.silencer.HandleList())
+	sharedAdmin.Delete("/api/silences/{silenceID}", rt.silencer.HandleDelete())
+
+	probe.Get("/healthz", rt.health.HandleLive())
+	probe.Get("/readyz", rt.health.HandleReady())
+
+	read.Get("/openapi.json", spec.Handler())
+
+	return mux, nil
+}
+
+// perTenant serves a route with the handler build makes for the storage of
+// the request's tenant. Recording rules, alerts and silences are shared
+// by the tenants and registered with mw.Shared instead
+func (rt routes) perTenant(build func(s handlers.Storager, h handlers.Historian) http.Handler) http.HandlerFunc {
+	def := build(rt.storage, rt.storage)
+	if rt.tenants == nil {
+		return def.ServeHTTP
+	}
+	return mw.Tenant(rt.tenants)(rt.tenants.Handler(def, func(t *tenant.Tenant) http.Handler {
+		return build(t.Storage, t.History)
+	})).ServeHTTP
+}
+
+// storageOnly adapts the handlers that do not read the history for perTenant
+func storageOnly(handler func(handlers.Storager) http.HandlerFunc) func(handlers.Storager, handlers.Historian) http.Handler {
+	return func(s handlers.Storager, _ handlers.Historian) http.Handler {
+		return handler(s)
+	}
+}
+
+// metricURL is the /api/v1 resource of the metric a legacy route is called
+// for, or the collection for the routes that take the metric in the body
+func metricURL(r *http.Request) string {
+	metricType, metricName := chi.URLParam(r, "metricType"), chi.URLParam(r, "metricName")
+	if metricType == "" || metricName == "" {
+		return "/api/v1/metrics"
+	}
+	return "/api/v1/metrics/" + url.PathEscape(metricType) + "/" + url.PathEscape(metricName)
+}
@@ -0,0 +1,510 @@
+package appserver
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"Vova4o/metrix/internal/audit"
+	"Vova4o/metrix/internal/auth"
+	"Vova4o/metrix/internal/encryption"
+	"Vova4o/metrix/internal/handlers"
+	"Vova4o/metrix/internal/health"
+	"Vova4o/metrix/internal/logger"
+	"Vova4o/metrix/internal/notifier"
+	"Vova4o/metrix/internal/openapi"
+	"Vova4o/metrix/internal/rules"
+	"Vova4o/metrix/internal/signature"
+	"Vova4o/metrix/internal/storage"
+	"Vova4o/metrix/internal/tenant"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testRoutes(t *testing.T) routes {
+	t.Helper()
+	_ = logger.New("test.log")
+
+	historyStorage := storage.NewHistoryStorage(storage.NewMemStorage(), time.Hour)
+	silencer, err := notifier.NewSilencer(nil)
+	require.NoError(t, err)
+	ruleManager, err := rules.New(rules.Config{}, historyStorage, historyStorage)
+	require.NoError(t, err)
+
+	return routes{
+		storage:  historyStorage,
+		silencer: silencer,
+		receiver: notifier.NewReceiver(),
+		rules:    ruleManager,
+		health:   health.New(),
+	}
+}
+
+// TestRoutesMatchOpenAPI keeps the router and the OpenAPI document in sync:
+// every registered route is documented and every documented route exists
+func TestRoutesMatchOpenAPI(t *testing.T) {
+	mux, err := newRouter(testRoutes(t))
+	require.NoError(t, err)
+
+	var registered []string
+	err = chi.Walk(mux, func(method, route string, _ http.Handler, _ ...func(http.Handler) http.Handler) error {
+		registered = append(registered, method+" "+route)
+		return nil
+	})
+	require.NoError(t, err)
+
+	spec, err := openapi.Load()
+	require.NoError(t, err)
+	var documented []string
+	for _, rt := range spec.Routes() {
+		documented = append(documented, rt.Method+" "+rt.Path)
+	}
+
+	sort.Strings(registered)
+	sort.Strings(documented)
+	assert.Equal(t, documented, registered)
+}
+
+func TestOpenAPIEndpoint(t *testing.T) {
+	mux, err := newRouter(testRoutes(t))
+	require.NoError(t, err)
+
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/openapi.json", nil))
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "application/json", rr.Header().Get("Content-Type"))
+	assert.True(t, strings.HasPrefix(strings.TrimSpace(rr.Body.String()), "{"))
+	assert.Contains(t, rr.Body.String(), `"openapi"`)
+}
+
+func TestRouterRejectsInvalidUpdate(t *testing.T) {
+	mux, err := newRouter(testRoutes(t))
+	require.NoError(t, err)
+
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/update/", strings.NewReader(`{"id": "a", "type": "histogram"}`))
+	req.Header.Set("Content-Type", "application/json")
+	mux.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"code":"unknown_type"`)
+}
+
+func TestRouterValidatesDecompressedBody(t *testing.T) {
+	mux, err := newRouter(testRoutes(t))
+	require.NoError(t, err)
+
+	post := func(body string) *httptest.ResponseRecorder {
+		var b bytes.Buffer
+		gz := gzip.NewWriter(&b)
+		_, err := gz.Write([]byte(body))
+		require.NoError(t, err)
+		require.NoError(t, gz.Close())
+
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/update/", &b)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Content-Encoding", "gzip")
+		mux.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := post(`{"id": "Alloc", "type": "gauge", "value": 1.5}`)
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, `{"id": "Alloc", "type": "gauge", "value": 1.5}`, rr.Body.String())
+
+	rr = post(`{"id": "a", "type": "histogram"}`)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"code":"unknown_type"`)
+}
+
+func TestRouterChecksSignatures(t *testing.T) {
+	rt := testRoutes(t)
+	rt.key = "secret"
+	mux, err := newRouter(rt)
+	require.NoError(t, err)
+
+	body := `{"id": "Alloc", "type": "gauge", "value": 1.5}`
+	post := func(sum string) *httptest.ResponseRecorder {
+		var b bytes.Buffer
+		gz := gzip.NewWriter(&b)
+		_, err := gz.Write([]byte(body))
+		require.NoError(t, err)
+		require.NoError(t, gz.Close())
+
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/update/", &b)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Content-Encoding", "gzip")
+		req.Header.Set(signature.Header, sum)
+		mux.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// The signature covers the body before compression
+	rr := post(signature.Sum([]byte("secret"), []byte(body)))
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.True(t, signature.Valid([]byte("secret"), rr.Body.Bytes(), rr.Header().Get(signature.Header)))
+
+	rr = post(signature.Sum([]byte("other"), []byte(body)))
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"code":"invalid_signature"`)
+
+	// Agents have to sign their updates, other clients only when they want to
+	rr = post("")
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"code":"invalid_signature"`)
+
+	for _, req := range []*http.Request{
+		httptest.NewRequest(http.MethodGet, "/api/v1/metrics", nil),
+		httptest.NewRequest(http.MethodDelete, "/api/v1/metrics/counter/Polls", nil),
+	} {
+		rr = httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+		assert.NotContains(t, rr.Body.String(), `"code":"invalid_signature"`, req.Method+" "+req.URL.Path)
+	}
+}
+
+func TestRouterDecryptsBodies(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	rt := testRoutes(t)
+	rt.key = "secret"
+	rt.privateKey = key
+	mux, err := newRouter(rt)
+	require.NoError(t, err)
+
+	// The agent signs the JSON, compresses it and encrypts the result
+	body := `{"id": "Polls", "type": "counter", "delta": 2}`
+	var b bytes.Buffer
+	gz := gzip.NewWriter(&b)
+	_, err = gz.Write([]byte(body))
+	require.NoError(t, err)
+	require.NoError(t, gz.Close())
+	sealed, err := encryption.Encrypt(&key.PublicKey, b.Bytes())
+	require.NoError(t, err)
+
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/update/", bytes.NewReader(sealed))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Content-Encoding", "gzip")
+	req.Header.Set(encryption.Header, encryption.Scheme)
+	req.Header.Set(signature.Header, signature.Sum([]byte("secret"), []byte(body)))
+	mux.ServeHTTP(rr, req)
+
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	assert.JSONEq(t, body, rr.Body.String())
+}
+
+func TestRouterTrustedSubnet(t *testing.T) {
+	_, subnet, err := net.ParseCIDR("10.0.0.0/8")
+	require.NoError(t, err)
+
+	serve := func(restrictReads bool, method, target, realIP string) int {
+		rt := testRoutes(t)
+		rt.trustedSubnet = subnet
+		rt.restrictReads = restrictReads
+		mux, err := newRouter(rt)
+		require.NoError(t, err)
+
+		// The subnet is checked before the body, so none is sent
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest(method, target, nil)
+		req.Header.Set("X-Real-IP", realIP)
+		mux.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	tests := []struct {
+		name          string
+		restrictReads bool
+		method        string
+		target        string
+		realIP        string
+		status        int
+	}{
+		{"update inside", false, http.MethodPost, "/update/counter/Polls/2", "10.1.2.3", http.StatusOK},
+		{"update outside", false, http.MethodPost, "/update/counter/Polls/2", "192.168.0.1", http.StatusForbidden},
+		{"put outside", false, http.MethodPut, "/api/v1/metrics/counter/Polls", "192.168.0.1", http.StatusForbidden},
+		{"delete outside", false, http.MethodDelete, "/api/v1/metrics/counter/Polls", "192.168.0.1", http.StatusForbidden},
+		{"silence outside", false, http.MethodPost, "/api/silences", "192.168.0.1", http.StatusForbidden},
+		{"unsilence outside", false, http.MethodDelete, "/api/silences/1", "192.168.0.1", http.StatusForbidden},
+		{"receive alert outside", false, http.MethodPost, "/api/alerts/receiver", "192.168.0.1", http.StatusForbidden},
+		{"read outside", false, http.MethodGet, "/api/v1/metrics", "192.168.0.1", http.StatusOK},
+		{"silences outside", false, http.MethodGet, "/api/silences", "192.168.0.1", http.StatusOK},
+		{"restricted read outside", true, http.MethodGet, "/api/v1/metrics", "192.168.0.1", http.StatusForbidden},
+		{"restricted read inside", true, http.MethodGet, "/api/v1/metrics", "10.1.2.3", http.StatusOK},
+		{"probe outside", true, http.MethodGet, "/healthz", "192.168.0.1", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.status, serve(tt.restrictReads, tt.method, tt.target, tt.realIP))
+		})
+	}
+}
+
+func TestRouterScopes(t *testing.T) {
+	tokens, err := auth.New(auth.Config{Tokens: []auth.TokenConfig{
+		{Name: "dashboard", SHA256: auth.Hash("read"), Scopes: []auth.Scope{auth.ScopeRead}},
+		{Name: "agent", SHA256: auth.Hash("write"), Scopes: []auth.Scope{auth.ScopeWrite}},
+		{Name: "ops", SHA256: auth.Hash("admin"), Scopes: []auth.Scope{auth.ScopeAdmin}},
+	}})
+	require.NoError(t, err)
+
+	rt := testRoutes(t)
+	rt.tokens = tokens
+	mux, err := newRouter(rt)
+	require.NoError(t, err)
+
+	serve := func(method, target, token string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest(method, target, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		mux.ServeHTTP(rr, req)
+		return rr
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		target string
+		token  string
+		status int
+	}{
+		{"update without token", http.MethodPost, "/update/counter/Polls/2", "", http.StatusUnauthorized},
+		{"update with read", http.MethodPost, "/update/counter/Polls/2", "read", http.StatusForbidden},
+		{"update with write", http.MethodPost, "/update/counter/Polls/2", "write", http.StatusOK},
+		// The body is only checked once the token is
+		{"put without body or token", http.MethodPut, "/api/v1/metrics/gauge/Alloc", "", http.StatusUnauthorized},
+		{"put without body with read", http.MethodPut, "/api/v1/metrics/gauge/Alloc", "read", http.StatusForbidden},
+		{"put without body with write", http.MethodPut, "/api/v1/metrics/gauge/Alloc", "write", http.StatusBadRequest},
+		{"value with read", http.MethodGet, "/value/counter/Polls", "read", http.StatusOK},
+		{"value with write", http.MethodGet, "/value/counter/Polls", "write", http.StatusForbidden},
+		{"value with admin", http.MethodGet, "/value/counter/Polls", "admin", http.StatusOK},
+		{"delete with write", http.MethodDelete, "/api/v1/metrics/counter/Polls", "write", http.StatusForbidden},
+		{"delete with admin", http.MethodDelete, "/api/v1/metrics/counter/Polls", "admin", http.StatusNoContent},
+		{"silences with read", http.MethodGet, "/api/silences", "read", http.StatusOK},
+		{"probe without token", http.MethodGet, "/healthz", "", http.StatusOK},
+	}
+
+	// In order, the update creates the metric the later routes read and delete
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := serve(tt.method, tt.target, tt.token)
+			require.Equal(t, tt.status, rr.Code, rr.Body.String())
+			if tt.status == http.StatusUnauthorized || tt.status == http.StatusForbidden {
+				assert.Equal(t, handlers.ProblemContentType, rr.Header().Get("Content-Type"))
+			}
+		})
+	}
+}
+
+// countingReader records whether the router read the request body
+type countingReader struct {
+	r     *bytes.Reader
+	reads int
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	c.reads++
+	return c.r.Read(p)
+}
+
+func TestRouterReadsBodiesAfterAccessChecks(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	_, subnet, err := net.ParseCIDR("10.0.0.0/8")
+	require.NoError(t, err)
+	tokens, err := auth.New(auth.Config{Tokens: []auth.TokenConfig{
+		{Name: "agent", SHA256: auth.Hash("write"), Scopes: []auth.Scope{auth.ScopeWrite}},
+	}})
+	require.NoError(t, err)
+
+	rt := testRoutes(t)
+	rt.privateKey = key
+	rt.trustedSubnet = subnet
+	rt.tokens = tokens
+	mux, err := newRouter(rt)
+	require.NoError(t, err)
+
+	var b bytes.Buffer
+	gz := gzip.NewWriter(&b)
+	_, err = gz.Write([]byte(`{"id": "Polls", "type": "counter", "delta": 2}`))
+	require.NoError(t, err)
+	require.NoError(t, gz.Close())
+	sealed, err := encryption.Encrypt(&key.PublicKey, b.Bytes())
+	require.NoError(t, err)
+
+	tests := []struct {
+		name   string
+		realIP string
+		token  string
+		status int
+	}{
+		{"outside the subnet", "192.168.0.1", "write", http.StatusForbidden},
+		{"without token", "10.1.2.3", "", http.StatusUnauthorized},
+		{"with write", "10.1.2.3", "write", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := &countingReader{r: bytes.NewReader(sealed)}
+			rr := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, "/update/", body)
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("Content-Encoding", "gzip")
+			req.Header.Set(encryption.Header, encryption.Scheme)
+			req.Header.Set("X-Real-IP", tt.realIP)
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			mux.ServeHTTP(rr, req)
+
+			require.Equal(t, tt.status, rr.Code, rr.Body.String())
+			if tt.status == http.StatusOK {
+				assert.NotZero(t, body.reads)
+			} else {
+				assert.Zero(t, body.reads, "the body was read before the request was let in")
+			}
+		})
+	}
+}
+
+func TestRouterTenants(t *testing.T) {
+	tenants, err := tenant.New(tenant.Config{Tenants: []tenant.TenantConfig{
+		{Name: "team-a", MaxMetrics: 2},
+		{Name: "team-b"},
+	}}, tenant.Options{HistoryRetention: time.Hour})
+	require.NoError(t, err)
+	tokens, err := auth.New(auth.Config{Tokens: []auth.TokenConfig{
+		{Name: "agent-a", SHA256: auth.Hash("a"), Scopes: []auth.Scope{auth.ScopeRead, auth.ScopeWrite}, Tenant: "team-a"},
+		{Name: "ops", SHA256: auth.Hash("ops"), Scopes: []auth.Scope{auth.ScopeAdmin}},
+	}})
+	require.NoError(t, err)
+
+	rt := testRoutes(t)
+	rt.tenants = tenants
+	rt.tokens = tokens
+	mux, err := newRouter(rt)
+	require.NoError(t, err)
+
+	serve := func(method, target, token, tenantID, body string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		if body != "" {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+		if tenantID != "" {
+			req.Header.Set(tenant.Header, tenantID)
+		}
+		mux.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// Each tenant counts its own PollCount
+	require.Equal(t, http.StatusOK, serve(http.MethodPost, "/update/counter/PollCount/2", "a", "", "").Code)
+	require.Equal(t, http.StatusOK, serve(http.MethodPost, "/update/counter/PollCount/5", "ops", "team-b", "").Code)
+	require.Equal(t, http.StatusOK, serve(http.MethodPost, "/update/counter/PollCount/7", "ops", "", "").Code)
+
+	assert.Equal(t, "2", serve(http.MethodGet, "/value/counter/PollCount", "a", "", "").Body.String())
+	assert.Equal(t, "5", serve(http.MethodGet, "/value/counter/PollCount", "ops", "team-b", "").Body.String())
+	assert.Equal(t, "7", serve(http.MethodGet, "/value/counter/PollCount", "ops", "", "").Body.String())
+
+	// The token of team-a cannot reach team-b
+	rr := serve(http.MethodGet, "/value/counter/PollCount", "a", "team-b", "")
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+
+	// team-a has room for one more metric, the batch would create two
+	rr = serve(http.MethodPost, "/api/v1/metrics", "a", "",
+		`[{"id": "Alloc", "type": "gauge", "value": 1}, {"id": "Heap", "type": "gauge", "value": 2}]`)
+	require.Equal(t, http.StatusForbidden, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"code":"quota_exceeded"`)
+	assert.Equal(t, http.StatusNotFound, serve(http.MethodGet, "/value/gauge/Alloc", "a", "", "").Code, "nothing of the batch is stored")
+
+	require.Equal(t, http.StatusOK, serve(http.MethodPost, "/update/gauge/Alloc/1", "a", "", "").Code)
+	assert.Equal(t, http.StatusForbidden, serve(http.MethodPost, "/update/gauge/Heap/1", "a", "", "").Code)
+	assert.Equal(t, http.StatusOK, serve(http.MethodPost, "/update/gauge/Alloc/2", "a", "", "").Code, "existing metrics still update")
+
+	// Silences and alerts are shared, a tenant's token cannot see or change them
+	assert.Equal(t, http.StatusForbidden, serve(http.MethodGet, "/api/silences", "a", "", "").Code)
+	assert.Equal(t, http.StatusForbidden, serve(http.MethodPost, "/api/alerts/receiver", "a", "", `{}`).Code)
+	assert.Equal(t, http.StatusBadRequest, serve(http.MethodGet, "/api/silences", "ops", "team-b", "").Code)
+	assert.Equal(t, http.StatusOK, serve(http.MethodGet, "/api/silences", "ops", "", "").Code)
+}
+
+func TestRouterAuditsUpdates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	sink, err := audit.NewFileSink(path, 0, 0)
+	require.NoError(t, err)
+
+	rt := testRoutes(t)
+	rt.audit = audit.New(sink)
+	mux, err := newRouter(rt)
+	require.NoError(t, err)
+
+	for _, req := range []*http.Request{
+		httptest.NewRequest(http.MethodPost, "/update/counter/Polls/2", nil),
+		httptest.NewRequest(http.MethodGet, "/value/counter/Polls", nil),
+		httptest.NewRequest(http.MethodPost, "/update/counter/Polls/none", nil),
+		httptest.NewRequest(http.MethodDelete, "/api/v1/metrics/counter/Polls", nil),
+	} {
+		mux.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	rt.audit.Run(ctx)
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	require.Len(t, lines, 2, "reads and rejected updates are not audited")
+	assert.Contains(t, lines[0], `"action":"update"`)
+	assert.Contains(t, lines[0], `"delta":2`)
+	assert.Contains(t, lines[1], `"action":"delete"`)
+}
+
+func TestLegacyRoutesAreAliases(t *testing.T) {
+	mux, err := newRouter(testRoutes(t))
+	require.NoError(t, err)
+
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/update/counter/Polls/2", nil))
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "true", rr.Header().Get("Deprecation"))
+	assert.Equal(t, `</api/v1/metrics/counter/Polls>; rel="successor-version"`, rr.Header().Get("Link"))
+
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, httptest.NewRequest(http.MethodPut, "/api/v1/metrics/counter/Polls", strings.NewReader(`{"delta": 3}`)))
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Empty(t, rr.Header().Get("Deprecation"))
+
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/value/counter/Polls", nil))
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "5", rr.Body.String())
+
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/value/", strings.NewReader(`{"id": "Polls", "type": "counter"}`)))
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, `</api/v1/metrics>; rel="successor-version"`, rr.Header().Get("Link"))
+}
@@ -1,57 +1,362 @@
 package appserver
 
 import (
+	"context"
+	"crypto/rsa"
+	"errors"
 	"fmt"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
 
-	"Vova4o/metrix/internal/handlers"
+	"Vova4o/metrix/internal/audit"
+	"Vova4o/metrix/internal/auth"
+	"Vova4o/metrix/internal/certs"
+	"Vova4o/metrix/internal/encryption"
+	"Vova4o/metrix/internal/health"
 	"Vova4o/metrix/internal/logger"
 	mw "Vova4o/metrix/internal/middleware"
+	"Vova4o/metrix/internal/notifier"
+	"Vova4o/metrix/internal/rules"
 	"Vova4o/metrix/internal/serverflags"
 	"Vova4o/metrix/internal/storage"
-
-	"github.com/go-chi/chi/middleware"
-	"github.com/go-chi/chi/v5"
+	"Vova4o/metrix/internal/tenant"
 )
 
+// NewServer runs the server until it gets SIGINT or SIGTERM
+// and then shuts it down gracefully
 func NewServer() error {
-	// Create a new router
-	mux := chi.NewRouter()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	return Run(ctx)
+}
 
-	tempFile := "metrix.page.tmpl"
+// Run serves requests until ctx is done. It then stops taking new
+// connections, lets the requests in flight finish within the shutdown
+// timeout and saves a final snapshot of the metrics
+func Run(ctx context.Context) error {
+	// Background workers stop after the server, before the final snapshot.
+	// Their context is not derived from ctx, so the requests still finishing
+	// during the shutdown are audited and their rules evaluated
+	workerCtx, stopWorkers := context.WithCancel(context.Background())
+	var workers sync.WaitGroup
+	defer workers.Wait()
+	defer stopWorkers()
 
 	// Create a new MemStorage
 	memStorager := storage.NewMemStorage()
 
+	var fileStorage *storage.FileStorage
 	if serverflags.GetFileStoragePath() != "" {
-		fileStorage, err := storage.NewFileStorage(memStorager, serverflags.GetStoreInterval(), serverflags.GetFileStoragePath(), serverflags.GetRestore())
+		var err error
+		fileStorage, err = storage.NewFileStorage(memStorager, serverflags.GetStoreInterval(), serverflags.GetFileStoragePath(), serverflags.GetRestore())
 		if err != nil {
 			err = fmt.Errorf("failed to create new file storage: %v", err)
 			logger.Log.WithError(err).Error("Failed to create new file storage")
 			return err
 		}
-		defer fileStorage.SaveToFile() // Save metrics to file on exit
+		// Saves the metrics if the server fails to start,
+		// a graceful shutdown closes it before returning
+		defer fileStorage.Close()
 	} else {
 		fmt.Println("Not using file storage")
 		logger.Log.Info("Not using file storage")
 	}
 
-	mux.Use(mw.RequestLogger)
-	mux.Use(mw.GzipMiddleware)
-	// mux.Use(middleware.Logger)
-	mux.Use(middleware.Recoverer)
+	// Handlers work with the history wrapper, the file storage keeps
+	// saving the plain metrics underneath it
+	historyStorage := storage.NewHistoryStorage(memStorager, time.Duration(serverflags.GetHistoryRetention())*time.Second)
+
+	var notifierConfig notifier.Config
+	if serverflags.GetNotifierConfig() != "" {
+		var err error
+		notifierConfig, err = notifier.LoadConfig(serverflags.GetNotifierConfig())
+		if err != nil {
+			logger.Log.WithError(err).Error("Failed to load notifier config")
+			return err
+		}
+	}
+
+	// Silences and maintenance windows mute notifications, not evaluation
+	silencer, err := notifier.NewSilencer(notifierConfig.MaintenanceWindows)
+	if err != nil {
+		logger.Log.WithError(err).Error("Failed to create silencer")
+		return err
+	}
+	if fileStorage != nil {
+		if err := fileStorage.Attach("Silences", silencer); err != nil {
+			logger.Log.WithError(err).Error("Failed to restore silences")
+			return err
+		}
+	}
+
+	// The receiver is only useful as a webhook of a configured notifier
+	var receiver *notifier.Receiver
+	if serverflags.GetNotifierConfig() != "" {
+		alertNotifier, err := notifier.New(notifierConfig, silencer)
+		if err != nil {
+			logger.Log.WithError(err).Error("Failed to create alert notifier")
+			return err
+		}
+		evaluator, err := notifier.NewEvaluator(notifierConfig, historyStorage, historyStorage, alertNotifier)
+		if err != nil {
+			logger.Log.WithError(err).Error("Failed to create alert evaluator")
+			return err
+		}
+		receiver = notifier.NewReceiver()
+
+		workers.Add(2)
+		go func() {
+			defer workers.Done()
+			alertNotifier.Run(workerCtx)
+		}()
+		go func() {
+			defer workers.Done()
+			evaluator.Run(workerCtx)
+		}()
+	}
+
+	var ruleManager *rules.Manager
+	if serverflags.GetRulesConfig() != "" {
+		rulesConfig, err := rules.LoadConfig(serverflags.GetRulesConfig())
+		if err != nil {
+			logger.Log.WithError(err).Error("Failed to load recording rules")
+			return err
+		}
+		ruleManager, err = rules.New(rulesConfig, historyStorage, historyStorage)
+		if err != nil {
+			logger.Log.WithError(err).Error("Failed to create recording rules")
+			return err
+		}
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			ruleManager.Run(workerCtx)
+		}()
+	}
 
-	// Add the handlers to the router
-	mux.Post("/update/{metricType}/{metricName}/{metricValue}", handlers.HandleUpdateText(memStorager))
-	mux.Post("/update/", handlers.HandleUpdateJSON(memStorager))
+	// Readiness follows the snapshot file, the only dependency for now
+	checker := health.New()
+	if fileStorage != nil {
+		checker.Add("storage_writable", fileStorage.CheckWritable)
+		checker.Add("last_snapshot", fileStorage.CheckLastSave)
+	}
+
+	var privateKey *rsa.PrivateKey
+	if serverflags.GetCryptoKey() != "" {
+		privateKey, err = encryption.LoadPrivateKey(serverflags.GetCryptoKey())
+		if err != nil {
+			logger.Log.WithError(err).Error("Failed to load crypto key")
+			return err
+		}
+		logger.Log.WithField("fingerprint", encryption.Fingerprint(&privateKey.PublicKey)).Info("Decrypting agent payloads")
+	}
 
-	mux.Get("/", handlers.ShowMetrics(memStorager, tempFile))
+	var tenants *tenant.Registry
+	if serverflags.GetTenantsConfig() != "" {
+		tenantsConfig, err := tenant.LoadConfig(serverflags.GetTenantsConfig())
+		if err != nil {
+			logger.Log.WithError(err).Error("Failed to load tenants")
+			return err
+		}
+		tenants, err = tenant.New(tenantsConfig, tenant.Options{
+			FileStoragePath:  serverflags.GetFileStoragePath(),
+			StoreInterval:    serverflags.GetStoreInterval(),
+			Restore:          serverflags.GetRestore(),
+			HistoryRetention: time.Duration(serverflags.GetHistoryRetention()) * time.Second,
+		})
+		if err != nil {
+			logger.Log.WithError(err).Error("Failed to open tenant storages")
+			return err
+		}
+		// Saves the tenants' metrics if the server fails to start
+		defer tenants.Close()
 
-	mux.Get("/value/{metricType}/{metricName}", handlers.MetricValue(memStorager))
-	mux.Post("/value/", handlers.MetricValueJSON(memStorager))
+		for name, check := range tenants.Checks() {
+			checker.Add(name, check)
+		}
+		logger.Log.WithField("tenants", len(tenantsConfig.Tenants)).Info("Serving tenants")
+	}
+
+	var tokens *auth.Tokens
+	if serverflags.GetTokensConfig() != "" {
+		tokensConfig, err := auth.LoadConfig(serverflags.GetTokensConfig())
+		if err != nil {
+			logger.Log.WithError(err).Error("Failed to load API tokens")
+			return err
+		}
+		for _, tc := range tokensConfig.Tokens {
+			if tc.Tenant == "" {
+				continue
+			}
+			if tenants == nil {
+				err = fmt.Errorf("token %s belongs to tenant %s, but no tenants are configured", tc.Name, tc.Tenant)
+			} else if _, ok := tenants.Get(tc.Tenant); !ok {
+				err = fmt.Errorf("token %s belongs to unknown tenant %s", tc.Name, tc.Tenant)
+			}
+			if err != nil {
+				logger.Log.WithError(err).Error("Failed to load API tokens")
+				return err
+			}
+		}
+		tokens, err = auth.New(tokensConfig)
+		if err != nil {
+			logger.Log.WithError(err).Error("Failed to load API tokens")
+			return err
+		}
+		logger.Log.WithField("tokens", len(tokensConfig.Tokens)).Info("Requiring API tokens")
+	}
+
+	var auditLog *audit.Logger
+	if serverflags.GetAuditFile() != "" || serverflags.GetAuditURL() != "" {
+		var sinks []audit.Sink
+		if path := serverflags.GetAuditFile(); path != "" {
+			fileSink, err := audit.NewFileSink(path, int64(serverflags.GetAuditFileMaxSize())<<20, serverflags.GetAuditFileMaxBackups())
+			if err != nil {
+				logger.Log.WithError(err).Error("Failed to open audit file")
+				return err
+			}
+			sinks = append(sinks, fileSink)
+		}
+		if url := serverflags.GetAuditURL(); url != "" {
+			sinks = append(sinks, audit.NewHTTPSink(url, 10*time.Second))
+		}
+
+		// The worker is stopped only once srv.Shutdown returns, so the
+		// updates finishing during the shutdown are written too
+		auditLog = audit.New(sinks...)
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			auditLog.Run(workerCtx)
+		}()
+	}
+
+	var trustedSubnet *net.IPNet
+	if serverflags.GetTrustedSubnet() != "" {
+		_, trustedSubnet, err = net.ParseCIDR(serverflags.GetTrustedSubnet())
+		if err != nil {
+			err = fmt.Errorf("invalid trusted subnet: %w", err)
+			logger.Log.WithError(err).Error("Failed to parse trusted subnet")
+			return err
+		}
+		logger.Log.WithField("subnet", trustedSubnet.String()).Info("Taking updates from the trusted subnet only")
+	}
+
+	mux, err := newRouter(routes{
+		storage:  historyStorage,
+		silencer: silencer,
+		receiver: receiver, // локальный приёмник для проверки маршрутов уведомлений
+		rules:    ruleManager,
+		health:   checker,
+		compress: mw.CompressConfig{
+			Level:   serverflags.GetCompressionLevel(),
+			MinSize: serverflags.GetCompressionMinSize(),
+		},
+		key:        serverflags.GetKey(),
+		privateKey: privateKey,
+
+		trustedSubnet: trustedSubnet,
+		restrictReads: serverflags.GetTrustedSubnetReads(),
+
+		tokens:  tokens,
+		tenants: tenants,
+		audit:   auditLog,
+	})
+	if err != nil {
+		logger.Log.WithError(err).Error("Failed to create router")
+		return err
+	}
 
 	fmt.Printf("Starting server on %s\n", serverflags.GetServerAddress())
 
+	// Streams only end when their request context is done, so they get one
+	// that is cancelled as soon as the shutdown starts
+	baseCtx, cancelRequests := context.WithCancel(context.Background())
+	defer cancelRequests()
+	srv := &http.Server{
+		Addr:        serverflags.GetServerAddress(),
+		Handler:     mux,
+		BaseContext: func(net.Listener) context.Context { return baseCtx },
+	}
+	srv.RegisterOnShutdown(cancelRequests)
+
+	var tlsStore *certs.Store
+	if serverflags.GetTLSCert() != "" || serverflags.GetTLSKey() != "" {
+		tlsStore, err = certs.Load(certs.Files{
+			Cert: serverflags.GetTLSCert(),
+			Key:  serverflags.GetTLSKey(),
+			CA:   serverflags.GetTLSClientCA(),
+		})
+		if err != nil {
+			logger.Log.WithError(err).Error("Failed to load TLS certificates")
+			return err
+		}
+		srv.TLSConfig = tlsStore.ServerConfig()
+
+		// Renewed certificates are served without a restart
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			if err := tlsStore.Watch(workerCtx); err != nil {
+				logger.Log.WithError(err).Warn("Certificates will not be reloaded")
+			}
+		}()
+	} else if serverflags.GetTLSClientCA() != "" {
+		err := errors.New("mutual TLS needs the server certificate and key")
+		logger.Log.WithError(err).Error("Failed to load TLS certificates")
+		return err
+	}
+
 	// Start the server
-	return http.ListenAndServe(serverflags.GetServerAddress(), mux)
+	serveErr := make(chan error, 1)
+	go func() {
+		if tlsStore != nil {
+			serveErr <- srv.ListenAndServeTLS("", "")
+			return
+		}
+		serveErr <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serveErr:
+		logger.Log.WithError(err).Error("Server failed")
+		return err
+	case <-ctx.Done():
+	}
+
+	timeout := time.Duration(serverflags.GetShutdownTimeout()) * time.Second
+	logger.Log.WithField("timeout", timeout.String()).Info("Shutting down")
+	checker.Drain()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		// Requests still running after the timeout are cut off
+		logger.Log.WithError(err).Warn("Requests did not finish in time")
+		srv.Close()
+	}
+
+	stopWorkers()
+	workers.Wait()
+
+	if tenants != nil {
+		if err := tenants.Close(); err != nil {
+			logger.Log.WithError(err).Error("Failed to save the final tenant snapshots")
+			return fmt.Errorf("failed to save the final tenant snapshots: %w", err)
+		}
+	}
+	if fileStorage != nil {
+		if err := fileStorage.Close(); err != nil {
+			logger.Log.WithError(err).Error("Failed to save the final snapshot")
+			return fmt.Errorf("failed to save the final snapshot: %w", err)
+		}
+		logger.Log.Info("Saved the final snapshot")
+	}
+	return nil
 }
@@ -1,14 +1,24 @@
 package appserver
 
 import (
+	"context"
 	"errors"
+	"io"
 	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"syscall"
 	"testing"
 	"time"
 
+	"Vova4o/metrix/internal/logger"
+
 	"github.com/sirupsen/logrus"
 	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/spf13/viper"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 // TestServer tests the NewServer function
@@ -82,3 +92,146 @@ func TestFileStorage(t *testing.T) {
 		}
 	})
 }
+
+// setFlags overrides server settings for one test
+func setFlags(t *testing.T, values map[string]interface{}) {
+	t.Helper()
+	for key, value := range values {
+		previous := viper.Get(key)
+		viper.Set(key, value)
+		t.Cleanup(func() { viper.Set(key, previous) })
+	}
+}
+
+// freeAddr returns a local address nothing listens on
+func freeAddr(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	return l.Addr().String()
+}
+
+// waitReady waits until the server at addr answers its readiness probe
+func waitReady(t *testing.T, addr string) {
+	t.Helper()
+	require.Eventually(t, func() bool {
+		resp, err := http.Get("http://" + addr + "/readyz")
+		if err != nil {
+			return false
+		}
+		resp.Body.Close()
+		return resp.StatusCode == http.StatusOK
+	}, 5*time.Second, 20*time.Millisecond, "server did not become ready")
+}
+
+// TestNewServer_SIGTERM checks that metrics written right before SIGTERM
+// are in the final snapshot and come back after a restart, even though
+// the periodic snapshot never ran
+func TestNewServer_SIGTERM(t *testing.T) {
+	_ = logger.New("test.log")
+
+	addr := freeAddr(t)
+	setFlags(t, map[string]interface{}{
+		"ServerAddress":   addr,
+		"FileStoragePath": filepath.Join(t.TempDir(), "metrics-db.json"),
+		"Restore":         true,
+		"StoreInterval":   3600,
+		"ShutdownTimeout": 5,
+	})
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- NewServer()
+	}()
+	waitReady(t, addr)
+
+	resp, err := http.Post("http://"+addr+"/update/gauge/Alloc/42.5", "text/plain", nil)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	process, err := os.FindProcess(os.Getpid())
+	require.NoError(t, err)
+	require.NoError(t, process.Signal(syscall.SIGTERM))
+
+	select {
+	case err := <-errCh:
+		require.NoError(t, err)
+	case <-time.After(10 * time.Second):
+		t.Fatal("server did not shut down")
+	}
+
+	// Restart from the snapshot
+	ctx, cancel := context.WithCancel(context.Background())
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- Run(ctx)
+	}()
+	waitReady(t, addr)
+
+	resp, err = http.Get("http://" + addr + "/value/gauge/Alloc")
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "42.5", string(body))
+
+	cancel()
+	require.NoError(t, <-runErr)
+}
+
+// TestRun_AuditsUpdatesDuringShutdown checks that an update still running
+// when the shutdown starts is written to the audit file
+func TestRun_AuditsUpdatesDuringShutdown(t *testing.T) {
+	_ = logger.New("test.log")
+
+	addr := freeAddr(t)
+	auditPath := filepath.Join(t.TempDir(), "audit.jsonl")
+	setFlags(t, map[string]interface{}{
+		"ServerAddress":   addr,
+		"FileStoragePath": filepath.Join(t.TempDir(), "metrics-db.json"),
+		"AuditFile":       auditPath,
+		"ShutdownTimeout": 5,
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- Run(ctx)
+	}()
+	waitReady(t, addr)
+
+	// The body is sent in two parts, so the request is still running when
+	// the shutdown starts
+	body, send := io.Pipe()
+	status := make(chan int, 1)
+	go func() {
+		resp, err := http.Post("http://"+addr+"/update/", "application/json", body)
+		if err != nil {
+			t.Errorf("update failed: %v", err)
+			status <- 0
+			return
+		}
+		resp.Body.Close()
+		status <- resp.StatusCode
+	}()
+	_, err := send.Write([]byte(`{"id":"Alloc",`))
+	require.NoError(t, err)
+	time.Sleep(100 * time.Millisecond)
+
+	cancel()
+	time.Sleep(100 * time.Millisecond)
+	_, err = send.Write([]byte(`"type":"gauge","value":42.5}`))
+	require.NoError(t, err)
+	require.NoError(t, send.Close())
+
+	assert.Equal(t, http.StatusOK, <-status)
+	require.NoError(t, <-runErr)
+
+	events, err := os.ReadFile(auditPath)
+	require.NoError(t, err)
+	assert.Contains(t, string(events), `"id":"Alloc"`)
+}
@@ -0,0 +1,154 @@
+// Package audit records who changed which metrics and when. Events are
+// queued in memory and written to the sinks by background workers, so
+// recording never waits for a file or a remote service
+package audit
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"Vova4o/metrix/internal/handlers"
+	"Vova4o/metrix/internal/logger"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// queueSize is how many events may wait for a sink, more are dropped
+	queueSize = 4096
+	// batchSize caps the events written to the sinks at once
+	batchSize = 100
+	// flushInterval is how long an event may wait for its batch to fill up
+	flushInterval = time.Second
+)
+
+// Event is one successful change of metrics
+type Event struct {
+	Time     time.Time              `json:"time"`
+	Action   string                 `json:"action"`  // update или delete
+	Metrics  []handlers.MetricsJSON `json:"metrics"` // значения в том виде, в каком их прислали
+	ClientIP string                 `json:"client_ip"`
+	AgentID  string                 `json:"agent_id,omitempty"`
+	Tenant   string                 `json:"tenant,omitempty"`
+	Token    string                 `json:"token,omitempty"` // имя токена, не сам токен
+}
+
+// Sink stores the events. Write is only called by the worker of the
+// sink, one batch at a time
+type Sink interface {
+	Write(events []Event) error
+	Close() error
+}
+
+// Logger queues events for its sinks. Every sink has its own queue and
+// worker, so a slow remote service does not hold back the file
+type Logger struct {
+	queues []*sinkQueue
+}
+
+// sinkQueue holds the events waiting for one sink
+type sinkQueue struct {
+	sink    Sink
+	events  chan Event
+	dropped atomic.Int64
+}
+
+// New creates a Logger that writes to the sinks once Run is started
+func New(sinks ...Sink) *Logger {
+	l := &Logger{}
+	for _, sink := range sinks {
+		l.queues = append(l.queues, &sinkQueue{sink: sink, events: make(chan Event, queueSize)})
+	}
+	return l
+}
+
+// Record queues an event for every sink without blocking. When a sink
+// falls behind and its queue is full the event is dropped for that sink
+// and counted
+func (l *Logger) Record(e Event) {
+	for _, q := range l.queues {
+		select {
+		case q.events <- e:
+		default:
+			q.dropped.Add(1)
+		}
+	}
+}
+
+// Run writes the queued events in batches until ctx is cancelled, then
+// writes what is still queued and closes the sinks
+func (l *Logger) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, q := range l.queues {
+		wg.Add(1)
+		go func(q *sinkQueue) {
+			defer wg.Done()
+			q.run(ctx)
+		}(q)
+	}
+	wg.Wait()
+}
+
+func (q *sinkQueue) run(ctx context.Context) {
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]Event, 0, batchSize)
+	for {
+		select {
+		case e := <-q.events:
+			batch = append(batch, e)
+			if len(batch) < batchSize {
+				continue
+			}
+		case <-ticker.C:
+		case <-ctx.Done():
+			q.drain(batch)
+			return
+		}
+		batch = q.write(batch)
+	}
+}
+
+// drain writes the batch and the events still queued, then closes the sink
+func (q *sinkQueue) drain(batch []Event) {
+	for {
+		select {
+		case e := <-q.events:
+			batch = append(batch, e)
+			if len(batch) == batchSize {
+				batch = q.write(batch)
+			}
+		default:
+			q.write(batch)
+			if err := q.sink.Close(); err != nil {
+				logger.Log.WithError(err).WithField("sink", fmt.Sprintf("%T", q.sink)).Error("Failed to close audit sink")
+			}
+			return
+		}
+	}
+}
+
+// write hands the batch to the sink and returns it emptied
+func (q *sinkQueue) write(batch []Event) []Event {
+	if dropped := q.dropped.Swap(0); dropped > 0 {
+		logger.Log.WithFields(logrus.Fields{
+			"sink":    fmt.Sprintf("%T", q.sink),
+			"dropped": dropped,
+		}).Warn("Audit queue was full, events were dropped")
+	}
+	if len(batch) == 0 {
+		return batch
+	}
+
+	if err := q.sink.Write(batch); err != nil {
+		logger.Log.WithError(err).WithFields(logrus.Fields{
+			"sink":   fmt.Sprintf("%T", q.sink),
+			"events": len(batch),
+		}).Error("Failed to write audit events")
+	}
+	return batch[:0]
+}
@@ -0,0 +1,154 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"Vova4o/metrix/internal/logger"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// memorySink keeps the batches it was given
+type memorySink struct {
+	mu      sync.Mutex
+	batches [][]Event
+	closed  bool
+}
+
+func (s *memorySink) Write(events []Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.batches = append(s.batches, append([]Event(nil), events...))
+	return nil
+}
+
+func (s *memorySink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	return nil
+}
+
+func (s *memorySink) events() []Event {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var all []Event
+	for _, batch := range s.batches {
+		all = append(all, batch...)
+	}
+	return all
+}
+
+func TestLogger_WritesOnShutdown(t *testing.T) {
+	_ = logger.New("test.log")
+	sink := &memorySink{}
+	l := New(sink)
+
+	for i := 0; i < 2*batchSize+5; i++ {
+		l.Record(Event{AgentID: "agent", ClientIP: "10.0.0.1"})
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	l.Run(ctx)
+
+	assert.Len(t, sink.events(), 2*batchSize+5)
+	for _, batch := range sink.batches {
+		assert.LessOrEqual(t, len(batch), batchSize)
+	}
+	assert.True(t, sink.closed)
+}
+
+func TestLogger_WritesWhileRunning(t *testing.T) {
+	_ = logger.New("test.log")
+	sink := &memorySink{}
+	l := New(sink)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		l.Run(ctx)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	l.Record(Event{Action: "update"})
+	assert.Eventually(t, func() bool { return len(sink.events()) == 1 }, 3*flushInterval, 10*time.Millisecond)
+}
+
+func TestLogger_DropsWhenFull(t *testing.T) {
+	_ = logger.New("test.log")
+	l := New(&memorySink{})
+
+	start := time.Now()
+	for i := 0; i < queueSize+10; i++ {
+		l.Record(Event{})
+	}
+	assert.Less(t, time.Since(start), time.Second, "Record does not wait for the worker")
+	assert.Equal(t, int64(10), l.queues[0].dropped.Load())
+}
+
+// blockingSink does not return from Write until it is released
+type blockingSink struct {
+	release chan struct{}
+}
+
+func (s *blockingSink) Write([]Event) error {
+	<-s.release
+	return nil
+}
+
+func (s *blockingSink) Close() error { return nil }
+
+func TestLogger_SlowSinkDoesNotHoldBackOthers(t *testing.T) {
+	_ = logger.New("test.log")
+	slow := &blockingSink{release: make(chan struct{})}
+	fast := &memorySink{}
+	l := New(slow, fast)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		l.Run(ctx)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		close(slow.release)
+		cancel()
+		<-done
+	})
+
+	l.Record(Event{Action: "update"})
+	l.Record(Event{Action: "delete"})
+	assert.Eventually(t, func() bool { return len(fast.events()) == 2 }, 3*flushInterval, 10*time.Millisecond)
+}
+
+func TestHTTPSink(t *testing.T) {
+	var got []Event
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&got))
+		if got[0].AgentID == "fail" {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	sink := NewHTTPSink(server.URL, time.Second)
+	require.NoError(t, sink.Write([]Event{{Action: "update", AgentID: "agent-1"}, {Action: "delete"}}))
+	require.Len(t, got, 2)
+	assert.Equal(t, "agent-1", got[0].AgentID)
+
+	assert.Error(t, sink.Write([]Event{{AgentID: "fail"}}))
+	assert.NoError(t, sink.Close())
+}
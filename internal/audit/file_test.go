@@ -0,0 +1,90 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// readEvents decodes a JSON lines file
+func readEvents(t *testing.T, path string) []Event {
+	t.Helper()
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+
+	var events []Event
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var e Event
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &e))
+		events = append(events, e)
+	}
+	require.NoError(t, scanner.Err())
+	return events
+}
+
+func TestFileSink_Appends(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+
+	sink, err := NewFileSink(path, 0, 0)
+	require.NoError(t, err)
+	require.NoError(t, sink.Write([]Event{{AgentID: "a"}, {AgentID: "b"}}))
+	require.NoError(t, sink.Close())
+
+	// A restarted server keeps appending
+	sink, err = NewFileSink(path, 0, 0)
+	require.NoError(t, err)
+	require.NoError(t, sink.Write([]Event{{AgentID: "c"}}))
+	require.NoError(t, sink.Close())
+
+	events := readEvents(t, path)
+	require.Len(t, events, 3)
+	assert.Equal(t, "c", events[2].AgentID)
+}
+
+func TestFileSink_Rotates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	line, err := json.Marshal(Event{AgentID: "0"})
+	require.NoError(t, err)
+
+	// Room for two events per file
+	sink, err := NewFileSink(path, int64(2*(len(line)+1)), 2)
+	require.NoError(t, err)
+	for _, id := range []string{"1", "2", "3", "4", "5", "6", "7"} {
+		require.NoError(t, sink.Write([]Event{{AgentID: id}}))
+	}
+	require.NoError(t, sink.Close())
+
+	ids := func(path string) []string {
+		var ids []string
+		for _, e := range readEvents(t, path) {
+			ids = append(ids, e.AgentID)
+		}
+		return ids
+	}
+	assert.Equal(t, []string{"7"}, ids(path))
+	assert.Equal(t, []string{"5", "6"}, ids(path+".1"))
+	assert.Equal(t, []string{"3", "4"}, ids(path+".2"))
+	_, err = os.Stat(path + ".3")
+	assert.True(t, os.IsNotExist(err), "older files are removed")
+}
+
+func TestFileSink_BatchLargerThanLimit(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+
+	// A batch goes into one file even when it is larger than the limit
+	sink, err := NewFileSink(path, 10, 1)
+	require.NoError(t, err)
+	require.NoError(t, sink.Write([]Event{{AgentID: "a"}, {AgentID: "b"}}))
+	require.NoError(t, sink.Write([]Event{{AgentID: "c"}}))
+	require.NoError(t, sink.Close())
+
+	assert.Len(t, readEvents(t, path+".1"), 2)
+	assert.Len(t, readEvents(t, path), 1)
+}
@@ -0,0 +1,54 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// HTTPSink posts each batch of events to a URL as a JSON array. A batch
+// that fails is logged and not retried, a FileSink keeps the full record
+type HTTPSink struct {
+	url    string
+	client *http.Client
+}
+
+// NewHTTPSink posts to url, each batch within timeout
+func NewHTTPSink(url string, timeout time.Duration) *HTTPSink {
+	return &HTTPSink{url: url, client: &http.Client{Timeout: timeout}}
+}
+
+// Write implements Sink
+func (s *HTTPSink) Write(events []Event) error {
+	body, err := json.Marshal(events)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("audit sink returned non-2xx status: %s", resp.Status)
+	}
+	return nil
+}
+
+// Close implements Sink
+func (s *HTTPSink) Close() error {
+	s.client.CloseIdleConnections()
+	return nil
+}
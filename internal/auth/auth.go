@@ -0,0 +1,146 @@
+// Package auth checks the bearer tokens of API clients. Tokens are listed
+// in a JSON config by their SHA-256, so the file does not hold a secret
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+)
+
+// Scope is what a token lets its holder do
+type Scope string
+
+const (
+	ScopeRead  Scope = "read"  // чтение метрик, UI и потоки
+	ScopeWrite Scope = "write" // обновление метрик, его выдают агентам
+	ScopeAdmin Scope = "admin" // удаление метрик и управление тишинами, включает остальные
+)
+
+// Config lists the tokens the server accepts
+type Config struct {
+	Tokens []TokenConfig `json:"tokens"`
+}
+
+// TokenConfig describes one token. The token itself is not stored,
+// only the hex SHA-256 of it, see Hash
+type TokenConfig struct {
+	Name   string  `json:"name"`
+	SHA256 string  `json:"sha256"`
+	Scopes []Scope `json:"scopes"`
+	Tenant string  `json:"tenant,omitempty"` // пустой — арендатора выбирает заголовок
+}
+
+// Token is the identity a request was authenticated as
+type Token struct {
+	Name   string
+	Scopes []Scope
+	Tenant string
+}
+
+// Allows reports whether the token grants scope. Admin grants every scope
+func (t *Token) Allows(scope Scope) bool {
+	return slices.Contains(t.Scopes, scope) || slices.Contains(t.Scopes, ScopeAdmin)
+}
+
+// Tokens finds the token a request presents
+type Tokens struct {
+	byHash map[[sha256.Size]byte]*Token
+}
+
+// LoadConfig reads the tokens from a JSON file
+func LoadConfig(path string) (Config, error) {
+	var cfg Config
+
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, fmt.Errorf("failed to read tokens config %s: %w", path, err)
+	}
+	if err := json.Unmarshal(contents, &cfg); err != nil {
+		return cfg, fmt.Errorf("failed to parse tokens config %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// New checks the config. It fails on malformed hashes, unknown scopes
+// and names or hashes given twice
+func New(cfg Config) (*Tokens, error) {
+	t := &Tokens{byHash: make(map[[sha256.Size]byte]*Token, len(cfg.Tokens))}
+	names := make(map[string]bool, len(cfg.Tokens))
+
+	var errs []error
+	for i, tc := range cfg.Tokens {
+		if tc.Name == "" {
+			errs = append(errs, fmt.Errorf("token %d: name is required", i))
+			continue
+		}
+		if names[tc.Name] {
+			errs = append(errs, fmt.Errorf("token %s: name is used twice", tc.Name))
+			continue
+		}
+		names[tc.Name] = true
+
+		var sum [sha256.Size]byte
+		if len(tc.SHA256) != hex.EncodedLen(sha256.Size) {
+			errs = append(errs, fmt.Errorf("token %s: sha256 must be %d hex digits", tc.Name, hex.EncodedLen(sha256.Size)))
+			continue
+		}
+		if _, err := hex.Decode(sum[:], []byte(tc.SHA256)); err != nil {
+			errs = append(errs, fmt.Errorf("token %s: sha256 is not hex: %w", tc.Name, err))
+			continue
+		}
+		if _, ok := t.byHash[sum]; ok {
+			errs = append(errs, fmt.Errorf("token %s: the same token is listed twice", tc.Name))
+			continue
+		}
+
+		if len(tc.Scopes) == 0 {
+			errs = append(errs, fmt.Errorf("token %s: at least one scope is required", tc.Name))
+			continue
+		}
+		for _, scope := range tc.Scopes {
+			switch scope {
+			case ScopeRead, ScopeWrite, ScopeAdmin:
+			default:
+				errs = append(errs, fmt.Errorf("token %s: unknown scope %q", tc.Name, scope))
+			}
+		}
+
+		t.byHash[sum] = &Token{Name: tc.Name, Scopes: slices.Clone(tc.Scopes), Tenant: tc.Tenant}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// Lookup returns the token with the given secret. Only its hash is
+// compared, so the lookup time does not depend on the secret
+func (t *Tokens) Lookup(secret string) (*Token, bool) {
+	token, ok := t.byHash[sha256.Sum256([]byte(secret))]
+	return token, ok
+}
+
+// Hash returns what goes into the sha256 field of a token's config
+func Hash(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+type contextKey struct{}
+
+// WithToken returns a copy of ctx that carries the authenticated token
+func WithToken(ctx context.Context, token *Token) context.Context {
+	return context.WithValue(ctx, contextKey{}, token)
+}
+
+// FromContext returns the token a request was authenticated as
+func FromContext(ctx context.Context) (*Token, bool) {
+	token, ok := ctx.Value(contextKey{}).(*Token)
+	return token, ok
+}
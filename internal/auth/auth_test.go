@@ -0,0 +1,94 @@
+package auth
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHash(t *testing.T) {
+	// echo -n secret | sha256sum
+	assert.Equal(t, "2bb80d537b1da3e38bd30361aa855686bde0eacd7162fef6a25fe97bf527a25b", Hash("secret"))
+}
+
+func TestNew(t *testing.T) {
+	valid := TokenConfig{Name: "agent", SHA256: Hash("agent-secret"), Scopes: []Scope{ScopeWrite}}
+
+	tests := []struct {
+		name   string
+		tokens []TokenConfig
+		err    string
+	}{
+		{"valid", []TokenConfig{valid, {Name: "dashboard", SHA256: strings.ToUpper(Hash("d")), Scopes: []Scope{ScopeRead}}}, ""},
+		{"no name", []TokenConfig{{SHA256: Hash("x"), Scopes: []Scope{ScopeRead}}}, "name is required"},
+		{"same name", []TokenConfig{valid, {Name: "agent", SHA256: Hash("x"), Scopes: []Scope{ScopeRead}}}, "name is used twice"},
+		{"same token", []TokenConfig{valid, {Name: "other", SHA256: valid.SHA256, Scopes: []Scope{ScopeRead}}}, "listed twice"},
+		{"short hash", []TokenConfig{{Name: "a", SHA256: "abc", Scopes: []Scope{ScopeRead}}}, "64 hex digits"},
+		{"long hash", []TokenConfig{{Name: "a", SHA256: Hash("x") + "00", Scopes: []Scope{ScopeRead}}}, "64 hex digits"},
+		{"not hex", []TokenConfig{{Name: "a", SHA256: strings.Repeat("z", 64), Scopes: []Scope{ScopeRead}}}, "not hex"},
+		{"no scopes", []TokenConfig{{Name: "a", SHA256: Hash("x")}}, "at least one scope"},
+		{"unknown scope", []TokenConfig{{Name: "a", SHA256: Hash("x"), Scopes: []Scope{"delete"}}}, `unknown scope "delete"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tokens, err := New(Config{Tokens: tt.tokens})
+			if tt.err != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.NotNil(t, tokens)
+		})
+	}
+}
+
+func TestLookup(t *testing.T) {
+	tokens, err := New(Config{Tokens: []TokenConfig{
+		{Name: "dashboard", SHA256: Hash("read-secret"), Scopes: []Scope{ScopeRead}, Tenant: "team-a"},
+		{Name: "ops", SHA256: Hash("admin-secret"), Scopes: []Scope{ScopeAdmin}},
+	}})
+	require.NoError(t, err)
+
+	token, ok := tokens.Lookup("read-secret")
+	require.True(t, ok)
+	assert.Equal(t, "dashboard", token.Name)
+	assert.Equal(t, "team-a", token.Tenant)
+	assert.True(t, token.Allows(ScopeRead))
+	assert.False(t, token.Allows(ScopeWrite))
+
+	token, ok = tokens.Lookup("admin-secret")
+	require.True(t, ok)
+	assert.True(t, token.Allows(ScopeRead))
+	assert.True(t, token.Allows(ScopeWrite))
+	assert.True(t, token.Allows(ScopeAdmin))
+
+	_, ok = tokens.Lookup(Hash("read-secret"))
+	assert.False(t, ok, "the hash itself is not a token")
+	_, ok = tokens.Lookup("")
+	assert.False(t, ok)
+}
+
+func TestLoadConfig(t *testing.T) {
+	dir := t.TempDir()
+
+	path := filepath.Join(dir, "tokens.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"tokens": [{"name": "agent", "sha256": "`+Hash("s")+`", "scopes": ["write"]}]}`), 0o600))
+	cfg, err := LoadConfig(path)
+	require.NoError(t, err)
+	require.Len(t, cfg.Tokens, 1)
+	assert.Equal(t, []Scope{ScopeWrite}, cfg.Tokens[0].Scopes)
+
+	_, err = LoadConfig(filepath.Join(dir, "missing.json"))
+	assert.Error(t, err)
+
+	broken := filepath.Join(dir, "broken.json")
+	require.NoError(t, os.WriteFile(broken, []byte(`{"tokens": `), 0o600))
+	_, err = LoadConfig(broken)
+	assert.Error(t, err)
+}
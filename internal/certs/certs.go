@@ -0,0 +1,171 @@
+// Package certs loads the TLS certificates of the server and the agent
+// and reloads them when the files change, so renewed certificates are
+// picked up without a restart
+package certs
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+
+	"Vova4o/metrix/internal/logger"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// Files are the PEM files of one side of a connection. Cert and Key are
+// its own certificate, CA the bundle it verifies the other side with
+type Files struct {
+	Cert string
+	Key  string
+	CA   string
+}
+
+// Store holds the certificates loaded from Files
+type Store struct {
+	files Files
+	cert  atomic.Pointer[tls.Certificate]
+	pool  atomic.Pointer[x509.CertPool]
+}
+
+// Load reads the files. Cert and Key go together, either may be left out
+// with the other, and so may CA
+func Load(files Files) (*Store, error) {
+	if (files.Cert == "") != (files.Key == "") {
+		return nil, errors.New("the certificate and its key must be given together")
+	}
+	s := &Store{files: files}
+	if err := s.Reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Reload reads the files again. On error the certificates loaded before
+// stay in use
+func (s *Store) Reload() error {
+	var cert *tls.Certificate
+	if s.files.Cert != "" {
+		pair, err := tls.LoadX509KeyPair(s.files.Cert, s.files.Key)
+		if err != nil {
+			return fmt.Errorf("failed to load certificate: %w", err)
+		}
+		cert = &pair
+	}
+
+	var pool *x509.CertPool
+	if s.files.CA != "" {
+		data, err := os.ReadFile(s.files.CA)
+		if err != nil {
+			return fmt.Errorf("failed to load CA bundle: %w", err)
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return fmt.Errorf("failed to load CA bundle: no certificates in %s", s.files.CA)
+		}
+	}
+
+	s.cert.Store(cert)
+	s.pool.Store(pool)
+	return nil
+}
+
+// Watch reloads the certificates whenever one of the files changes, until
+// ctx is done. The directories are watched rather than the files, as
+// certificates are usually renewed by replacing the file
+func (s *Store) Watch(ctx context.Context) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+
+	watched := make(map[string]bool)
+	for _, file := range []string{s.files.Cert, s.files.Key, s.files.CA} {
+		if file == "" {
+			continue
+		}
+		watched[filepath.Clean(file)] = true
+		if err := watcher.Add(filepath.Dir(file)); err != nil {
+			return err
+		}
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			if !watched[filepath.Clean(event.Name)] || event.Has(fsnotify.Chmod) {
+				continue
+			}
+			// The certificate and the key may be replaced one after the
+			// other, until both are there the pair does not match
+			if err := s.Reload(); err != nil {
+				logger.Log.WithError(err).Warn("Keeping the previous certificates")
+				continue
+			}
+			logger.Log.WithField("file", event.Name).Info("Reloaded certificates")
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			logger.Log.WithError(err).Warn("Certificate watcher failed")
+		}
+	}
+}
+
+// Certificate returns the certificate loaded last, nil without one
+func (s *Store) Certificate() *tls.Certificate {
+	return s.cert.Load()
+}
+
+// ServerConfig serves the certificate and, with a CA bundle, requires
+// clients to present a certificate signed by it (mutual TLS)
+func (s *Store) ServerConfig() *tls.Config {
+	config := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			cert := s.cert.Load()
+			if cert == nil {
+				return nil, errors.New("no server certificate")
+			}
+			return cert, nil
+		},
+	}
+	if s.files.CA != "" {
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+		// Every handshake gets the CA bundle loaded last
+		config.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			current := config.Clone()
+			current.GetConfigForClient = nil
+			current.ClientCAs = s.pool.Load()
+			return current, nil
+		}
+	}
+	return config
+}
+
+// ClientConfig verifies the server with the CA bundle, or the system
+// roots without one, and presents the certificate when the server asks.
+// The CA bundle is read once, the client certificate follows the files
+func (s *Store) ClientConfig() *tls.Config {
+	config := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		RootCAs:    s.pool.Load(),
+	}
+	if s.files.Cert != "" {
+		config.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return s.cert.Load(), nil
+		}
+	}
+	return config
+}
@@ -0,0 +1,201 @@
+package certs
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"Vova4o/metrix/internal/logger"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// authority signs test certificates
+type authority struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newAuthority(t *testing.T) *authority {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "metrix test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return &authority{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue returns the PEM certificate and key of a leaf valid for 127.0.0.1
+func (a *authority) issue(t *testing.T, serial int64, usage x509.ExtKeyUsage) (certPEM, keyPEM []byte) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "metrix"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, a.cert, &key.PublicKey, a.key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func writeFile(t *testing.T, dir, name string, data []byte) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	require.NoError(t, os.WriteFile(path, data, 0o600))
+	return path
+}
+
+func TestLoad(t *testing.T) {
+	ca := newAuthority(t)
+	certPEM, keyPEM := ca.issue(t, 2, x509.ExtKeyUsageServerAuth)
+	dir := t.TempDir()
+	cert := writeFile(t, dir, "cert.pem", certPEM)
+	key := writeFile(t, dir, "key.pem", keyPEM)
+	bundle := writeFile(t, dir, "ca.pem", ca.pem)
+
+	tests := []struct {
+		name    string
+		files   Files
+		wantErr bool
+	}{
+		{"pair and CA", Files{Cert: cert, Key: key, CA: bundle}, false},
+		{"CA only", Files{CA: bundle}, false},
+		{"cert without key", Files{Cert: cert}, true},
+		{"swapped", Files{Cert: key, Key: cert}, true},
+		{"missing CA", Files{CA: filepath.Join(dir, "missing.pem")}, true},
+		{"CA without certificates", Files{CA: key}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Load(tt.files)
+			assert.Equal(t, tt.wantErr, err != nil, "error %v", err)
+		})
+	}
+}
+
+func TestMutualTLS(t *testing.T) {
+	_ = logger.New("test.log")
+	ca := newAuthority(t)
+	dir := t.TempDir()
+	bundle := writeFile(t, dir, "ca.pem", ca.pem)
+
+	serverCert, serverKey := ca.issue(t, 2, x509.ExtKeyUsageServerAuth)
+	serverStore, err := Load(Files{
+		Cert: writeFile(t, dir, "server.pem", serverCert),
+		Key:  writeFile(t, dir, "server-key.pem", serverKey),
+		CA:   bundle,
+	})
+	require.NoError(t, err)
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.TLS.PeerCertificates[0].Subject.CommonName))
+	}))
+	server.TLS = serverStore.ServerConfig()
+	server.StartTLS()
+	defer server.Close()
+
+	get := func(files Files) (*http.Response, error) {
+		store, err := Load(files)
+		require.NoError(t, err)
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: store.ClientConfig()}}
+		return client.Get(server.URL)
+	}
+
+	clientCert, clientKey := ca.issue(t, 3, x509.ExtKeyUsageClientAuth)
+	resp, err := get(Files{
+		Cert: writeFile(t, dir, "client.pem", clientCert),
+		Key:  writeFile(t, dir, "client-key.pem", clientKey),
+		CA:   bundle,
+	})
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// Without a client certificate the handshake fails
+	_, err = get(Files{CA: bundle})
+	assert.Error(t, err)
+
+	// Without the CA the server is not trusted
+	_, err = get(Files{})
+	assert.Error(t, err)
+}
+
+func TestWatch(t *testing.T) {
+	_ = logger.New("test.log")
+	ca := newAuthority(t)
+	dir := t.TempDir()
+	certPEM, keyPEM := ca.issue(t, 2, x509.ExtKeyUsageServerAuth)
+	files := Files{
+		Cert: writeFile(t, dir, "cert.pem", certPEM),
+		Key:  writeFile(t, dir, "key.pem", keyPEM),
+	}
+	store, err := Load(files)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- store.Watch(ctx) }()
+	// Give the watcher time to start
+	time.Sleep(50 * time.Millisecond)
+
+	// A broken pair in the middle of the renewal keeps the old certificate
+	renewedCert, renewedKey := ca.issue(t, 4, x509.ExtKeyUsageServerAuth)
+	writeFile(t, dir, "cert.pem", renewedCert)
+	time.Sleep(50 * time.Millisecond)
+	require.NotNil(t, store.Certificate())
+	writeFile(t, dir, "key.pem", renewedKey)
+
+	block, _ := pem.Decode(renewedCert)
+	require.Eventually(t, func() bool {
+		return bytes.Equal(store.Certificate().Certificate[0], block.Bytes)
+	}, 5*time.Second, 10*time.Millisecond, "certificate was not reloaded")
+
+	cancel()
+	assert.NoError(t, <-done)
+}
+
+func TestClientConfig_NoCertificate(t *testing.T) {
+	store, err := Load(Files{})
+	require.NoError(t, err)
+	config := store.ClientConfig()
+	assert.Nil(t, config.RootCAs)
+	assert.Nil(t, config.GetClientCertificate)
+	assert.Equal(t, uint16(tls.VersionTLS12), config.MinVersion)
+}
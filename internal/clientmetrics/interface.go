@@ -1,6 +1,7 @@
 package clientmetrics
 
 import (
+	"context"
 	"sync"
 	"time"
 
@@ -14,7 +15,7 @@ type Metric struct {
 }
 
 type MetricSender interface {
-	SendMetric(client *resty.Client, metricType, metricName, metricValue, baseURL string) error
+	SendMetric(ctx context.Context, client *resty.Client, metricType, metricName, metricValue, baseURL string) error
 }
 
 type TextMetricSender struct{}
@@ -30,7 +31,7 @@ type JSONMetricSender struct{}
 
 type MetricsClient interface {
 	PollMetrics() error
-	ReportMetrics(baseURL string) error
+	ReportMetrics(ctx context.Context, baseURL string) error
 }
 
 type Metrics struct {
@@ -1,6 +1,7 @@
 package clientmetrics
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"math/rand"
@@ -70,10 +71,12 @@ func (ma *Metrics) PollMetrics() error {
 	return nil
 }
 
-func (ma *Metrics) ReportMetrics(baseURL string) error {
+// ReportMetrics sends every metric as text and as JSON. Cancelling ctx
+// aborts the requests in flight; it returns once every sender has exited
+func (ma *Metrics) ReportMetrics(ctx context.Context, baseURL string) error {
 	ma.mu.Lock()
 	defer ma.mu.Unlock()
-	
+
 	if ma.GaugeMetrics == nil {
 		return errors.New("random value is nil")
 	}
@@ -95,11 +98,11 @@ func (ma *Metrics) ReportMetrics(baseURL string) error {
 
 	reportMetric := func(metricType, name, value string) {
 		defer wg.Done()
-		if err := ma.TextSender.SendMetric(ma.Client, metricType, name, value, baseURL); err != nil {
-			logger.Log.Errorf("error sending %s metric %s: %v", metricType, name, err)
+		if err := ma.TextSender.SendMetric(ctx, ma.Client, metricType, name, value, baseURL); err != nil {
+			errs <- fmt.Errorf("error sending %s metric %s: %w", metricType, name, err)
 		}
-		if err := ma.JSONSender.SendMetric(ma.Client, metricType, name, value, baseURL); err != nil {
-			logger.Log.Errorf("error sending %s metric %s: %v", metricType, name, err)
+		if err := ma.JSONSender.SendMetric(ctx, ma.Client, metricType, name, value, baseURL); err != nil {
+			errs <- fmt.Errorf("error sending %s metric %s: %w", metricType, name, err)
 		}
 	}
 
@@ -118,9 +121,22 @@ func (ma *Metrics) ReportMetrics(baseURL string) error {
 		close(errs)
 	}()
 
+	// Every failed send is logged, the caller learns that the report was incomplete
+	var failed []error
 	for err := range errs {
-		logger.Log.Println(err)
+		logger.Log.Error(err)
+		failed = append(failed, err)
 	}
 
-	return nil
+	return errors.Join(failed...)
+}
+
+// Stop stops the poll and report tickers
+func (ma *Metrics) Stop() {
+	if ma.PollTicker != nil {
+		ma.PollTicker.Stop()
+	}
+	if ma.ReportTicker != nil {
+		ma.ReportTicker.Stop()
+	}
 }
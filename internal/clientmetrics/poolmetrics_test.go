@@ -1,7 +1,12 @@
 package clientmetrics
 
 import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/go-resty/resty/v2"
 )
@@ -63,8 +68,18 @@ func TestMetrics_PollMetrics(t *testing.T) {
 
 func TestReportMetrics(t *testing.T) {
 	setup()
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if strings.HasPrefix(req.URL.Path, "/error") {
+			rw.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		rw.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
 	tests := []struct {
 		name           string
+		path           string
 		gaugeMetrics   map[string]float64
 		counterMetrics map[string]int64
 		client         *resty.Client
@@ -82,6 +97,16 @@ func TestReportMetrics(t *testing.T) {
 			senderJSON:     &JSONMetricSender{},
 			wantErr:        false,
 		},
+		{
+			name:           "Server Error",
+			path:           "/error",
+			gaugeMetrics:   map[string]float64{"test": 1.0},
+			counterMetrics: map[string]int64{"Poll": 1},
+			client:         resty.New(),
+			senderText:     &TextMetricSender{},
+			senderJSON:     &JSONMetricSender{},
+			wantErr:        true,
+		},
 		{
 			name:           "Nil GaugeMetrics",
 			gaugeMetrics:   nil,
@@ -139,9 +164,32 @@ func TestReportMetrics(t *testing.T) {
 				JSONSender:     tt.senderJSON,
 			}
 
-			if err := ma.ReportMetrics("http://localhost"); (err != nil) != tt.wantErr {
+			if err := ma.ReportMetrics(context.Background(), server.URL+tt.path); (err != nil) != tt.wantErr {
 				t.Errorf("ReportMetrics() error = %v, wantErr %v", err, tt.wantErr)
 			}
 		})
 	}
 }
+
+func TestMetrics_Stop(t *testing.T) {
+	m := &Metrics{
+		PollTicker:   time.NewTicker(time.Millisecond),
+		ReportTicker: time.NewTicker(time.Millisecond),
+	}
+	m.Stop()
+
+	// A stopped ticker may still hold one tick, but sends no more
+	time.Sleep(10 * time.Millisecond)
+	select {
+	case <-m.PollTicker.C:
+	default:
+	}
+	select {
+	case <-m.PollTicker.C:
+		t.Errorf("PollTicker ticks after Stop()")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	// Metrics without tickers can be stopped too
+	(&Metrics{}).Stop()
+}
@@ -1,15 +1,21 @@
 package clientmetrics
 
 import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
 
 	"Vova4o/metrix/internal/logger"
+	"Vova4o/metrix/internal/signature"
 
 	"github.com/go-resty/resty/v2"
+	"github.com/spf13/viper"
 )
 
 type MockRestClient struct {
@@ -152,7 +158,7 @@ func TestSendMetric(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			err := tt.sender.SendMetric(tt.client.client, tt.metrixtype, tt.metrixname, tt.value, tt.url)
+			err := tt.sender.SendMetric(context.Background(), tt.client.client, tt.metrixtype, tt.metrixname, tt.value, tt.url)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("SendMetric() error = %v, wantErr %v", err, tt.wantErr)
 			}
@@ -193,10 +199,89 @@ func TestJSONMetricSender_SendMetric(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			sender := &JSONMetricSender{}
 			client := resty.New()
-			err := sender.SendMetric(client, tt.metricType, tt.metricName, tt.metricValue, tt.url)
+			err := sender.SendMetric(context.Background(), client, tt.metricType, tt.metricName, tt.metricValue, tt.url)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("SendMetric() error = %v, wantErr %v", err, tt.wantErr)
 			}
 		})
 	}
 }
+
+func TestJSONMetricSender_CompressesBody(t *testing.T) {
+	setup()
+	var got MetricsJSON
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("Content-Encoding") != "gzip" {
+			t.Errorf("Content-Encoding = %q, want gzip", req.Header.Get("Content-Encoding"))
+		}
+		gz, err := gzip.NewReader(req.Body)
+		if err != nil {
+			t.Errorf("body is not gzip: %v", err)
+			rw.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if err := json.NewDecoder(gz).Decode(&got); err != nil {
+			t.Errorf("body is not JSON: %v", err)
+		}
+		rw.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	sender := &JSONMetricSender{}
+	if err := sender.SendMetric(context.Background(), resty.New(), "counter", "PollCount", "3", server.URL); err != nil {
+		t.Fatalf("SendMetric() error = %v", err)
+	}
+	if got.ID != "PollCount" || got.MType != "counter" || got.Delta == nil || *got.Delta != 3 {
+		t.Errorf("server got %+v, want PollCount counter with delta 3", got)
+	}
+}
+
+func TestSendMetric_Signed(t *testing.T) {
+	setup()
+	previous := viper.Get("Key")
+	viper.Set("Key", "secret")
+	defer viper.Set("Key", previous)
+
+	// The server checks the signature of the uncompressed body and signs
+	// its answer with responseKey
+	newServer := func(responseKey string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			gz, err := gzip.NewReader(req.Body)
+			if err != nil {
+				rw.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			body, _ := io.ReadAll(gz)
+			if !signature.Valid([]byte("secret"), body, req.Header.Get(signature.Header)) {
+				t.Errorf("request to %s is not signed", req.URL.Path)
+				rw.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			rw.Header().Set(signature.Header, signature.Sum([]byte(responseKey), []byte("ok")))
+			rw.Write([]byte("ok"))
+		}))
+	}
+
+	tests := []struct {
+		name        string
+		responseKey string
+		wantErr     bool
+	}{
+		{"signed response", "secret", false},
+		{"forged response", "other", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newServer(tt.responseKey)
+			defer server.Close()
+
+			for _, sender := range []MetricSender{&TextMetricSender{}, &JSONMetricSender{}} {
+				err := sender.SendMetric(context.Background(), resty.New(), "gauge", "Alloc", "1.5", server.URL)
+				if (err != nil) != tt.wantErr {
+					t.Errorf("%T.SendMetric() error = %v, wantErr %v", sender, err, tt.wantErr)
+				}
+			}
+		})
+	}
+}
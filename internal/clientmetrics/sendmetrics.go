@@ -3,6 +3,7 @@ package clientmetrics
 import (
 	"bytes"
 	"compress/gzip"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -11,24 +12,26 @@ import (
 	"strconv"
 	"strings"
 
+	"Vova4o/metrix/internal/agentflags"
 	"Vova4o/metrix/internal/logger"
+	"Vova4o/metrix/internal/signature"
 
 	"github.com/go-resty/resty/v2"
 )
 
-func (t *TextMetricSender) SendMetric(client *resty.Client, metricType, metricName, metricValue, baseURL string) error {
+func (t *TextMetricSender) SendMetric(ctx context.Context, client *resty.Client, metricType, metricName, metricValue, baseURL string) error {
 	if client == nil {
 		return errors.New("client is nil")
 	}
 
-	if !strings.HasPrefix(baseURL, "http://") {
-		baseURL = "http://" + baseURL
-	}
+	baseURL = withScheme(baseURL)
 
 	req := client.R().
+		SetContext(ctx).
 		SetHeader("Content-Type", "text/plain").
 		SetHeader("Accept-Encoding", "gzip").
 		SetBody(metricValue)
+	signRequest(req, []byte(metricValue))
 
 	if strings.Contains(req.Header.Get("Accept-Encoding"), "gzip") {
 		var b bytes.Buffer
@@ -44,11 +47,7 @@ func (t *TextMetricSender) SendMetric(client *resty.Client, metricType, metricNa
 		req.SetHeader("Content-Encoding", "gzip")
 	}
 
-	// fmt.Printf("Request Headers: TEXT %v\n", req.Header)
-
-	resp, err := client.R().
-		SetHeader("Content-Type", "text/plain").
-		Post(fmt.Sprintf("%s/update/%s/%s/%s", baseURL, metricType, metricName, metricValue))
+	resp, err := req.Post(fmt.Sprintf("%s/update/%s/%s/%s", baseURL, metricType, metricName, metricValue))
 	if err != nil {
 		logger.Log.WithError(err).Errorf("failed to send %s metric %s", metricType, metricName)
 		return err
@@ -60,10 +59,16 @@ func (t *TextMetricSender) SendMetric(client *resty.Client, metricType, metricNa
 		return err
 	}
 
+	if err := verifyResponse(resp); err != nil {
+		err = fmt.Errorf("%s metric %s: %w", metricType, metricName, err)
+		logger.Log.Error(err)
+		return err
+	}
+
 	return nil
 }
 
-func (j *JSONMetricSender) SendMetric(client *resty.Client, metricType, metricName, metricValue, baseURL string) error {
+func (j *JSONMetricSender) SendMetric(ctx context.Context, client *resty.Client, metricType, metricName, metricValue, baseURL string) error {
 	var delta *int64
 	var value *float64
 
@@ -97,9 +102,7 @@ func (j *JSONMetricSender) SendMetric(client *resty.Client, metricType, metricNa
 		return errors.New("client is nil")
 	}
 
-	if !strings.HasPrefix(baseURL, "http://") {
-		baseURL = "http://" + baseURL
-	}
+	baseURL = withScheme(baseURL)
 
 	jsonDate, err := json.MarshalIndent(metric, "", "  ")
 	// this is how the error idealy should look like.
@@ -110,9 +113,11 @@ func (j *JSONMetricSender) SendMetric(client *resty.Client, metricType, metricNa
 	}
 
 	req := client.R().
+		SetContext(ctx).
 		SetHeader("Content-Type", "application/json").
 		SetHeader("Accept-Encoding", "gzip").
 		SetBody(jsonDate)
+	signRequest(req, jsonDate)
 
 	if strings.Contains(req.Header.Get("Accept-Encoding"), "gzip") {
 		var b bytes.Buffer
@@ -130,12 +135,7 @@ func (j *JSONMetricSender) SendMetric(client *resty.Client, metricType, metricNa
 		req.SetBody(jsonDate)
 	}
 
-	// fmt.Printf("Request Headers: JSON %v\n", req.Header)
-
-	resp, err := client.R().
-		SetHeader("Content-Type", "application/json").
-		Post(fmt.Sprintf("%s/update/", baseURL))
-		// this is how it maigh look like
+	resp, err := req.Post(fmt.Sprintf("%s/update/", baseURL))
 	if err != nil {
 		logger.Log.Errorf("failed to send %s metric %s: %v", metricType, metricName, err)
 		return fmt.Errorf("failed to send %s metric %s: %v", metricType, metricName, err)
@@ -146,6 +146,44 @@ func (j *JSONMetricSender) SendMetric(client *resty.Client, metricType, metricNa
 		return fmt.Errorf("server returned non-OK status for %s metric %s: %v", metricType, metricName, resp.Status())
 	}
 
+	if err := verifyResponse(resp); err != nil {
+		logger.Log.Errorf("%s metric %s: %v", metricType, metricName, err)
+		return fmt.Errorf("%s metric %s: %w", metricType, metricName, err)
+	}
+
+	return nil
+}
+
+// withScheme adds http:// to a server address without a scheme,
+// http:// and https:// URLs are kept as they are
+func withScheme(baseURL string) string {
+	if strings.HasPrefix(baseURL, "http://") || strings.HasPrefix(baseURL, "https://") {
+		return baseURL
+	}
+	return "http://" + baseURL
+}
+
+// signRequest signs the uncompressed body when the agent has a key
+func signRequest(req *resty.Request, body []byte) {
+	if key := agentflags.GetKey(); key != "" {
+		req.SetHeader(signature.Header, signature.Sum([]byte(key), body))
+	}
+}
+
+// verifyResponse checks the signature of a response when the agent has a key.
+// resty has already decompressed the body
+func verifyResponse(resp *resty.Response) error {
+	key := agentflags.GetKey()
+	if key == "" {
+		return nil
+	}
+	sum := resp.Header().Get(signature.Header)
+	if sum == "" {
+		return errors.New("response is not signed")
+	}
+	if !signature.Valid([]byte(key), resp.Body(), sum) {
+		return errors.New("response signature does not match the body")
+	}
 	return nil
 }
 
@@ -0,0 +1,190 @@
+// Package encryption encrypts the payloads the agent sends for the RSA key
+// of the server. Bodies are sealed with a fresh AES-256-GCM key, which is
+// encrypted with RSA-OAEP, so bodies of any size fit
+package encryption
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+)
+
+const (
+	// Header marks an encrypted request body
+	Header = "X-Encryption"
+	// Scheme is the value of Header for this package's envelopes
+	Scheme = "rsa-oaep-aes-256-gcm"
+)
+
+// version is the first byte of every envelope
+const version = 1
+
+// fingerprintSize is the length of the key fingerprint in an envelope
+const fingerprintSize = 8
+
+var (
+	// ErrMalformed reports data that is not an envelope of this package
+	ErrMalformed = errors.New("encrypted payload is malformed")
+	// ErrKeyMismatch reports an envelope encrypted for another key
+	ErrKeyMismatch = errors.New("payload is encrypted for another key")
+	// ErrCorrupt reports an envelope changed after it was sealed
+	ErrCorrupt = errors.New("encrypted payload is corrupt")
+)
+
+// Envelope layout:
+//
+//	version (1) | key fingerprint (8) | wrapped key length (2) | wrapped key | nonce (12) | ciphertext
+
+// Encrypt seals plaintext for the holder of the private half of key
+func Encrypt(key *rsa.PublicKey, plaintext []byte) ([]byte, error) {
+	aesKey := make([]byte, 32)
+	if _, err := rand.Read(aesKey); err != nil {
+		return nil, err
+	}
+	wrapped, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, key, aesKey, nil)
+	if err != nil {
+		return nil, fmt.Errorf("wrap key: %w", err)
+	}
+
+	gcm, err := newGCM(aesKey)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	var b bytes.Buffer
+	b.WriteByte(version)
+	b.Write(fingerprint(key))
+	binary.Write(&b, binary.BigEndian, uint16(len(wrapped)))
+	b.Write(wrapped)
+	b.Write(nonce)
+	// The header is authenticated too, nothing in the envelope can be swapped
+	return gcm.Seal(b.Bytes(), nonce, plaintext, b.Bytes()), nil
+}
+
+// Decrypt opens an envelope made by Encrypt
+func Decrypt(key *rsa.PrivateKey, data []byte) ([]byte, error) {
+	const fixed = 1 + fingerprintSize + 2
+	if len(data) < fixed || data[0] != version {
+		return nil, ErrMalformed
+	}
+	if got, want := data[1:1+fingerprintSize], fingerprint(&key.PublicKey); !bytes.Equal(got, want) {
+		return nil, fmt.Errorf("%w: payload key %s, server key %s", ErrKeyMismatch, hex.EncodeToString(got), hex.EncodeToString(want))
+	}
+
+	wrappedLen := int(binary.BigEndian.Uint16(data[1+fingerprintSize:]))
+	if len(data) < fixed+wrappedLen {
+		return nil, ErrMalformed
+	}
+	aesKey, err := rsa.DecryptOAEP(sha256.New(), nil, key, data[fixed:fixed+wrappedLen], nil)
+	if err != nil {
+		return nil, ErrKeyMismatch
+	}
+
+	gcm, err := newGCM(aesKey)
+	if err != nil {
+		return nil, err
+	}
+	headerLen := fixed + wrappedLen + gcm.NonceSize()
+	if len(data) < headerLen+gcm.Overhead() {
+		return nil, ErrMalformed
+	}
+	plaintext, err := gcm.Open(nil, data[fixed+wrappedLen:headerLen], data[headerLen:], data[:headerLen])
+	if err != nil {
+		return nil, ErrCorrupt
+	}
+	return plaintext, nil
+}
+
+// Fingerprint identifies a key in logs and errors
+func Fingerprint(key *rsa.PublicKey) string {
+	return hex.EncodeToString(fingerprint(key))
+}
+
+func fingerprint(key *rsa.PublicKey) []byte {
+	sum := sha256.Sum256(x509.MarshalPKCS1PublicKey(key))
+	return sum[:fingerprintSize]
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// LoadPublicKey reads a PEM encoded RSA public key, either PKIX
+// ("PUBLIC KEY") or PKCS #1 ("RSA PUBLIC KEY")
+func LoadPublicKey(path string) (*rsa.PublicKey, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch block.Type {
+	case "RSA PUBLIC KEY":
+		return x509.ParsePKCS1PublicKey(block.Bytes)
+	case "PUBLIC KEY":
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		rsaKey, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("%s: %T is not an RSA key", path, key)
+		}
+		return rsaKey, nil
+	}
+	return nil, fmt.Errorf("%s: %q is not a public key", path, block.Type)
+}
+
+// LoadPrivateKey reads a PEM encoded RSA private key, either PKCS #1
+// ("RSA PRIVATE KEY") or PKCS #8 ("PRIVATE KEY")
+func LoadPrivateKey(path string) (*rsa.PrivateKey, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		rsaKey, ok := key.(*rsa.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("%s: %T is not an RSA key", path, key)
+		}
+		return rsaKey, nil
+	}
+	return nil, fmt.Errorf("%s: %q is not a private key", path, block.Type)
+}
+
+func readPEM(path string) (*pem.Block, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s: no PEM data", path)
+	}
+	return block, nil
+}
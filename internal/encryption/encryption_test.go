@@ -0,0 +1,108 @@
+package encryption
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func generateKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	return key
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	key := generateKey(t)
+
+	for _, size := range []int{0, 10, 1 << 20} {
+		plaintext := bytes.Repeat([]byte("a"), size)
+		data, err := Encrypt(&key.PublicKey, plaintext)
+		require.NoError(t, err)
+		assert.NotContains(t, string(data), "aaaaaaaa")
+
+		got, err := Decrypt(key, data)
+		require.NoError(t, err)
+		assert.Equal(t, string(plaintext), string(got))
+	}
+}
+
+func TestDecrypt_Errors(t *testing.T) {
+	key := generateKey(t)
+	data, err := Encrypt(&key.PublicKey, []byte(`{"id":"Alloc"}`))
+	require.NoError(t, err)
+
+	t.Run("other key", func(t *testing.T) {
+		_, err := Decrypt(generateKey(t), data)
+		assert.ErrorIs(t, err, ErrKeyMismatch)
+		assert.Contains(t, err.Error(), Fingerprint(&key.PublicKey))
+	})
+
+	t.Run("tampered", func(t *testing.T) {
+		tampered := bytes.Clone(data)
+		tampered[len(tampered)-1] ^= 1
+		_, err := Decrypt(key, tampered)
+		assert.ErrorIs(t, err, ErrCorrupt)
+	})
+
+	t.Run("truncated", func(t *testing.T) {
+		for _, n := range []int{0, 5, 20, 280} {
+			_, err := Decrypt(key, data[:n])
+			assert.ErrorIs(t, err, ErrMalformed, "%d bytes", n)
+		}
+	})
+
+	t.Run("not an envelope", func(t *testing.T) {
+		_, err := Decrypt(key, []byte(`{"id":"Alloc"}`))
+		assert.ErrorIs(t, err, ErrMalformed)
+	})
+}
+
+func writePEM(t *testing.T, blockType string, der []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "key.pem")
+	require.NoError(t, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0o600))
+	return path
+}
+
+func TestLoadKeys(t *testing.T) {
+	key := generateKey(t)
+	pkix, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	require.NoError(t, err)
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+
+	for _, path := range []string{
+		writePEM(t, "PUBLIC KEY", pkix),
+		writePEM(t, "RSA PUBLIC KEY", x509.MarshalPKCS1PublicKey(&key.PublicKey)),
+	} {
+		pub, err := LoadPublicKey(path)
+		require.NoError(t, err)
+		assert.True(t, key.PublicKey.Equal(pub))
+	}
+
+	for _, path := range []string{
+		writePEM(t, "PRIVATE KEY", pkcs8),
+		writePEM(t, "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(key)),
+	} {
+		priv, err := LoadPrivateKey(path)
+		require.NoError(t, err)
+		assert.True(t, key.Equal(priv))
+	}
+
+	_, err = LoadPublicKey(writePEM(t, "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(key)))
+	assert.Error(t, err)
+	_, err = LoadPrivateKey(writePEM(t, "PUBLIC KEY", pkix))
+	assert.Error(t, err)
+	_, err = LoadPublicKey(filepath.Join(t.TempDir(), "missing.pem"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,63 @@
+package expr
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"Vova4o/metrix/internal/handlers"
+)
+
+// QueryResult is the answer of the query endpoint
+type QueryResult struct {
+	Expr    string  `json:"expr"`
+	Type    string  `json:"type"`
+	Value   *Number `json:"value,omitempty"`
+	Samples *Vector `json:"samples,omitempty"`
+}
+
+// HandleQuery is an HTTP handler that evaluates the expression
+// in the expr query parameter, e.g. /api/query?expr=HeapInuse / HeapSys
+func HandleQuery(s handlers.Storager, h handlers.Historian) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		input := r.URL.Query().Get("expr")
+
+		node, err := Parse(input)
+		if err != nil {
+			problem := handlers.NewProblem(http.StatusBadRequest, handlers.CodeInvalidValue, err.Error())
+			var parseErr *ParseError
+			if errors.As(err, &parseErr) {
+				problem.AtParameter("expr", fmt.Sprintf("%s (at position %d)", parseErr.Msg, parseErr.Pos))
+			}
+			handlers.WriteProblem(w, r, problem)
+			return
+		}
+
+		value, err := Eval(node, Env{Storage: s, History: h, Now: time.Now()})
+		if err != nil {
+			handlers.WriteProblem(w, r, handlers.NewProblem(http.StatusUnprocessableEntity, handlers.CodeInvalidValue, err.Error()).
+				AtParameter("expr", "cannot be evaluated"))
+			return
+		}
+
+		result := QueryResult{Expr: node.String(), Type: value.Type()}
+		switch v := value.(type) {
+		case Scalar:
+			n := Number(v)
+			result.Value = &n
+		case Vector:
+			if v == nil {
+				v = Vector{}
+			}
+			result.Samples = &v
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(result); err != nil {
+			log.Printf("Failed to encode query result: %v", err)
+		}
+	}
+}
@@ -0,0 +1,282 @@
+package expr
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"Vova4o/metrix/internal/handlers"
+)
+
+// Value is the result of evaluating an expression: a Scalar or a Vector
+type Value interface {
+	Type() string
+}
+
+// Scalar is a single number
+type Scalar float64
+
+// Vector is a set of metrics, one sample each
+type Vector []Sample
+
+// Sample is the value of one metric, or of an operation on metrics
+type Sample struct {
+	Name   string            `json:"name,omitempty"`
+	MType  string            `json:"type,omitempty"`
+	Labels map[string]string `json:"labels,omitempty"`
+	Value  Number            `json:"value"`
+}
+
+// Number is a float that is written to JSON as a string when it
+// is NaN or infinite, since JSON numbers cannot hold those
+type Number float64
+
+func (Scalar) Type() string { return "scalar" }
+func (Vector) Type() string { return "vector" }
+
+func (n Number) MarshalJSON() ([]byte, error) {
+	f := float64(n)
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return []byte(strconv.Quote(strconv.FormatFloat(f, 'g', -1, 64))), nil
+	}
+	return []byte(strconv.FormatFloat(f, 'g', -1, 64)), nil
+}
+
+// Env is what an expression is evaluated against
+type Env struct {
+	Storage handlers.Storager
+	History handlers.Historian // может быть nil, тогда rate() недоступна
+	Now     time.Time
+}
+
+// Eval computes the value of a parsed expression
+func Eval(n Node, env Env) (Value, error) {
+	switch n := n.(type) {
+	case *NumberLiteral:
+		return Scalar(n.Value), nil
+
+	case *ParenExpr:
+		return Eval(n.Expr, env)
+
+	case *Selector:
+		return selectSamples(n, env), nil
+
+	case *UnaryExpr:
+		v, err := Eval(n.Expr, env)
+		if err != nil {
+			return nil, err
+		}
+		switch v := v.(type) {
+		case Scalar:
+			return -v, nil
+		case Vector:
+			result := make(Vector, len(v))
+			for i, s := range v {
+				result[i] = Sample{Labels: s.Labels, Value: -s.Value}
+			}
+			return result, nil
+		}
+
+	case *BinaryExpr:
+		left, err := Eval(n.Left, env)
+		if err != nil {
+			return nil, err
+		}
+		right, err := Eval(n.Right, env)
+		if err != nil {
+			return nil, err
+		}
+		return binary(n.Op, left, right)
+
+	case *Call:
+		return functions[n.Func].eval(n.Args, env)
+	}
+	return nil, fmt.Errorf("cannot evaluate %s", n)
+}
+
+// selectSamples returns the current value of every metric matching the selector
+func selectSamples(sel *Selector, env Env) Vector {
+	result := Vector{}
+	env.Storage.Range(func(m handlers.MetricsJSON, meta handlers.MetricMeta) bool {
+		if sel.Name != "" && m.ID != sel.Name {
+			return true
+		}
+		labels := handlers.MetricLabels(m, meta)
+		for _, matcher := range sel.Matchers {
+			if !matcher.Match(labels) {
+				return true
+			}
+		}
+		result = append(result, Sample{
+			Name:   m.ID,
+			MType:  m.MType,
+			Labels: sampleLabels(labels),
+			Value:  Number(metricValue(m)),
+		})
+		return true
+	})
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Name != result[j].Name {
+			return result[i].Name < result[j].Name
+		}
+		return result[i].MType < result[j].MType
+	})
+	return result
+}
+
+// sampleLabels drops the labels that are already fields of the sample
+func sampleLabels(labels map[string]string) map[string]string {
+	result := make(map[string]string)
+	for k, v := range labels {
+		if k != "name" && k != "type" && v != "" {
+			result[k] = v
+		}
+	}
+	if len(result) == 0 {
+		return nil
+	}
+	return result
+}
+
+func metricValue(m handlers.MetricsJSON) float64 {
+	switch {
+	case m.Value != nil:
+		return *m.Value
+	case m.Delta != nil:
+		return float64(*m.Delta)
+	}
+	return math.NaN()
+}
+
+func binary(op string, left, right Value) (Value, error) {
+	ls, lIsScalar := left.(Scalar)
+	rs, rIsScalar := right.(Scalar)
+	comparison := precedence[op] == 1
+
+	switch {
+	case lIsScalar && rIsScalar:
+		return Scalar(apply(op, float64(ls), float64(rs))), nil
+
+	case rIsScalar:
+		return vectorScalar(op, left.(Vector), float64(rs), false, comparison), nil
+
+	case lIsScalar:
+		return vectorScalar(op, right.(Vector), float64(ls), true, comparison), nil
+	}
+
+	lv, rv := left.(Vector), right.(Vector)
+	// Two single metrics, like HeapInuse / HeapSys, always go together
+	if len(lv) == 1 && len(rv) == 1 {
+		return vectorVector(op, lv, rv, func(Sample) string { return "" }, comparison)
+	}
+	return vectorVector(op, lv, rv, matchKey, comparison)
+}
+
+// vectorScalar applies the operation to every sample. Comparisons keep
+// the samples for which they hold instead of returning 0 or 1
+func vectorScalar(op string, v Vector, s float64, scalarLeft, comparison bool) Vector {
+	result := Vector{}
+	for _, sample := range v {
+		a, b := float64(sample.Value), s
+		if scalarLeft {
+			a, b = b, a
+		}
+		value := apply(op, a, b)
+		if comparison {
+			if value == 1 {
+				result = append(result, sample)
+			}
+			continue
+		}
+		result = append(result, Sample{Labels: sample.Labels, Value: Number(value)})
+	}
+	return result
+}
+
+// vectorVector pairs the samples of both sides by their labels
+func vectorVector(op string, lv, rv Vector, key func(Sample) string, comparison bool) (Value, error) {
+	right := make(map[string]Sample, len(rv))
+	for _, s := range rv {
+		k := key(s)
+		if _, dup := right[k]; dup {
+			return nil, fmt.Errorf("right side of %q has several metrics with labels {%s}, narrow it down with label matchers", op, k)
+		}
+		right[k] = s
+	}
+
+	result := Vector{}
+	seen := make(map[string]bool, len(lv))
+	for _, l := range lv {
+		k := key(l)
+		if seen[k] {
+			return nil, fmt.Errorf("left side of %q has several metrics with labels {%s}, narrow it down with label matchers", op, k)
+		}
+		seen[k] = true
+
+		r, ok := right[k]
+		if !ok {
+			continue
+		}
+		value := apply(op, float64(l.Value), float64(r.Value))
+		if comparison {
+			if value == 1 {
+				result = append(result, l)
+			}
+			continue
+		}
+		result = append(result, Sample{Labels: l.Labels, Value: Number(value)})
+	}
+	return result, nil
+}
+
+// matchKey identifies the samples that belong together across two vectors
+func matchKey(s Sample) string {
+	keys := make([]string, 0, len(s.Labels))
+	for k := range s.Labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, len(keys))
+	for i, k := range keys {
+		parts[i] = k + "=" + strconv.Quote(s.Labels[k])
+	}
+	return strings.Join(parts, ", ")
+}
+
+func apply(op string, a, b float64) float64 {
+	switch op {
+	case "+":
+		return a + b
+	case "-":
+		return a - b
+	case "*":
+		return a * b
+	case "/":
+		return a / b
+	case "%":
+		return math.Mod(a, b)
+	case "==":
+		return boolValue(a == b)
+	case "!=":
+		return boolValue(a != b)
+	case "<":
+		return boolValue(a < b)
+	case "<=":
+		return boolValue(a <= b)
+	case ">":
+		return boolValue(a > b)
+	case ">=":
+		return boolValue(a >= b)
+	}
+	return math.NaN()
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,204 @@
+package expr
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"Vova4o/metrix/internal/handlers"
+	"Vova4o/metrix/internal/storage"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeHistory map[string][]handlers.HistoryPoint
+
+func (h fakeHistory) GetHistory(metricType, key string, since time.Time) []handlers.HistoryPoint {
+	var result []handlers.HistoryPoint
+	for _, p := range h[metricType+"/"+key] {
+		if !p.At.Before(since) {
+			result = append(result, p)
+		}
+	}
+	return result
+}
+
+func newTestEnv() Env {
+	s := storage.NewMemStorage()
+	s.SetGauge("HeapInuse", 30)
+	s.SetGauge("HeapSys", 120)
+	s.SetGauge("Alloc", 10)
+	s.SetSource("gauge", "Alloc", "web-1")
+	s.SetCounter("PollCount", 5)
+	s.SetSource("counter", "PollCount", "web-1")
+	s.SetCounter("Requests", 7)
+	s.SetSource("counter", "Requests", "web-2")
+
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	history := fakeHistory{
+		"counter/PollCount": {
+			{At: now.Add(-10 * time.Minute), Value: 0},
+			{At: now.Add(-4 * time.Minute), Value: 60},
+			{At: now.Add(-2 * time.Minute), Value: 120},
+			// Перезапуск агента обнулил счётчик
+			{At: now.Add(-time.Minute), Value: 30},
+		},
+		"gauge/HeapSys": {
+			{At: now.Add(-time.Minute), Value: 100},
+			{At: now, Value: 160},
+		},
+	}
+
+	return Env{Storage: s, History: history, Now: now}
+}
+
+func evalString(t *testing.T, input string, env Env) Value {
+	t.Helper()
+	node, err := Parse(input)
+	require.NoError(t, err)
+	v, err := Eval(node, env)
+	require.NoError(t, err)
+	return v
+}
+
+func TestEval_Scalars(t *testing.T) {
+	env := newTestEnv()
+
+	tests := []struct {
+		input string
+		want  float64
+	}{
+		{"1 + 2 * 3", 7},
+		{"(1 + 2) * 3", 9},
+		{"7 % 4", 3},
+		{"-2 * -3", 6},
+		{"2 > 1", 1},
+		{"2 == 1", 0},
+		{"sum(HeapInuse) / sum(HeapSys)", 0.25},
+		{`sum({host=~"web.*"})`, 22},
+		{`count({type="counter"})`, 2},
+		{`avg({type="gauge"})`, 160.0 / 3},
+		{`min({type="gauge"})`, 10},
+		{`max({type="gauge"})`, 120},
+		{`sum(Missing)`, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			v := evalString(t, tt.input, env)
+			require.IsType(t, Scalar(0), v)
+			assert.InDelta(t, tt.want, float64(v.(Scalar)), 1e-9)
+		})
+	}
+
+	assert.True(t, math.IsNaN(float64(evalString(t, "avg(Missing)", env).(Scalar))))
+}
+
+func TestEval_Vectors(t *testing.T) {
+	env := newTestEnv()
+
+	v := evalString(t, "HeapInuse / HeapSys", env).(Vector)
+	require.Len(t, v, 1)
+	assert.Equal(t, Number(0.25), v[0].Value)
+
+	v = evalString(t, `PollCount{host="web-1"}`, env).(Vector)
+	require.Len(t, v, 1)
+	assert.Equal(t, "PollCount", v[0].Name)
+	assert.Equal(t, "counter", v[0].MType)
+	assert.Equal(t, map[string]string{"host": "web-1", "source": "web-1"}, v[0].Labels)
+
+	v = evalString(t, `{type="gauge"} * 2`, env).(Vector)
+	require.Len(t, v, 3)
+	assert.Equal(t, Number(20), v[0].Value)
+
+	// Comparisons filter the vector
+	v = evalString(t, `{type="gauge"} > 20`, env).(Vector)
+	require.Len(t, v, 2)
+	assert.Equal(t, "HeapInuse", v[0].Name)
+	assert.Equal(t, "HeapSys", v[1].Name)
+
+	// Vectors are paired by labels
+	v = evalString(t, `{type="counter"} + {type="gauge", host!=""}`, env).(Vector)
+	require.Len(t, v, 1)
+	assert.Equal(t, Number(15), v[0].Value)
+}
+
+func TestEval_Rate(t *testing.T) {
+	env := newTestEnv()
+
+	v := evalString(t, "rate(PollCount[5m])", env).(Vector)
+	require.Len(t, v, 1)
+	// 60 после рестарта + 30 за 3 минуты
+	assert.InDelta(t, 90.0/180, float64(v[0].Value), 1e-9)
+
+	v = evalString(t, "rate(HeapSys[5m])", env).(Vector)
+	require.Len(t, v, 1)
+	assert.InDelta(t, 1.0, float64(v[0].Value), 1e-9)
+
+	// One point is not enough for a rate
+	v = evalString(t, "rate(PollCount[90s])", env).(Vector)
+	assert.Empty(t, v)
+
+	node, err := Parse("rate(PollCount[5m])")
+	require.NoError(t, err)
+	env.History = nil
+	_, err = Eval(node, env)
+	assert.Error(t, err)
+}
+
+func TestEval_Errors(t *testing.T) {
+	env := newTestEnv()
+
+	for _, input := range []string{
+		"sum(1)",
+		`{type="counter"} / {type="gauge"}`,
+	} {
+		node, err := Parse(input)
+		require.NoError(t, err)
+		_, err = Eval(node, env)
+		assert.Error(t, err, input)
+	}
+}
+
+func TestHandleQuery(t *testing.T) {
+	env := newTestEnv()
+	h := HandleQuery(env.Storage, env.History)
+
+	query := func(input string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/query?expr="+url.QueryEscape(input), nil)
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := query("sum(HeapInuse) / sum(HeapSys)")
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, `{"expr":"sum(HeapInuse) / sum(HeapSys)","type":"scalar","value":0.25}`, rr.Body.String())
+
+	rr = query("1 / 0")
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, `{"expr":"1 / 0","type":"scalar","value":"+Inf"}`, rr.Body.String())
+
+	rr = query("Missing")
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, `{"expr":"Missing","type":"vector","samples":[]}`, rr.Body.String())
+
+	rr = query("sum(Alloc")
+	require.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Equal(t, handlers.ProblemContentType, rr.Header().Get("Content-Type"))
+	var problem handlers.Problem
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &problem))
+	assert.Equal(t, handlers.CodeInvalidValue, problem.Code)
+	require.Len(t, problem.Errors, 1)
+	assert.Equal(t, "expr", problem.Errors[0].Parameter)
+	assert.Contains(t, problem.Errors[0].Detail, "to close the arguments of sum")
+	assert.Contains(t, problem.Errors[0].Detail, "at position 9")
+
+	rr = query("sum(1)")
+	assert.Equal(t, http.StatusUnprocessableEntity, rr.Code)
+}
@@ -0,0 +1,138 @@
+package expr
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+type argKind int
+
+const (
+	argVector argKind = iota
+	argRange
+)
+
+type function struct {
+	args []argKind
+	eval func(args []Node, env Env) (Value, error)
+}
+
+var functions map[string]function
+
+func init() {
+	// Set up in init because the aggregations call Eval, which looks up functions
+	functions = map[string]function{
+		"sum":   aggregate("sum", sumOf),
+		"avg":   aggregate("avg", avgOf),
+		"min":   aggregate("min", minOf),
+		"max":   aggregate("max", maxOf),
+		"count": aggregate("count", func(v []float64) float64 { return float64(len(v)) }),
+		"rate":  {args: []argKind{argRange}, eval: rate},
+	}
+}
+
+func functionNames() string {
+	names := make([]string, 0, len(functions))
+	for name := range functions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// aggregate folds a vector into a scalar
+func aggregate(name string, fold func([]float64) float64) function {
+	return function{
+		args: []argKind{argVector},
+		eval: func(args []Node, env Env) (Value, error) {
+			v, err := Eval(args[0], env)
+			if err != nil {
+				return nil, err
+			}
+			vector, ok := v.(Vector)
+			if !ok {
+				return nil, fmt.Errorf("%s expects metrics, got a %s", name, v.Type())
+			}
+			values := make([]float64, len(vector))
+			for i, s := range vector {
+				values[i] = float64(s.Value)
+			}
+			return Scalar(fold(values)), nil
+		},
+	}
+}
+
+func sumOf(values []float64) float64 {
+	sum := 0.0
+	for _, v := range values {
+		sum += v
+	}
+	return sum
+}
+
+func avgOf(values []float64) float64 {
+	if len(values) == 0 {
+		return math.NaN()
+	}
+	return sumOf(values) / float64(len(values))
+}
+
+func minOf(values []float64) float64 {
+	if len(values) == 0 {
+		return math.NaN()
+	}
+	result := values[0]
+	for _, v := range values[1:] {
+		result = math.Min(result, v)
+	}
+	return result
+}
+
+func maxOf(values []float64) float64 {
+	if len(values) == 0 {
+		return math.NaN()
+	}
+	result := values[0]
+	for _, v := range values[1:] {
+		result = math.Max(result, v)
+	}
+	return result
+}
+
+// rate is the per-second increase of every selected metric over the range.
+// Counters that went down are taken to have been reset
+func rate(args []Node, env Env) (Value, error) {
+	if env.History == nil {
+		return nil, fmt.Errorf("rate needs metric history, which is not available")
+	}
+	sel := args[0].(*Selector)
+
+	result := Vector{}
+	for _, s := range selectSamples(sel, env) {
+		points := env.History.GetHistory(s.MType, s.Name, env.Now.Add(-sel.Range))
+		if len(points) < 2 {
+			continue
+		}
+		first, last := points[0], points[len(points)-1]
+		seconds := last.At.Sub(first.At).Seconds()
+		if seconds <= 0 {
+			continue
+		}
+
+		increase := last.Value - first.Value
+		if s.MType == "counter" {
+			increase = 0
+			for i := 1; i < len(points); i++ {
+				delta := points[i].Value - points[i-1].Value
+				if delta < 0 {
+					delta = points[i].Value
+				}
+				increase += delta
+			}
+		}
+		result = append(result, Sample{Name: s.Name, Labels: s.Labels, Value: Number(increase / seconds)})
+	}
+	return result, nil
+}
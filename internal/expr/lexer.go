@@ -0,0 +1,197 @@
+package expr
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokNumber
+	tokIdent
+	tokString
+	tokDuration
+	tokOp // арифметика, сравнения и операторы меток
+	tokLParen
+	tokRParen
+	tokLBrace
+	tokRBrace
+	tokLBracket
+	tokRBracket
+	tokComma
+)
+
+func (k tokenKind) String() string {
+	switch k {
+	case tokEOF:
+		return "end of input"
+	case tokNumber:
+		return "number"
+	case tokIdent:
+		return "identifier"
+	case tokString:
+		return "string"
+	case tokDuration:
+		return "duration"
+	case tokOp:
+		return "operator"
+	case tokLParen:
+		return `"("`
+	case tokRParen:
+		return `")"`
+	case tokLBrace:
+		return `"{"`
+	case tokRBrace:
+		return `"}"`
+	case tokLBracket:
+		return `"["`
+	case tokRBracket:
+		return `"]"`
+	case tokComma:
+		return `","`
+	}
+	return "token"
+}
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int // смещение в байтах от начала выражения
+}
+
+func (t token) String() string {
+	switch t.kind {
+	case tokEOF:
+		return t.kind.String()
+	case tokString:
+		return fmt.Sprintf("string %s", t.text)
+	}
+	return fmt.Sprintf("%q", t.text)
+}
+
+// operators are matched longest first
+var operators = []string{"==", "!=", "<=", ">=", "=~", "!~", "+", "-", "*", "/", "%", "<", ">", "="}
+
+// lex splits the input into tokens. Durations are only recognised
+// inside brackets, so "5m" elsewhere is a number followed by a name
+func lex(input string) ([]token, error) {
+	var tokens []token
+	inBrackets := false
+
+	for pos := 0; pos < len(input); {
+		c := input[pos]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			pos++
+			continue
+
+		case inBrackets && isDigit(c):
+			end := pos
+			for end < len(input) && (isDigit(input[end]) || isLetter(input[end]) || input[end] == '.') {
+				end++
+			}
+			tokens = append(tokens, token{tokDuration, input[pos:end], pos})
+			pos = end
+			continue
+
+		case isDigit(c) || (c == '.' && pos+1 < len(input) && isDigit(input[pos+1])):
+			end := scanNumber(input, pos)
+			tokens = append(tokens, token{tokNumber, input[pos:end], pos})
+			pos = end
+			continue
+
+		case isLetter(c) || c == '_' || c == ':':
+			end := pos + 1
+			for end < len(input) && isIdentChar(input[end]) {
+				end++
+			}
+			tokens = append(tokens, token{tokIdent, input[pos:end], pos})
+			pos = end
+			continue
+
+		case c == '"' || c == '\'':
+			end := pos + 1
+			for end < len(input) && input[end] != c {
+				if input[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(input) {
+				return nil, &ParseError{Pos: pos, Msg: "unterminated string"}
+			}
+			tokens = append(tokens, token{tokString, input[pos : end+1], pos})
+			pos = end + 1
+			continue
+		}
+
+		kind := tokOp
+		switch c {
+		case '(':
+			kind = tokLParen
+		case ')':
+			kind = tokRParen
+		case '{':
+			kind = tokLBrace
+		case '}':
+			kind = tokRBrace
+		case '[':
+			kind, inBrackets = tokLBracket, true
+		case ']':
+			kind, inBrackets = tokRBracket, false
+		case ',':
+			kind = tokComma
+		}
+		if kind != tokOp {
+			tokens = append(tokens, token{kind, input[pos : pos+1], pos})
+			pos++
+			continue
+		}
+
+		op := ""
+		for _, candidate := range operators {
+			if strings.HasPrefix(input[pos:], candidate) {
+				op = candidate
+				break
+			}
+		}
+		if op == "" {
+			r, _ := utf8.DecodeRuneInString(input[pos:])
+			return nil, &ParseError{Pos: pos, Msg: fmt.Sprintf("unexpected character %q", r)}
+		}
+		tokens = append(tokens, token{tokOp, op, pos})
+		pos += len(op)
+	}
+
+	return append(tokens, token{kind: tokEOF, pos: len(input)}), nil
+}
+
+// scanNumber returns the end of a decimal number with an optional exponent
+func scanNumber(input string, pos int) int {
+	end := pos
+	for end < len(input) && (isDigit(input[end]) || input[end] == '.') {
+		end++
+	}
+	if end < len(input) && (input[end] == 'e' || input[end] == 'E') {
+		exp := end + 1
+		if exp < len(input) && (input[exp] == '+' || input[exp] == '-') {
+			exp++
+		}
+		if exp < len(input) && isDigit(input[exp]) {
+			end = exp
+			for end < len(input) && isDigit(input[end]) {
+				end++
+			}
+		}
+	}
+	return end
+}
+
+func isDigit(c byte) bool  { return c >= '0' && c <= '9' }
+func isLetter(c byte) bool { return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') }
+func isIdentChar(c byte) bool {
+	return isLetter(c) || isDigit(c) || c == '_' || c == ':' || c == '.'
+}
@@ -0,0 +1,414 @@
+package expr
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"Vova4o/metrix/internal/handlers"
+)
+
+// maxRange caps the window of a range selector
+const maxRange = 7 * 24 * time.Hour
+
+// ParseError describes where and why an expression could not be parsed
+type ParseError struct {
+	Pos int    `json:"position"` // смещение в байтах
+	Msg string `json:"message"`
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("parse error at position %d: %s", e.Pos, e.Msg)
+}
+
+// Node is an element of a parsed expression
+type Node interface {
+	String() string
+}
+
+// NumberLiteral is a constant like 0.5
+type NumberLiteral struct {
+	Value float64
+}
+
+// Selector picks metrics by name and labels, e.g. PollCount{host=~"web.*"}.
+// With a Range it selects their history, e.g. PollCount[5m]
+type Selector struct {
+	Name     string
+	Matchers []handlers.LabelMatcher
+	Range    time.Duration
+
+	pos int
+}
+
+// UnaryExpr is a negation
+type UnaryExpr struct {
+	Op   string
+	Expr Node
+}
+
+// BinaryExpr is an arithmetic operation or a comparison
+type BinaryExpr struct {
+	Op          string
+	Left, Right Node
+}
+
+// Call is a function applied to its arguments
+type Call struct {
+	Func string
+	Args []Node
+
+	pos int
+}
+
+// ParenExpr keeps the parentheses of the source for printing
+type ParenExpr struct {
+	Expr Node
+}
+
+func (n *NumberLiteral) String() string { return strconv.FormatFloat(n.Value, 'g', -1, 64) }
+func (n *UnaryExpr) String() string     { return n.Op + n.Expr.String() }
+func (n *BinaryExpr) String() string    { return n.Left.String() + " " + n.Op + " " + n.Right.String() }
+func (n *ParenExpr) String() string     { return "(" + n.Expr.String() + ")" }
+
+func (n *Selector) String() string {
+	var b strings.Builder
+	b.WriteString(n.Name)
+	if len(n.Matchers) > 0 {
+		parts := make([]string, len(n.Matchers))
+		for i, m := range n.Matchers {
+			parts[i] = m.String()
+		}
+		b.WriteString("{" + strings.Join(parts, ", ") + "}")
+	}
+	if n.Range > 0 {
+		b.WriteString("[" + n.Range.String() + "]")
+	}
+	return b.String()
+}
+
+func (n *Call) String() string {
+	args := make([]string, len(n.Args))
+	for i, a := range n.Args {
+		args[i] = a.String()
+	}
+	return n.Func + "(" + strings.Join(args, ", ") + ")"
+}
+
+// precedence of the binary operators, higher binds tighter
+var precedence = map[string]int{
+	"==": 1, "!=": 1, "<": 1, "<=": 1, ">": 1, ">=": 1,
+	"+": 2, "-": 2,
+	"*": 3, "/": 3, "%": 3,
+}
+
+// Parse turns an expression like sum(PollCount{host=~"web.*"}) / 2
+// into a tree. Errors are *ParseError
+func Parse(input string) (Node, error) {
+	if strings.TrimSpace(input) == "" {
+		return nil, &ParseError{Pos: 0, Msg: "empty expression"}
+	}
+
+	tokens, err := lex(input)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{tokens: tokens}
+	node, err := p.parseExpr(1)
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, p.errorf(t, "unexpected %s after complete expression", t)
+	}
+	if err := check(node); err != nil {
+		return nil, err
+	}
+	return node, nil
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() token { return p.tokens[p.pos] }
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) expect(kind tokenKind, context string) (token, error) {
+	t := p.next()
+	if t.kind != kind {
+		return t, p.errorf(t, "expected %s %s, found %s", kind, context, t)
+	}
+	return t, nil
+}
+
+func (p *parser) errorf(t token, format string, args ...interface{}) *ParseError {
+	return &ParseError{Pos: t.pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+// parseExpr parses binary operations of at least the given precedence.
+// Comparisons do not chain, arithmetic is left-associative
+func (p *parser) parseExpr(minPrec int) (Node, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		t := p.peek()
+		prec, ok := precedence[t.text]
+		if t.kind != tokOp || !ok || prec < minPrec {
+			return left, nil
+		}
+		p.next()
+
+		right, err := p.parseExpr(prec + 1)
+		if err != nil {
+			return nil, err
+		}
+		if prec == 1 {
+			if next := p.peek(); next.kind == tokOp && precedence[next.text] == 1 {
+				return nil, p.errorf(next, "comparisons cannot be chained, use parentheses")
+			}
+		}
+		left = &BinaryExpr{Op: t.text, Left: left, Right: right}
+	}
+}
+
+func (p *parser) parseUnary() (Node, error) {
+	if t := p.peek(); t.kind == tokOp && (t.text == "-" || t.text == "+") {
+		p.next()
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		if t.text == "+" {
+			return operand, nil
+		}
+		return &UnaryExpr{Op: "-", Expr: operand}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *parser) parsePrimary() (Node, error) {
+	t := p.peek()
+	switch t.kind {
+	case tokNumber:
+		p.next()
+		v, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, p.errorf(t, "invalid number %q", t.text)
+		}
+		return &NumberLiteral{Value: v}, nil
+
+	case tokLParen:
+		p.next()
+		inner, err := p.parseExpr(1)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := p.expect(tokRParen, "to close parenthesis"); err != nil {
+			return nil, err
+		}
+		return &ParenExpr{Expr: inner}, nil
+
+	case tokIdent:
+		p.next()
+		if p.peek().kind == tokLParen {
+			return p.parseCall(t)
+		}
+		return p.parseSelector(t.text, t.pos)
+
+	case tokLBrace:
+		return p.parseSelector("", t.pos)
+
+	case tokEOF:
+		return nil, p.errorf(t, "unexpected end of input, expected a value")
+	}
+	return nil, p.errorf(t, "unexpected %s, expected a number, metric or function", t)
+}
+
+func (p *parser) parseCall(name token) (Node, error) {
+	fn, ok := functions[name.text]
+	if !ok {
+		return nil, p.errorf(name, "unknown function %q, known functions are %s", name.text, functionNames())
+	}
+	p.next() // (
+
+	call := &Call{Func: name.text, pos: name.pos}
+	if p.peek().kind != tokRParen {
+		for {
+			arg, err := p.parseExpr(1)
+			if err != nil {
+				return nil, err
+			}
+			call.Args = append(call.Args, arg)
+			if p.peek().kind != tokComma {
+				break
+			}
+			p.next()
+		}
+	}
+	if _, err := p.expect(tokRParen, "to close the arguments of "+name.text); err != nil {
+		return nil, err
+	}
+	if len(call.Args) != len(fn.args) {
+		return nil, p.errorf(name, "%s expects %d argument(s), got %d", name.text, len(fn.args), len(call.Args))
+	}
+	return call, nil
+}
+
+func (p *parser) parseSelector(name string, pos int) (Node, error) {
+	sel := &Selector{Name: name, pos: pos}
+
+	if p.peek().kind == tokLBrace {
+		p.next()
+		for p.peek().kind != tokRBrace {
+			m, err := p.parseMatcher()
+			if err != nil {
+				return nil, err
+			}
+			sel.Matchers = append(sel.Matchers, m)
+			if p.peek().kind != tokComma {
+				break
+			}
+			p.next()
+		}
+		if _, err := p.expect(tokRBrace, "to close label matchers"); err != nil {
+			return nil, err
+		}
+	}
+
+	if sel.Name == "" && len(sel.Matchers) == 0 {
+		return nil, p.errorf(p.peek(), "a selector needs a metric name or at least one label matcher")
+	}
+
+	if p.peek().kind == tokLBracket {
+		p.next()
+		t, err := p.expect(tokDuration, "inside brackets")
+		if err != nil {
+			return nil, err
+		}
+		d, err := parseDuration(t.text)
+		if err != nil || d <= 0 {
+			return nil, p.errorf(t, "invalid duration %q, use values like 30s, 5m or 1h", t.text)
+		}
+		if d > maxRange {
+			return nil, p.errorf(t, "range %s is longer than the maximum of %s", d, maxRange)
+		}
+		sel.Range = d
+		if _, err := p.expect(tokRBracket, "to close the range"); err != nil {
+			return nil, err
+		}
+	}
+
+	return sel, nil
+}
+
+func (p *parser) parseMatcher() (handlers.LabelMatcher, error) {
+	name, err := p.expect(tokIdent, "as label name")
+	if err != nil {
+		return handlers.LabelMatcher{}, err
+	}
+	op := p.next()
+	switch op.text {
+	case "=", "!=", "=~", "!~":
+	default:
+		return handlers.LabelMatcher{}, p.errorf(op, "expected one of =, !=, =~, !~ after label %q, found %s", name.text, op)
+	}
+	value, err := p.expect(tokString, "as label value")
+	if err != nil {
+		return handlers.LabelMatcher{}, err
+	}
+	unquoted, err := strconv.Unquote(value.text)
+	if err != nil {
+		// Single-quoted strings are not Go syntax
+		unquoted = strings.Trim(value.text, `'`)
+	}
+	m, err := handlers.NewLabelMatcher(name.text, op.text, unquoted)
+	if err != nil {
+		return handlers.LabelMatcher{}, p.errorf(value, "%v", err)
+	}
+	return m, nil
+}
+
+// parseDuration accepts Go durations and a "d" suffix for days
+func parseDuration(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, err
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(s)
+}
+
+// check verifies that range selectors appear only where a function takes them
+func check(n Node) error {
+	switch n := n.(type) {
+	case *Selector:
+		if n.Range > 0 {
+			return &ParseError{Pos: n.pos, Msg: fmt.Sprintf("range selector %s can only be used in rate()", n)}
+		}
+	case *ParenExpr:
+		return check(n.Expr)
+	case *UnaryExpr:
+		return check(n.Expr)
+	case *BinaryExpr:
+		if err := check(n.Left); err != nil {
+			return err
+		}
+		return check(n.Right)
+	case *Call:
+		fn := functions[n.Func]
+		for i, arg := range n.Args {
+			if fn.args[i] == argRange {
+				sel, ok := arg.(*Selector)
+				if !ok || sel.Range == 0 {
+					return &ParseError{Pos: n.pos, Msg: fmt.Sprintf("%s expects a range selector like Metric[5m], got %s", n.Func, arg)}
+				}
+				continue
+			}
+			if err := check(arg); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Selectors returns every selector used by the expression
+func Selectors(n Node) []*Selector {
+	var result []*Selector
+	var walk func(Node)
+	walk = func(n Node) {
+		switch n := n.(type) {
+		case *Selector:
+			result = append(result, n)
+		case *ParenExpr:
+			walk(n.Expr)
+		case *UnaryExpr:
+			walk(n.Expr)
+		case *BinaryExpr:
+			walk(n.Left)
+			walk(n.Right)
+		case *Call:
+			for _, arg := range n.Args {
+				walk(arg)
+			}
+		}
+	}
+	walk(n)
+	return result
+}
@@ -0,0 +1,104 @@
+package expr
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"1 + 2 * 3", "1 + 2 * 3"},
+		{"HeapInuse / HeapSys", "HeapInuse / HeapSys"},
+		{"(1 + 2) * 3", "(1 + 2) * 3"},
+		{"-Alloc", "-Alloc"},
+		{"+5", "5"},
+		{"1.5e3", "1500"},
+		{`sum(PollCount{host=~"web.*"})`, `sum(PollCount{host=~"web.*"})`},
+		{`count({type="gauge", host!='db-1'})`, `count({type="gauge", host!="db-1"})`},
+		{"rate(PollCount[5m])", "rate(PollCount[5m0s])"},
+		{"rate(PollCount[1d])", "rate(PollCount[24h0m0s])"},
+		{"HeapAlloc > 1024", "HeapAlloc > 1024"},
+		{"sum(Alloc) / count(Alloc) >= 10", "sum(Alloc) / count(Alloc) >= 10"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			node, err := Parse(tt.input)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, node.String())
+		})
+	}
+}
+
+func TestParse_Precedence(t *testing.T) {
+	node, err := Parse("1 + 2 * 3 - 4")
+	require.NoError(t, err)
+
+	// (1 + (2 * 3)) - 4
+	sub, ok := node.(*BinaryExpr)
+	require.True(t, ok)
+	assert.Equal(t, "-", sub.Op)
+	add, ok := sub.Left.(*BinaryExpr)
+	require.True(t, ok)
+	assert.Equal(t, "+", add.Op)
+	mul, ok := add.Right.(*BinaryExpr)
+	require.True(t, ok)
+	assert.Equal(t, "*", mul.Op)
+}
+
+func TestParse_Errors(t *testing.T) {
+	tests := []struct {
+		input   string
+		pos     int
+		message string
+	}{
+		{"", 0, "empty expression"},
+		{"1 +", 3, "unexpected end of input"},
+		{"(1 + 2", 6, `expected ")" to close parenthesis`},
+		{"foo(Alloc)", 0, `unknown function "foo"`},
+		{"sum(Alloc, Sys)", 0, "sum expects 1 argument(s), got 2"},
+		{"Alloc # 2", 6, `unexpected character '#'`},
+		{"Alloc 2", 6, `unexpected "2" after complete expression`},
+		{`Alloc{host="web"`, 16, `expected "}" to close label matchers`},
+		{`Alloc{host~"web"}`, 10, "unexpected character '~'"},
+		{`Alloc{host=web}`, 11, "expected string as label value"},
+		{`Alloc{host=~"[web"}`, 12, "invalid regex"},
+		{`Alloc{host="web}`, 11, "unterminated string"},
+		{"rate(PollCount)", 0, "rate expects a range selector"},
+		{"PollCount[5m]", 0, "can only be used in rate()"},
+		{"rate(PollCount[5x])", 15, `invalid duration "5x"`},
+		{"rate(PollCount[30d])", 15, "longer than the maximum"},
+		{"1 < 2 < 3", 6, "comparisons cannot be chained"},
+		{"{}", 2, "needs a metric name or at least one label matcher"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			_, err := Parse(tt.input)
+			require.Error(t, err)
+
+			var parseErr *ParseError
+			require.True(t, errors.As(err, &parseErr))
+			assert.Equal(t, tt.pos, parseErr.Pos)
+			assert.Contains(t, parseErr.Msg, tt.message)
+		})
+	}
+}
+
+func TestSelectors(t *testing.T) {
+	node, err := Parse(`sum(HeapInuse{host="a"}) / rate(PollCount[1m]) + 2`)
+	require.NoError(t, err)
+
+	selectors := Selectors(node)
+	require.Len(t, selectors, 2)
+	assert.Equal(t, "HeapInuse", selectors[0].Name)
+	assert.Equal(t, "PollCount", selectors[1].Name)
+	assert.Equal(t, time.Minute, selectors[1].Range)
+}
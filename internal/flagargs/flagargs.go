@@ -0,0 +1,28 @@
+// Package flagargs prepares the command line of the agent and the server
+// for pflag
+package flagargs
+
+import (
+	"strings"
+
+	"github.com/spf13/pflag"
+)
+
+// Normalize lets the long flags of flags start with a single dash, as in
+// -crypto-key. pflag would read them as a group of shorthand flags
+func Normalize(flags *pflag.FlagSet, args []string) []string {
+	normalized := make([]string, 0, len(args))
+	for i, arg := range args {
+		if arg == "--" {
+			return append(normalized, args[i:]...)
+		}
+		if strings.HasPrefix(arg, "-") && !strings.HasPrefix(arg, "--") {
+			name, _, _ := strings.Cut(arg[1:], "=")
+			if len(name) > 1 && flags.Lookup(name) != nil {
+				arg = "-" + arg
+			}
+		}
+		normalized = append(normalized, arg)
+	}
+	return normalized
+}
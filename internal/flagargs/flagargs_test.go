@@ -0,0 +1,22 @@
+package flagargs
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/spf13/pflag"
+)
+
+func TestNormalize(t *testing.T) {
+	flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	flags.StringP("ServerAddress", "a", "", "")
+	flags.String("crypto-key", "", "")
+
+	args := []string{"-crypto-key", "key.pem", "-a", "localhost:9090", "-crypto-key=k.pem", "--crypto-key", "x", "-cryptokey", "--", "-crypto-key"}
+	want := []string{"--crypto-key", "key.pem", "-a", "localhost:9090", "--crypto-key=k.pem", "--crypto-key", "x", "-cryptokey", "--", "-crypto-key"}
+
+	got := Normalize(flags, args)
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+}
@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	chartWidth   = 720
+	chartHeight  = 240
+	chartPadding = 10
+)
+
+// Chart is a line chart already laid out for the SVG in the detail template
+type Chart struct {
+	Width      int
+	Height     int
+	Points     string // координаты для <polyline points="...">
+	Empty      bool
+	MinLabel   string
+	MaxLabel   string
+	StartLabel string
+	EndLabel   string
+}
+
+// NewChart scales the points into the chart area. The time axis spans
+// from since to now, so gaps in reporting stay visible as gaps. NaN and
+// infinite values cannot be drawn and are left out like gaps
+func NewChart(name string, points []HistoryPoint, since, now time.Time) Chart {
+	c := Chart{Width: chartWidth, Height: chartHeight}
+	c.StartLabel = since.Format("15:04:05")
+	c.EndLabel = now.Format("15:04:05")
+
+	finite := make([]HistoryPoint, 0, len(points))
+	for _, p := range points {
+		if math.IsNaN(p.Value) || math.IsInf(p.Value, 0) {
+			continue
+		}
+		finite = append(finite, p)
+	}
+	points = finite
+
+	if len(points) == 0 {
+		c.Empty = true
+		return c
+	}
+
+	lo, hi := points[0].Value, points[0].Value
+	for _, p := range points[1:] {
+		lo = min(lo, p.Value)
+		hi = max(hi, p.Value)
+	}
+	c.MinLabel = HumanizeValue(name, lo)
+	c.MaxLabel = HumanizeValue(name, hi)
+
+	span := now.Sub(since).Seconds()
+	if span <= 0 {
+		span = 1
+	}
+	valueSpan := hi - lo
+
+	plotW := float64(chartWidth - 2*chartPadding)
+	plotH := float64(chartHeight - 2*chartPadding)
+
+	var b strings.Builder
+	for i, p := range points {
+		x := chartPadding + plotW*p.At.Sub(since).Seconds()/span
+		// A flat line is drawn across the middle of the chart
+		y := chartPadding + plotH/2
+		if valueSpan > 0 {
+			y = chartPadding + plotH*(1-(p.Value-lo)/valueSpan)
+		}
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		b.WriteString(strconv.FormatFloat(x, 'f', 1, 64))
+		b.WriteByte(',')
+		b.WriteString(strconv.FormatFloat(y, 'f', 1, 64))
+	}
+	c.Points = b.String()
+
+	return c
+}
@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ETag formats a storage version as an entity tag. Listings served in
+// several formats pass the format as variant, so each gets its own tag
+func ETag(version uint64, variant ...string) string {
+	tag := strconv.FormatUint(version, 10)
+	for _, v := range variant {
+		tag += "-" + v
+	}
+	return `"` + tag + `"`
+}
+
+// NotModified sets the ETag and Last-Modified headers of a GET response
+// and reports whether the copy the client already has is current. In that
+// case it has answered 304 and the handler must not write a body.
+// If-None-Match wins over If-Modified-Since, as RFC 9110 asks
+func NotModified(w http.ResponseWriter, r *http.Request, etag string, modified time.Time) bool {
+	w.Header().Set("ETag", etag)
+	if !modified.IsZero() {
+		w.Header().Set("Last-Modified", modified.UTC().Format(http.TimeFormat))
+	}
+
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+
+	notModified := false
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		notModified = etagListMatches(inm, etag)
+	} else if ims := r.Header.Get("If-Modified-Since"); ims != "" && !modified.IsZero() {
+		since, err := http.ParseTime(ims)
+		// Last-Modified has whole seconds, the change time does not
+		notModified = err == nil && !modified.Truncate(time.Second).After(since)
+	}
+	if !notModified {
+		return false
+	}
+
+	h := w.Header()
+	h.Del("Content-Type")
+	h.Del("Content-Length")
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+// etagListMatches reports whether a list of entity tags such as an
+// If-Match or If-None-Match header holds etag. The comparison is weak,
+// it ignores the W/ prefix
+func etagListMatches(list, etag string) bool {
+	for _, candidate := range strings.Split(list, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" {
+			return true
+		}
+		candidate = strings.TrimPrefix(candidate, "W/")
+		if candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// checkIfMatch evaluates the If-Match header of a gauge update against the
+// current version of the gauge. It returns the version the update has to
+// be applied at, ok=false when there is no If-Match header, or a problem
+// when the precondition fails
+func checkIfMatch(s Storager, r *http.Request, m MetricsJSON) (version uint64, ok bool, p *Problem) {
+	ifMatch := r.Header.Get("If-Match")
+	if ifMatch == "" {
+		return 0, false, nil
+	}
+	if m.MType != "gauge" {
+		// Counter updates add to the total and do not lose each other's changes
+		return 0, false, NewProblem(http.StatusBadRequest, CodeInvalidValue,
+			"If-Match is only supported for gauges").
+			AtParameter("If-Match", "must be omitted for "+m.MType+" updates")
+	}
+
+	// The tag names a version of the gauge, not the bytes of a response.
+	// Compress weakens the tags it sends, so W/ is ignored here as well
+	meta, exists := s.GetMeta(m.MType, m.ID)
+	if _, stored := s.GetGauge(m.ID); !exists || !stored || !etagListMatches(ifMatch, ETag(meta.Version)) {
+		return 0, false, preconditionFailed(m)
+	}
+	return meta.Version, true, nil
+}
+
+// preconditionFailed reports a gauge changed since the client read it
+func preconditionFailed(m MetricsJSON) *Problem {
+	return NewProblem(http.StatusPreconditionFailed, CodeConflict,
+		fmt.Sprintf("%s metric %q has changed or does not exist, read it again", m.MType, m.ID))
+}
+
+// setMetricETag sets the ETag of a metric after an update
+func setMetricETag(w http.ResponseWriter, s Storager, m MetricsJSON) {
+	if meta, ok := s.GetMeta(m.MType, m.ID); ok {
+		w.Header().Set("ETag", ETag(meta.Version))
+	}
+}
@@ -0,0 +1,167 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNotModified(t *testing.T) {
+	modified := time.Date(2024, 5, 1, 10, 0, 0, 500, time.UTC)
+
+	tests := []struct {
+		name    string
+		method  string
+		headers map[string]string
+		want    bool
+	}{
+		{"no conditions", http.MethodGet, nil, false},
+		{"matching etag", http.MethodGet, map[string]string{"If-None-Match": `"7"`}, true},
+		{"weak etag", http.MethodGet, map[string]string{"If-None-Match": `W/"7"`}, true},
+		{"etag in a list", http.MethodGet, map[string]string{"If-None-Match": `"5", "7"`}, true},
+		{"star", http.MethodGet, map[string]string{"If-None-Match": `*`}, true},
+		{"old etag", http.MethodGet, map[string]string{"If-None-Match": `"6"`}, false},
+		{"same second", http.MethodGet, map[string]string{"If-Modified-Since": "Wed, 01 May 2024 10:00:00 GMT"}, true},
+		{"older copy", http.MethodGet, map[string]string{"If-Modified-Since": "Wed, 01 May 2024 09:59:59 GMT"}, false},
+		{"bad date", http.MethodGet, map[string]string{"If-Modified-Since": "yesterday"}, false},
+		{"etag wins over date", http.MethodGet, map[string]string{
+			"If-None-Match":     `"6"`,
+			"If-Modified-Since": "Wed, 01 May 2024 10:00:00 GMT",
+		}, false},
+		{"not for POST", http.MethodPost, map[string]string{"If-None-Match": `"7"`}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/value/gauge/Alloc", nil)
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			rr := httptest.NewRecorder()
+
+			got := NotModified(rr, req, ETag(7), modified)
+
+			assert.Equal(t, tt.want, got)
+			assert.Equal(t, `"7"`, rr.Header().Get("ETag"))
+			assert.Equal(t, "Wed, 01 May 2024 10:00:00 GMT", rr.Header().Get("Last-Modified"))
+			if tt.want {
+				assert.Equal(t, http.StatusNotModified, rr.Code)
+			}
+		})
+	}
+}
+
+func TestMetricValueConditional(t *testing.T) {
+	s := &mockStorager{
+		gauges:   map[string]float64{"Alloc": 1.5},
+		counters: map[string]int64{},
+		meta:     map[string]MetricMeta{"gauge/Alloc": {Version: 3, UpdatedAt: time.Now()}},
+	}
+	r := chi.NewRouter()
+	r.Get("/value/{metricType}/{metricName}", MetricValue(s))
+
+	rr := serve(r, http.MethodGet, "/value/gauge/Alloc", "")
+	require.Equal(t, http.StatusOK, rr.Code)
+	etag := rr.Header().Get("ETag")
+	assert.Equal(t, `"3"`, etag)
+
+	req := httptest.NewRequest(http.MethodGet, "/value/gauge/Alloc", nil)
+	req.Header.Set("If-None-Match", etag)
+	rr = httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusNotModified, rr.Code)
+	assert.Empty(t, rr.Body.String())
+}
+
+func TestListConditional(t *testing.T) {
+	s := &mockStorager{gauges: map[string]float64{"Alloc": 1.5}, counters: map[string]int64{}, version: 10}
+	h := ExportMetrics(s)
+
+	get := func(accept, ifNoneMatch string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/metrics", nil)
+		req.Header.Set("Accept", accept)
+		if ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		rr := httptest.NewRecorder()
+		h(rr, req)
+		return rr
+	}
+
+	rr := get(FormatJSON, "")
+	require.Equal(t, http.StatusOK, rr.Code)
+	etag := rr.Header().Get("ETag")
+	assert.Equal(t, `"10-json"`, etag)
+	assert.Equal(t, []string{"Accept"}, rr.Header().Values("Vary"))
+
+	assert.Equal(t, http.StatusNotModified, get(FormatJSON, etag).Code)
+	// Every format has its own tag
+	assert.Equal(t, http.StatusOK, get(FormatCSV, etag).Code)
+
+	s.version = 11
+	assert.Equal(t, http.StatusOK, get(FormatJSON, etag).Code)
+}
+
+func TestIfMatch(t *testing.T) {
+	newStorage := func() *mockStorager {
+		return &mockStorager{
+			gauges:   map[string]float64{"Alloc": 1.5},
+			counters: map[string]int64{"Polls": 1},
+			meta: map[string]MetricMeta{
+				"gauge/Alloc":   {Version: 3},
+				"counter/Polls": {Version: 4},
+			},
+		}
+	}
+	put := func(s Storager, target, body, ifMatch string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPut, target, strings.NewReader(body))
+		req.Header.Set("If-Match", ifMatch)
+		rr := httptest.NewRecorder()
+		newResourceRouter(s).ServeHTTP(rr, req)
+		return rr
+	}
+
+	t.Run("current version", func(t *testing.T) {
+		s := newStorage()
+		rr := put(s, "/api/v1/metrics/gauge/Alloc", `{"value": 2}`, `"3"`)
+		require.Equal(t, http.StatusOK, rr.Code)
+		assert.Equal(t, 2.0, s.gauges["Alloc"])
+		assert.Equal(t, `"4"`, rr.Header().Get("ETag"))
+	})
+
+	t.Run("changed since read", func(t *testing.T) {
+		s := newStorage()
+		rr := put(s, "/api/v1/metrics/gauge/Alloc", `{"value": 2}`, `"2"`)
+		require.Equal(t, http.StatusPreconditionFailed, rr.Code)
+		assertProblem(t, rr, CodeConflict)
+		assert.Equal(t, 1.5, s.gauges["Alloc"])
+	})
+
+	t.Run("tags weakened by compression", func(t *testing.T) {
+		s := newStorage()
+		rr := put(s, "/api/v1/metrics/gauge/Alloc", `{"value": 2}`, `W/"3"`)
+		require.Equal(t, http.StatusOK, rr.Code)
+		assert.Equal(t, 2.0, s.gauges["Alloc"])
+
+		rr = put(s, "/api/v1/metrics/gauge/Alloc", `{"value": 3}`, `W/"3"`)
+		require.Equal(t, http.StatusPreconditionFailed, rr.Code)
+	})
+
+	t.Run("star needs an existing gauge", func(t *testing.T) {
+		s := newStorage()
+		require.Equal(t, http.StatusOK, put(s, "/api/v1/metrics/gauge/Alloc", `{"value": 2}`, `*`).Code)
+		require.Equal(t, http.StatusPreconditionFailed, put(s, "/api/v1/metrics/gauge/Frees", `{"value": 2}`, `*`).Code)
+	})
+
+	t.Run("counters", func(t *testing.T) {
+		rr := put(newStorage(), "/api/v1/metrics/counter/Polls", `{"delta": 2}`, `"4"`)
+		require.Equal(t, http.StatusBadRequest, rr.Code)
+		assertProblem(t, rr, CodeInvalidValue)
+	})
+}
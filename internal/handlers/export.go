@@ -0,0 +1,239 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Media types a metric listing can be rendered as
+const (
+	FormatHTML   = "text/html"
+	FormatJSON   = "application/json"
+	FormatCSV    = "text/csv"
+	FormatNDJSON = "application/x-ndjson"
+	FormatText   = "text/plain"
+)
+
+// formatAliases lets browsers and spreadsheets pick a format
+// with ?format= instead of an Accept header
+var formatAliases = map[string]string{
+	"html":   FormatHTML,
+	"json":   FormatJSON,
+	"csv":    FormatCSV,
+	"ndjson": FormatNDJSON,
+	"text":   FormatText,
+}
+
+// exportFlushEvery is how many rows are written between flushes,
+// so large listings reach the client while they are being encoded
+const exportFlushEvery = 500
+
+// MetricExport is one metric in a JSON or NDJSON listing
+type MetricExport struct {
+	MetricsJSON
+	UpdatedAt *time.Time `json:"updated_at,omitempty"`
+}
+
+// ExportMetrics is an HTTP handler that lists every metric
+// as JSON, CSV, NDJSON or plain text, chosen by the Accept header
+func ExportMetrics(s Storager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		format := NegotiateFormat(r, FormatJSON, FormatCSV, FormatNDJSON, FormatText)
+		if format == "" {
+			WriteProblem(w, r, NewProblem(http.StatusNotAcceptable, CodeNotAcceptable,
+				"metrics can be listed as application/json, text/csv, application/x-ndjson or text/plain"))
+			return
+		}
+		w.Header().Add("Vary", "Accept")
+		if notModifiedSince(w, r, s, format) {
+			return
+		}
+		WriteExport(w, s, format)
+	}
+}
+
+// notModifiedSince answers 304 when no metric has changed since the client
+// fetched the listing in this format, see NotModified
+func notModifiedSince(w http.ResponseWriter, r *http.Request, s Storager, format string) bool {
+	variant := format
+	for alias, f := range formatAliases {
+		if f == format {
+			variant = alias
+		}
+	}
+	version, modified := s.Version()
+	return NotModified(w, r, ETag(version, variant), modified)
+}
+
+// NegotiateFormat picks the offer the client prefers. A ?format= query
+// parameter wins over the Accept header; without either the first offer
+// is used. It returns "" when the client accepts none of the offers
+func NegotiateFormat(r *http.Request, offers ...string) string {
+	if alias := r.URL.Query().Get("format"); alias != "" {
+		format := formatAliases[alias]
+		if contains(offers, format) {
+			return format
+		}
+		return ""
+	}
+
+	accept := r.Header.Get("Accept")
+	if strings.TrimSpace(accept) == "" {
+		return offers[0]
+	}
+
+	best, bestQ := "", 0.0
+	for _, offer := range offers {
+		if q := acceptQuality(accept, offer); q > bestQ {
+			best, bestQ = offer, q
+		}
+	}
+	return best
+}
+
+// acceptQuality returns the q-value the Accept header gives to a media
+// type, using the most specific matching range
+func acceptQuality(accept, mediaType string) float64 {
+	q, specificity := 0.0, -1
+	for _, part := range strings.Split(accept, ",") {
+		fields := strings.Split(part, ";")
+		rng := strings.ToLower(strings.TrimSpace(fields[0]))
+
+		spec := -1
+		switch {
+		case rng == mediaType:
+			spec = 2
+		case rng == "*/*":
+			spec = 0
+		case strings.HasSuffix(rng, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(rng, "*")):
+			spec = 1
+		}
+		if spec <= specificity {
+			continue
+		}
+
+		value := 1.0
+		for _, param := range fields[1:] {
+			name, v, ok := strings.Cut(strings.TrimSpace(param), "=")
+			if ok && strings.EqualFold(name, "q") {
+				if parsed, err := strconv.ParseFloat(v, 64); err == nil {
+					value = parsed
+				}
+			}
+		}
+		q, specificity = value, spec
+	}
+	return q
+}
+
+// WriteExport writes every metric in the given format, flushing
+// as it goes. Rows are ordered by type, then by name, so they are
+// collected first: the storage is not locked while the client reads.
+// Callers set Vary, since they also answer 304 for the same listing
+func WriteExport(w http.ResponseWriter, s Storager, format string) {
+	rows := CollectRows(s, time.Now())
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].Type != rows[j].Type {
+			return rows[i].Type < rows[j].Type
+		}
+		return rows[i].Name < rows[j].Name
+	})
+
+	switch format {
+	case FormatCSV:
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="metrics.csv"`)
+	case FormatText:
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	default:
+		w.Header().Set("Content-Type", format)
+	}
+	w.WriteHeader(http.StatusOK)
+
+	flusher, _ := w.(http.Flusher)
+	flush := func(i int) {
+		if flusher != nil && i > 0 && i%exportFlushEvery == 0 {
+			flusher.Flush()
+		}
+	}
+
+	switch format {
+	case FormatCSV:
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"id", "type", "value", "updated_at"})
+		for i, row := range rows {
+			cw.Write([]string{row.Name, row.Type, row.Value, formatTime(row.UpdatedAt)})
+			if i > 0 && i%exportFlushEvery == 0 {
+				cw.Flush()
+				flush(i)
+			}
+		}
+		cw.Flush()
+
+	case FormatNDJSON:
+		enc := json.NewEncoder(w)
+		for i, row := range rows {
+			enc.Encode(row.export())
+			flush(i)
+		}
+
+	case FormatText:
+		for i, row := range rows {
+			fmt.Fprintf(w, "%s %s %s %s\n", row.Type, row.Name, row.Value, formatTime(row.UpdatedAt))
+			flush(i)
+		}
+
+	default:
+		// A JSON array written element by element, so the encoded
+		// document is never held in memory, only the rows
+		fmt.Fprint(w, "[")
+		written := 0
+		for i, row := range rows {
+			// NaN and Inf gauges cannot be written as JSON numbers
+			data, err := json.Marshal(row.export())
+			if err != nil {
+				continue
+			}
+			if written > 0 {
+				fmt.Fprint(w, ",")
+			}
+			w.Write(data)
+			written++
+			flush(i)
+		}
+		fmt.Fprint(w, "]\n")
+	}
+}
+
+func (row MetricRow) export() MetricExport {
+	e := MetricExport{MetricsJSON: MetricsJSON{ID: row.Name, MType: row.Type}}
+	if row.Type == "counter" {
+		// Value holds the exact total, Numeric may have lost precision
+		delta, err := strconv.ParseInt(row.Value, 10, 64)
+		if err != nil {
+			delta = int64(row.Numeric)
+		}
+		e.Delta = &delta
+	} else {
+		value := row.Numeric
+		e.Value = &value
+	}
+	if !row.UpdatedAt.IsZero() {
+		updated := row.UpdatedAt
+		e.UpdatedAt = &updated
+	}
+	return e
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339Nano)
+}
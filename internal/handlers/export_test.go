@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNegotiateFormat(t *testing.T) {
+	offers := []string{FormatHTML, FormatJSON, FormatCSV, FormatNDJSON, FormatText}
+
+	tests := []struct {
+		name   string
+		accept string
+		query  string
+		want   string
+	}{
+		{"no header uses default", "", "", FormatHTML},
+		{"browser", "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8", "", FormatHTML},
+		{"exact type", "application/json", "", FormatJSON},
+		{"q-values", "text/csv;q=0.5, application/x-ndjson;q=0.9", "", FormatNDJSON},
+		{"wildcard subtype", "text/*;q=0.9, application/json;q=0.1", "", FormatHTML},
+		{"specific range overrides wildcard", "text/*, text/html;q=0", "", FormatCSV},
+		{"anything", "*/*", "", FormatHTML},
+		{"nothing acceptable", "image/png", "", ""},
+		{"query parameter wins", "application/json", "format=csv", FormatCSV},
+		{"unknown query format", "", "format=xml", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/?"+tt.query, nil)
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+			assert.Equal(t, tt.want, NegotiateFormat(req, offers...))
+		})
+	}
+}
+
+func exportRequest(t *testing.T, h http.Handler, accept string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "/api/metrics", nil)
+	req.Header.Set("Accept", accept)
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestExportMetrics(t *testing.T) {
+	h := ExportMetrics(newDashboardMock())
+
+	t.Run("json", func(t *testing.T) {
+		rr := exportRequest(t, h, "application/json")
+		require.Equal(t, http.StatusOK, rr.Code)
+		assert.Equal(t, "application/json", rr.Header().Get("Content-Type"))
+		assert.Equal(t, []string{"Accept"}, rr.Header().Values("Vary"))
+
+		var metrics []MetricExport
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &metrics))
+		require.Len(t, metrics, 4)
+		assert.Equal(t, "PollCount", metrics[0].ID)
+		assert.Equal(t, int64(42), *metrics[0].Delta)
+		assert.Equal(t, "HeapAlloc", metrics[1].ID)
+		assert.Equal(t, 2097152.0, *metrics[1].Value)
+		assert.NotNil(t, metrics[1].UpdatedAt)
+	})
+
+	t.Run("csv", func(t *testing.T) {
+		rr := exportRequest(t, h, "text/csv")
+		require.Equal(t, http.StatusOK, rr.Code)
+		assert.Equal(t, "text/csv; charset=utf-8", rr.Header().Get("Content-Type"))
+
+		records, err := csv.NewReader(rr.Body).ReadAll()
+		require.NoError(t, err)
+		require.Len(t, records, 5)
+		assert.Equal(t, []string{"id", "type", "value", "updated_at"}, records[0])
+		assert.Equal(t, []string{"PollCount", "counter", "42"}, records[1][:3])
+		assert.Equal(t, []string{"RandomValue", "gauge", "0.25"}, records[4][:3])
+	})
+
+	t.Run("ndjson", func(t *testing.T) {
+		rr := exportRequest(t, h, "application/x-ndjson")
+		require.Equal(t, http.StatusOK, rr.Code)
+
+		scanner := bufio.NewScanner(rr.Body)
+		lines := 0
+		for scanner.Scan() {
+			var m MetricExport
+			require.NoError(t, json.Unmarshal(scanner.Bytes(), &m))
+			lines++
+		}
+		assert.Equal(t, 4, lines)
+	})
+
+	t.Run("text", func(t *testing.T) {
+		rr := exportRequest(t, h, "text/plain")
+		require.Equal(t, http.StatusOK, rr.Code)
+		lines := strings.Split(strings.TrimSpace(rr.Body.String()), "\n")
+		require.Len(t, lines, 4)
+		assert.True(t, strings.HasPrefix(lines[0], "counter PollCount 42 "))
+	})
+
+	t.Run("not acceptable", func(t *testing.T) {
+		rr := exportRequest(t, h, "text/html")
+		assert.Equal(t, http.StatusNotAcceptable, rr.Code)
+	})
+}
+
+func TestShowMetrics_Negotiation(t *testing.T) {
+	h := ShowMetrics(newDashboardMock(), "metrix.page.tmpl")
+
+	rr := exportRequest(t, h, "text/csv")
+	assert.Equal(t, "text/csv; charset=utf-8", rr.Header().Get("Content-Type"))
+
+	rr = exportRequest(t, h, "text/html")
+	assert.Equal(t, "text/html; charset=utf-8", rr.Header().Get("Content-Type"))
+
+	// An Accept header matching nothing still gets the page
+	rr = exportRequest(t, h, "html/text")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "text/html; charset=utf-8", rr.Header().Get("Content-Type"))
+}
@@ -3,6 +3,7 @@ package handlers
 import (
 	"fmt"
 	"strconv"
+	"time"
 )
 
 type Storager interface {
@@ -10,10 +11,56 @@ type Storager interface {
 	GetGauge(key string) (float64, bool)
 	SetCounter(key string, value int64)
 	GetCounter(key string) (int64, bool)
-	// Delete(key string)
+	// Delete removes a metric with its bookkeeping data and
+	// reports whether it existed
+	Delete(metricType, key string) bool
 	GetAllGauges() map[string]float64
 	GetAllCounters() map[string]int64
 	GetAllMetrics() map[string]interface{}
+	GetMeta(metricType, key string) (MetricMeta, bool)
+	SetSource(metricType, key, source string)
+	// SetGaugeWithSource sets a gauge and records its source as a single
+	// change, so the version is bumped and subscribers are notified once
+	SetGaugeWithSource(key string, value float64, source string)
+	// Subscribe calls fn with the new value after every update,
+	// whichever handler the update came through
+	Subscribe(fn func(MetricsJSON)) (unsubscribe func())
+	// SetGaugeIfVersion sets a gauge only while its version is still the
+	// given one and reports whether it did
+	SetGaugeIfVersion(key string, value float64, version uint64) bool
+	// Version returns the version and time of the latest change of any
+	// metric, so whole listings can be cached
+	Version() (uint64, time.Time)
+	// Range calls fn for every metric until fn returns false. fn runs
+	// with the storage locked and must not call back into it
+	Range(fn func(m MetricsJSON, meta MetricMeta) bool)
+}
+
+// MetricMeta is bookkeeping data kept next to a metric value
+type MetricMeta struct {
+	UpdatedAt time.Time `json:"updated_at"`        // время последнего обновления
+	Source    string    `json:"source,omitempty"`  // агент, приславший последнее значение
+	Version   uint64    `json:"version,omitempty"` // растёт при каждом изменении метрики
+}
+
+// HistoryPoint is the value of a metric at a moment in time
+type HistoryPoint struct {
+	At    time.Time `json:"at"`
+	Value float64   `json:"value"`
+}
+
+// Historian returns the recent values of a metric, oldest first
+type Historian interface {
+	GetHistory(metricType, key string, since time.Time) []HistoryPoint
+}
+
+// Limiter is implemented by storages that cap the number of metrics
+// they hold. Handlers store their updates through it
+type Limiter interface {
+	// Admit calls store when the updates leave the storage within its
+	// cap, and returns an error instead when they would create more
+	// metrics than it may hold. Other updates wait until store returns
+	Admit(updates []MetricsJSON, store func()) error
 }
 
 // MetricType is an interface for metric types
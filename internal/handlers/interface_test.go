@@ -1,16 +1,25 @@
 package handlers
 
 import (
+	"sync"
 	"testing"
+	"time"
 )
 
 type mockStorager struct {
 	gauges   map[string]float64
 	counters map[string]int64
+	meta     map[string]MetricMeta
+	version  uint64
+	modified time.Time
+
+	subMu sync.Mutex
+	subs  []func(MetricsJSON)
 }
 
 func (m *mockStorager) SetGauge(key string, value float64) {
 	m.gauges[key] = value
+	m.notify(MetricsJSON{ID: key, MType: "gauge", Value: &value})
 }
 
 func (m *mockStorager) GetGauge(key string) (float64, bool) {
@@ -20,6 +29,29 @@ func (m *mockStorager) GetGauge(key string) (float64, bool) {
 
 func (m *mockStorager) SetCounter(key string, value int64) {
 	m.counters[key] = value
+	m.notify(MetricsJSON{ID: key, MType: "counter", Delta: &value})
+}
+
+func (m *mockStorager) Subscribe(fn func(MetricsJSON)) func() {
+	m.subMu.Lock()
+	defer m.subMu.Unlock()
+	m.subs = append(m.subs, fn)
+	return func() {}
+}
+
+func (m *mockStorager) notify(change MetricsJSON) {
+	m.subMu.Lock()
+	defer m.subMu.Unlock()
+	for _, fn := range m.subs {
+		fn(change)
+	}
+}
+
+// subscribers returns how many clients have subscribed so far
+func (m *mockStorager) subscribers() int {
+	m.subMu.Lock()
+	defer m.subMu.Unlock()
+	return len(m.subs)
 }
 
 func (m *mockStorager) GetCounter(key string) (int64, bool) {
@@ -46,6 +78,67 @@ func (m *mockStorager) GetAllMetrics() map[string]interface{} {
 	return result
 }
 
+func (m *mockStorager) SetGaugeIfVersion(key string, value float64, version uint64) bool {
+	meta, ok := m.meta["gauge/"+key]
+	if _, exists := m.gauges[key]; !exists || !ok || meta.Version != version {
+		return false
+	}
+	m.SetGauge(key, value)
+	meta.Version++
+	m.meta["gauge/"+key] = meta
+	return true
+}
+
+func (m *mockStorager) Version() (uint64, time.Time) {
+	return m.version, m.modified
+}
+
+func (m *mockStorager) Delete(metricType, key string) bool {
+	var exists bool
+	switch metricType {
+	case "gauge":
+		_, exists = m.gauges[key]
+		delete(m.gauges, key)
+	case "counter":
+		_, exists = m.counters[key]
+		delete(m.counters, key)
+	}
+	delete(m.meta, metricType+"/"+key)
+	return exists
+}
+
+func (m *mockStorager) GetMeta(metricType, key string) (MetricMeta, bool) {
+	meta, ok := m.meta[metricType+"/"+key]
+	return meta, ok
+}
+
+func (m *mockStorager) SetSource(metricType, key, source string) {
+	if m.meta == nil {
+		m.meta = make(map[string]MetricMeta)
+	}
+	meta := m.meta[metricType+"/"+key]
+	meta.Source = source
+	m.meta[metricType+"/"+key] = meta
+}
+
+func (m *mockStorager) SetGaugeWithSource(key string, value float64, source string) {
+	m.SetSource("gauge", key, source)
+	m.SetGauge(key, value)
+}
+
+func (m *mockStorager) Range(fn func(MetricsJSON, MetricMeta) bool) {
+	for key, value := range m.gauges {
+		if !fn(MetricsJSON{ID: key, MType: "gauge", Value: &value}, m.meta["gauge/"+key]) {
+			return
+		}
+	}
+	for key, delta := range m.counters {
+		if !fn(MetricsJSON{ID: key, MType: "counter", Delta: &delta}, m.meta["counter/"+key]) {
+			return
+		}
+	}
+}
+
 func TestGaugeMetricType_GetAll(t *testing.T) {
 	mock := &mockStorager{
 		gauges: map[string]float64{
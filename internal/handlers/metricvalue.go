@@ -3,7 +3,6 @@ package handlers
 import (
 	"encoding/json"
 	"fmt"
-	"log"
 	"net/http"
 
 	"github.com/go-chi/chi/v5"
@@ -11,24 +10,20 @@ import (
 
 func MetricValue(s Storager) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		metricType := chi.URLParam(r, "metricType")
-		metricName := chi.URLParam(r, "metricName")
-
-		var mt Metricer
-		switch metricType {
-		case "gauge":
-			mt = GaugeMetricType{}
-		case "counter":
-			mt = CounterMetricType{}
-		default:
-			log.Printf("Invalid metric type: %s", metricType)
-			http.Error(w, "Invalid metric type", http.StatusBadRequest)
+		metricType := pathMetricType(w, r)
+		if metricType == "" {
 			return
 		}
+		metricName := chi.URLParam(r, "metricName")
 
+		mt := metricKind(metricType)
 		value, exists := mt.GetValue(s, metricName)
 		if !exists {
-			http.Error(w, "Metric not found", http.StatusNotFound)
+			WriteProblem(w, r, NotFoundProblem(metricType, metricName))
+			return
+		}
+		meta, _ := s.GetMeta(metricType, metricName)
+		if NotModified(w, r, ETag(meta.Version), meta.UpdatedAt) {
 			return
 		}
 
@@ -45,44 +40,21 @@ func MetricValueJSON(s Storager) http.HandlerFunc {
 		// Decode the JSON request body into the metrics struct
 		err := json.NewDecoder(r.Body).Decode(&metrics)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			WriteProblem(w, r, DecodeProblem(err))
 			return
 		}
 
-		var mt Metricer
-		switch metrics.MType {
-		case "gauge":
-			mt = GaugeMetricType{}
-		case "counter":
-			mt = CounterMetricType{}
-		default:
-			log.Printf("Invalid metric type: %s", metrics.MType)
-			http.Error(w, "Invalid metric type", http.StatusBadRequest)
+		if metricKind(metrics.MType) == nil {
+			WriteProblem(w, r, UnknownTypeProblem(metrics.MType).AtPointer("/type", "must be gauge or counter"))
 			return
 		}
 
-		value, exists := mt.GetValue(s, metrics.ID)
+		current, exists := loadMetric(s, metrics.MType, metrics.ID)
 		if !exists {
-			http.Error(w, "Metric not found", http.StatusNotFound)
+			WriteProblem(w, r, NotFoundProblem(metrics.MType, metrics.ID))
 			return
 		}
 
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusOK)
-		if metrics.MType == "gauge" {
-			json.NewEncoder(w).Encode(map[string]interface{}{
-				"id":    metrics.ID,
-				"type":  metrics.MType,
-				"value": value,
-			})
-			return
-		}
-		if metrics.MType == "counter" {
-			json.NewEncoder(w).Encode(map[string]interface{}{
-				"id":    metrics.ID,
-				"type":  metrics.MType,
-				"delta": value,
-			})
-		}
+		writeJSON(w, http.StatusOK, current)
 	}
 }
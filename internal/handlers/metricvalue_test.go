@@ -3,7 +3,6 @@ package handlers
 import (
 	"bytes"
 	"encoding/json"
-	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"reflect"
@@ -35,7 +34,8 @@ func TestMetricValue(t *testing.T) {
 	}{
 		{"Gauge Test", "gauge", "test", http.StatusOK, "123.45"},
 		{"Counter Test", "counter", "test", http.StatusOK, "678"},
-		{"Invalid Metric Type", "wrong", "test", http.StatusBadRequest, "Invalid metric type"},
+		{"Invalid Metric Type", "wrong", "test", http.StatusBadRequest, CodeUnknownType},
+		{"Missing Metric", "gauge", "missing", http.StatusNotFound, CodeNotFound},
 	}
 
 	for _, tt := range tests {
@@ -73,6 +73,12 @@ func TestMetricValue(t *testing.T) {
 				t.Errorf("expected %v, got %v", tt.expectedStatus, rr.Code)
 			}
 
+			// Errors are problem documents, checked by their code
+			if tt.expectedStatus != http.StatusOK {
+				assertProblem(t, rr, tt.expectedBody)
+				return
+			}
+
 			if gotBody := strings.TrimSpace(rr.Body.String()); gotBody != strings.TrimSpace(tt.expectedBody) {
 				t.Errorf("expected %v, got %v", tt.expectedBody, gotBody)
 			}
@@ -112,7 +118,16 @@ func TestMetricValueJSON(t *testing.T) {
 				"id":   "test",
 			},
 			expectedStatus: http.StatusBadRequest,
-			expectedBody:   `"Invalid metric type"`,
+			expectedBody:   CodeUnknownType,
+		},
+		{
+			name: "Missing Metric",
+			body: map[string]interface{}{
+				"type": "counter",
+				"id":   "missing",
+			},
+			expectedStatus: http.StatusNotFound,
+			expectedBody:   CodeNotFound,
 		},
 		// Add more test cases as needed
 	}
@@ -175,10 +190,7 @@ func TestMetricValueJSON(t *testing.T) {
 					t.Errorf("expected %v, got %v", expectedBody, gotBody)
 				}
 			} else {
-				gotBody := strings.Trim(rr.Body.String(), "\n")
-				if fmt.Sprintf("%q", gotBody) != tt.expectedBody {
-					t.Errorf("expected %v, got %v", tt.expectedBody, fmt.Sprintf("%q", gotBody))
-				}
+				assertProblem(t, rr, tt.expectedBody)
 			}
 		})
 	}
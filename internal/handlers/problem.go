@@ -0,0 +1,158 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// ProblemContentType is the media type of error responses (RFC 9457)
+const ProblemContentType = "application/problem+json"
+
+// Machine-readable error codes
+const (
+	CodeUnknownType   = "unknown_type"   // тип метрики не gauge и не counter
+	CodeInvalidValue  = "invalid_value"  // значение не разбирается или отсутствует
+	CodeNotFound      = "not_found"      // метрика или ресурс не найдены
+	CodeConflict      = "conflict"       // состояние изменилось с момента чтения
+	CodeInvalidBody   = "invalid_body"   // тело запроса не является корректным JSON
+	CodeNotAcceptable = "not_acceptable" // ни один формат ответа не подходит
+	CodeInternal      = "internal"
+
+	CodeUnsupportedEncoding = "unsupported_encoding" // Content-Encoding запроса не поддерживается
+	CodeInvalidSignature    = "invalid_signature"    // подпись HashSHA256 отсутствует или не совпадает
+	CodeDecryptionFailed    = "decryption_failed"    // тело зашифровано не тем ключом или повреждено
+	CodeForbidden           = "forbidden"            // клиенту запрещён этот маршрут
+	CodeUnauthorized        = "unauthorized"         // нет токена или токен неизвестен
+	CodeQuotaExceeded       = "quota_exceeded"       // у арендатора кончилась квота на число метрик
+)
+
+// problemTitles are the short, fixed summaries of each code
+var problemTitles = map[string]string{
+	CodeUnknownType:   "Unknown metric type",
+	CodeInvalidValue:  "Invalid value",
+	CodeNotFound:      "Not found",
+	CodeConflict:      "Conflict",
+	CodeInvalidBody:   "Invalid request body",
+	CodeNotAcceptable: "Not acceptable",
+	CodeInternal:      "Internal server error",
+
+	CodeUnsupportedEncoding: "Unsupported content encoding",
+	CodeInvalidSignature:    "Invalid signature",
+	CodeDecryptionFailed:    "Decryption failed",
+	CodeForbidden:           "Forbidden",
+	CodeUnauthorized:        "Unauthorized",
+	CodeQuotaExceeded:       "Quota exceeded",
+}
+
+// Problem is an application/problem+json error response
+type Problem struct {
+	Type     string       `json:"type"`
+	Title    string       `json:"title"`
+	Status   int          `json:"status"`
+	Detail   string       `json:"detail,omitempty"`
+	Instance string       `json:"instance,omitempty"` // путь запроса
+	Code     string       `json:"code"`
+	Errors   []FieldError `json:"errors,omitempty"`
+
+	cause error // пишется в лог, но не клиенту
+}
+
+// FieldError points at the part of the request that is wrong: a JSON
+// pointer into the body, or the name of a query or path parameter
+type FieldError struct {
+	Pointer   string `json:"pointer,omitempty"`
+	Parameter string `json:"parameter,omitempty"`
+	Detail    string `json:"detail"`
+}
+
+// NewProblem creates a problem with the given status and code
+func NewProblem(status int, code, detail string) *Problem {
+	title, ok := problemTitles[code]
+	if !ok {
+		title = http.StatusText(status)
+	}
+	return &Problem{
+		Type:   "urn:metrix:problem:" + code,
+		Title:  title,
+		Status: status,
+		Detail: detail,
+		Code:   code,
+	}
+}
+
+// AtPointer adds an error for the body field at the JSON pointer, e.g. "/value"
+func (p *Problem) AtPointer(pointer, detail string) *Problem {
+	p.Errors = append(p.Errors, FieldError{Pointer: pointer, Detail: detail})
+	return p
+}
+
+// AtParameter adds an error for a query or path parameter
+func (p *Problem) AtParameter(name, detail string) *Problem {
+	p.Errors = append(p.Errors, FieldError{Parameter: name, Detail: detail})
+	return p
+}
+
+// WithCause keeps the underlying error for the server log
+func (p *Problem) WithCause(err error) *Problem {
+	p.cause = err
+	return p
+}
+
+// WriteProblem logs the problem and writes it as the response
+func WriteProblem(w http.ResponseWriter, r *http.Request, p *Problem) {
+	if p.Instance == "" {
+		p.Instance = r.URL.Path
+	}
+
+	if p.cause != nil {
+		log.Printf("%s %s: %s: %v", r.Method, p.Instance, p.Detail, p.cause)
+	} else {
+		log.Printf("%s %s: %s", r.Method, p.Instance, p.Detail)
+	}
+
+	h := w.Header()
+	h.Del("Content-Length")
+	h.Set("Content-Type", ProblemContentType)
+	h.Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(p.Status)
+	json.NewEncoder(w).Encode(p)
+}
+
+// UnknownTypeProblem reports a metric type that is neither gauge nor counter
+func UnknownTypeProblem(metricType string) *Problem {
+	return NewProblem(http.StatusBadRequest, CodeUnknownType,
+		fmt.Sprintf("metric type %q is not supported, use gauge or counter", metricType))
+}
+
+// NotFoundProblem reports a metric missing from the storage
+func NotFoundProblem(metricType, name string) *Problem {
+	return NewProblem(http.StatusNotFound, CodeNotFound,
+		fmt.Sprintf("%s metric %q not found", metricType, name))
+}
+
+// DecodeProblem turns a JSON decoding error into a problem that points at
+// the offending field when the decoder knows it, without echoing the raw error
+func DecodeProblem(err error) *Problem {
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) && typeErr.Field != "" {
+		pointer := "/" + strings.ReplaceAll(typeErr.Field, ".", "/")
+		return NewProblem(http.StatusBadRequest, CodeInvalidValue, "a field has the wrong type").
+			AtPointer(pointer, fmt.Sprintf("expected %s, got %s", typeErr.Type, typeErr.Value)).
+			WithCause(err)
+	}
+
+	var syntaxErr *json.SyntaxError
+	switch {
+	case errors.As(err, &syntaxErr):
+		return NewProblem(http.StatusBadRequest, CodeInvalidBody,
+			fmt.Sprintf("malformed JSON at byte %d", syntaxErr.Offset)).WithCause(err)
+	case errors.Is(err, io.EOF):
+		return NewProblem(http.StatusBadRequest, CodeInvalidBody, "request body is empty")
+	}
+	return NewProblem(http.StatusBadRequest, CodeInvalidBody, "request body is not valid JSON").WithCause(err)
+}
@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// assertProblem checks that the response is a problem document with the code
+func assertProblem(t *testing.T, rr *httptest.ResponseRecorder, code string) Problem {
+	t.Helper()
+
+	assert.Equal(t, ProblemContentType, rr.Header().Get("Content-Type"))
+
+	var p Problem
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &p))
+	assert.Equal(t, code, p.Code)
+	assert.Equal(t, rr.Code, p.Status)
+	assert.Equal(t, "urn:metrix:problem:"+code, p.Type)
+	assert.NotEmpty(t, p.Title)
+	return p
+}
+
+func TestWriteProblem(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/value/gauge/x", nil)
+	rr := httptest.NewRecorder()
+
+	WriteProblem(rr, req, NotFoundProblem("gauge", "x").AtParameter("metricName", "unknown"))
+
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+	assert.Equal(t, "nosniff", rr.Header().Get("X-Content-Type-Options"))
+	p := assertProblem(t, rr, CodeNotFound)
+	assert.Equal(t, "/value/gauge/x", p.Instance)
+	assert.Equal(t, `gauge metric "x" not found`, p.Detail)
+	assert.Equal(t, []FieldError{{Parameter: "metricName", Detail: "unknown"}}, p.Errors)
+}
+
+func TestDecodeProblem(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		code    string
+		pointer string
+	}{
+		{"empty body", "", CodeInvalidBody, ""},
+		{"malformed", `{"id": "a",`, CodeInvalidBody, ""},
+		{"syntax error", `{"id" "a"}`, CodeInvalidBody, ""},
+		{"wrong type", `{"id": "a", "type": "gauge", "value": "12"}`, CodeInvalidValue, "/value"},
+		{"wrong id type", `{"id": 5}`, CodeInvalidValue, "/id"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var m MetricsJSON
+			err := json.NewDecoder(strings.NewReader(tt.body)).Decode(&m)
+			require.Error(t, err)
+
+			p := DecodeProblem(err)
+			assert.Equal(t, tt.code, p.Code)
+			assert.Equal(t, http.StatusBadRequest, p.Status)
+			// Raw decoder errors stay in the log
+			assert.NotContains(t, p.Detail, "json:")
+			if tt.pointer != "" {
+				require.Len(t, p.Errors, 1)
+				assert.Equal(t, tt.pointer, p.Errors[0].Pointer)
+			}
+		})
+	}
+}
+
+func TestUpdateProblems(t *testing.T) {
+	s := &mockStorager{gauges: map[string]float64{}, counters: map[string]int64{}}
+	r := chi.NewRouter()
+	r.Post("/update/{metricType}/{metricName}/{metricValue}", HandleUpdateText(s))
+	r.Post("/update/", HandleUpdateJSON(s))
+
+	tests := []struct {
+		name    string
+		path    string
+		body    string
+		code    string
+		pointer string
+		param   string
+	}{
+		{"text unknown type", "/update/histogram/a/1", "", CodeUnknownType, "", "metricType"},
+		{"text invalid value", "/update/counter/a/1.5", "", CodeInvalidValue, "", "metricValue"},
+		{"json unknown type", "/update/", `{"id":"a","type":"histogram"}`, CodeUnknownType, "/type", ""},
+		{"json missing id", "/update/", `{"type":"gauge","value":1}`, CodeInvalidValue, "/id", ""},
+		{"json missing value", "/update/", `{"id":"a","type":"gauge"}`, CodeInvalidValue, "/value", ""},
+		{"json wrong delta", "/update/", `{"id":"a","type":"counter","delta":"x"}`, CodeInvalidValue, "/delta", ""},
+		{"json malformed", "/update/", `{`, CodeInvalidBody, "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(tt.body))
+			rr := httptest.NewRecorder()
+			r.ServeHTTP(rr, req)
+
+			require.Equal(t, http.StatusBadRequest, rr.Code)
+			p := assertProblem(t, rr, tt.code)
+			if tt.pointer != "" || tt.param != "" {
+				require.Len(t, p.Errors, 1)
+				assert.Equal(t, tt.pointer, p.Errors[0].Pointer)
+				assert.Equal(t, tt.param, p.Errors[0].Parameter)
+			}
+		})
+	}
+}
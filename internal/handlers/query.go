@@ -0,0 +1,451 @@
+package handlers
+
+import (
+	"cmp"
+	"container/heap"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultQueryLimit = 100
+	maxQueryLimit     = 1000
+)
+
+// LabelMatcher compares one label of a metric with a value or an
+// anchored regular expression, like source=~"web.*"
+type LabelMatcher struct {
+	Name  string
+	Op    string // =, !=, =~ или !~
+	Value string
+	re    *regexp.Regexp
+}
+
+// ParseLabelMatcher reads a matcher written as name=value, name!=value,
+// name=~regex or name!~regex. The value may be double-quoted
+func ParseLabelMatcher(s string) (LabelMatcher, error) {
+	i := strings.IndexAny(s, "=!")
+	if i <= 0 {
+		return LabelMatcher{}, fmt.Errorf("invalid label matcher %q", s)
+	}
+	name, rest := strings.TrimSpace(s[:i]), s[i:]
+
+	var op string
+	for _, candidate := range []string{"=~", "!~", "!=", "="} {
+		if strings.HasPrefix(rest, candidate) {
+			op = candidate
+			break
+		}
+	}
+	if op == "" {
+		return LabelMatcher{}, fmt.Errorf("invalid label matcher %q", s)
+	}
+
+	value := strings.TrimSpace(rest[len(op):])
+	if unquoted, err := strconv.Unquote(value); err == nil {
+		value = unquoted
+	}
+	return NewLabelMatcher(name, op, value)
+}
+
+// NewLabelMatcher builds a matcher, compiling the regular expression if needed
+func NewLabelMatcher(name, op, value string) (LabelMatcher, error) {
+	m := LabelMatcher{Name: name, Op: op, Value: value}
+	switch op {
+	case "=", "!=":
+	case "=~", "!~":
+		re, err := regexp.Compile("^(?:" + value + ")$")
+		if err != nil {
+			return LabelMatcher{}, fmt.Errorf("invalid regex for label %q: %w", name, err)
+		}
+		m.re = re
+	default:
+		return LabelMatcher{}, fmt.Errorf("invalid label operator %q", op)
+	}
+	return m, nil
+}
+
+// Match reports whether the label value passes the matcher.
+// A missing label matches as an empty string
+func (m LabelMatcher) Match(labels map[string]string) bool {
+	v := labels[m.Name]
+	switch m.Op {
+	case "=":
+		return v == m.Value
+	case "!=":
+		return v != m.Value
+	case "=~":
+		return m.re.MatchString(v)
+	case "!~":
+		return !m.re.MatchString(v)
+	}
+	return false
+}
+
+func (m LabelMatcher) String() string {
+	return m.Name + m.Op + strconv.Quote(m.Value)
+}
+
+// MetricLabels returns the labels a metric can be matched by. Metrics
+// carry no labels of their own, so they are derived from the metadata:
+// host is the agent that sent the last value, source is kept as an alias
+func MetricLabels(m MetricsJSON, meta MetricMeta) map[string]string {
+	return map[string]string{
+		"name":   m.ID,
+		"type":   m.MType,
+		"host":   meta.Source,
+		"source": meta.Source,
+	}
+}
+
+// MetricQuery selects, orders and pages metrics
+type MetricQuery struct {
+	Types    []string
+	Names    []string // шаблоны имён в синтаксисе path.Match
+	NameRe   *regexp.Regexp
+	Labels   []LabelMatcher
+	Min, Max *float64
+	Sort     string // name, type, value или updated
+	Order    string // asc или desc
+	Limit    int
+	After    *QueryCursor
+}
+
+// QueryCursor is the position of the last metric of a page. The next page
+// starts right after it, so inserts and deletes never shift the pages
+type QueryCursor struct {
+	Sort    string `json:"s"`
+	Order   string `json:"o"`
+	Type    string `json:"t"`
+	Name    string `json:"n"`
+	Value   string `json:"v,omitempty"` // строкой, чтобы пережить NaN и Inf
+	Updated int64  `json:"u,omitempty"`
+}
+
+// sortKey holds the fields metrics are ordered by
+type sortKey struct {
+	typ, name string
+	value     float64
+	updated   int64
+}
+
+// QueryItem is one metric of a query result
+type QueryItem struct {
+	MetricsJSON
+	UpdatedAt *time.Time `json:"updated_at,omitempty"`
+	Source    string     `json:"source,omitempty"`
+
+	numeric float64
+}
+
+// QueryPage is one page of a query result
+type QueryPage struct {
+	Metrics    []QueryItem `json:"metrics"`
+	NextCursor string      `json:"next_cursor,omitempty"`
+}
+
+// QueryMetrics is an HTTP handler that lists the metrics matching the query
+// string one page at a time, e.g. ?type=gauge&name=Heap*&sort=value&order=desc&limit=50
+func QueryMetrics(s Storager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q, err := ParseMetricQuery(r.URL.Query())
+		if err != nil {
+			WriteProblem(w, r, NewProblem(http.StatusBadRequest, CodeInvalidValue, err.Error()))
+			return
+		}
+
+		// The page depends on the query too, but ETags are kept per URL
+		version, modified := s.Version()
+		if NotModified(w, r, ETag(version), modified) {
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(q.Run(s)); err != nil {
+			log.Printf("Failed to encode query result: %v", err)
+		}
+	}
+}
+
+// ParseMetricQuery reads a query from the type, name, name_re, label,
+// min, max, sort, order, limit and cursor parameters
+func ParseMetricQuery(values url.Values) (*MetricQuery, error) {
+	q := &MetricQuery{
+		Types: splitValues(values["type"]),
+		Names: splitValues(values["name"]),
+		Sort:  values.Get("sort"),
+		Order: values.Get("order"),
+		Limit: defaultQueryLimit,
+	}
+
+	for _, t := range q.Types {
+		if t != "gauge" && t != "counter" {
+			return nil, fmt.Errorf("invalid metric type %q", t)
+		}
+	}
+	for _, pattern := range q.Names {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid name pattern %q", pattern)
+		}
+	}
+	if expr := values.Get("name_re"); expr != "" {
+		re, err := regexp.Compile("^(?:" + expr + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("invalid name regex: %w", err)
+		}
+		q.NameRe = re
+	}
+	for _, raw := range values["label"] {
+		m, err := ParseLabelMatcher(raw)
+		if err != nil {
+			return nil, err
+		}
+		q.Labels = append(q.Labels, m)
+	}
+
+	for _, bound := range []struct {
+		name string
+		dst  **float64
+	}{{"min", &q.Min}, {"max", &q.Max}} {
+		raw := values.Get(bound.name)
+		if raw == "" {
+			continue
+		}
+		v, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s value %q", bound.name, raw)
+		}
+		*bound.dst = &v
+	}
+
+	switch q.Sort {
+	case "":
+		q.Sort = "name"
+	case "name", "type", "value", "updated":
+	default:
+		return nil, fmt.Errorf("invalid sort %q", q.Sort)
+	}
+	switch q.Order {
+	case "":
+		q.Order = "asc"
+	case "asc", "desc":
+	default:
+		return nil, fmt.Errorf("invalid order %q", q.Order)
+	}
+
+	if raw := values.Get("limit"); raw != "" {
+		limit, err := strconv.Atoi(raw)
+		if err != nil || limit <= 0 {
+			return nil, fmt.Errorf("invalid limit %q", raw)
+		}
+		q.Limit = min(limit, maxQueryLimit)
+	}
+
+	if raw := values.Get("cursor"); raw != "" {
+		c, err := decodeCursor(raw)
+		if err != nil || c.Sort != q.Sort || c.Order != q.Order {
+			return nil, fmt.Errorf("invalid cursor")
+		}
+		q.After = c
+	}
+
+	return q, nil
+}
+
+// Match reports whether the metric passes every filter of the query
+func (q *MetricQuery) Match(m MetricsJSON, meta MetricMeta) bool {
+	if len(q.Types) > 0 && !contains(q.Types, m.MType) {
+		return false
+	}
+	if len(q.Names) > 0 {
+		matched := false
+		for _, pattern := range q.Names {
+			if ok, _ := path.Match(pattern, m.ID); ok {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	if q.NameRe != nil && !q.NameRe.MatchString(m.ID) {
+		return false
+	}
+	if q.Min != nil || q.Max != nil {
+		v := numericValue(m)
+		if q.Min != nil && !(v >= *q.Min) {
+			return false
+		}
+		if q.Max != nil && !(v <= *q.Max) {
+			return false
+		}
+	}
+	if len(q.Labels) > 0 {
+		labels := MetricLabels(m, meta)
+		for _, lm := range q.Labels {
+			if !lm.Match(labels) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Run executes the query against the storage. Only the current page is
+// kept in memory while the store is scanned, never a copy of the store
+func (q *MetricQuery) Run(s Storager) QueryPage {
+	h := &pageHeap{query: q}
+	var after sortKey
+	if q.After != nil {
+		after = q.After.key()
+	}
+	s.Range(func(m MetricsJSON, meta MetricMeta) bool {
+		if !q.Match(m, meta) {
+			return true
+		}
+		item := newQueryItem(m, meta)
+		if math.IsNaN(item.numeric) || math.IsInf(item.numeric, 0) {
+			// Such gauges cannot be written as JSON numbers
+			return true
+		}
+		if q.After != nil && q.compare(after, item.key()) >= 0 {
+			return true
+		}
+		// One extra item tells whether there is a next page
+		if h.Len() <= q.Limit {
+			heap.Push(h, item)
+		} else if q.compare(item.key(), h.items[0].key()) < 0 {
+			h.items[0] = item
+			heap.Fix(h, 0)
+		}
+		return true
+	})
+
+	items := make([]QueryItem, h.Len())
+	for i := len(items) - 1; i >= 0; i-- {
+		items[i] = heap.Pop(h).(QueryItem)
+	}
+
+	page := QueryPage{Metrics: items}
+	if len(items) > q.Limit {
+		page.Metrics = items[:q.Limit]
+		last := page.Metrics[q.Limit-1].key()
+		page.NextCursor = encodeCursor(QueryCursor{
+			Sort:    q.Sort,
+			Order:   q.Order,
+			Type:    last.typ,
+			Name:    last.name,
+			Value:   strconv.FormatFloat(last.value, 'g', -1, 64),
+			Updated: last.updated,
+		})
+	}
+	return page
+}
+
+// compare orders two metrics by the sort column, then by name and type,
+// so that no two metrics are ever equal
+func (q *MetricQuery) compare(a, b sortKey) int {
+	c := 0
+	switch q.Sort {
+	case "type":
+		c = cmp.Compare(a.typ, b.typ)
+	case "value":
+		c = cmp.Compare(a.value, b.value)
+	case "updated":
+		c = cmp.Compare(a.updated, b.updated)
+	}
+	if c == 0 {
+		c = cmp.Compare(a.name, b.name)
+	}
+	if c == 0 {
+		c = cmp.Compare(a.typ, b.typ)
+	}
+	if q.Order == "desc" {
+		return -c
+	}
+	return c
+}
+
+func newQueryItem(m MetricsJSON, meta MetricMeta) QueryItem {
+	item := QueryItem{MetricsJSON: m, Source: meta.Source, numeric: numericValue(m)}
+	if !meta.UpdatedAt.IsZero() {
+		updated := meta.UpdatedAt
+		item.UpdatedAt = &updated
+	}
+	return item
+}
+
+func (item QueryItem) key() sortKey {
+	k := sortKey{typ: item.MType, name: item.ID, value: item.numeric}
+	if item.UpdatedAt != nil {
+		k.updated = item.UpdatedAt.UnixNano()
+	}
+	return k
+}
+
+func (c *QueryCursor) key() sortKey {
+	value, _ := strconv.ParseFloat(c.Value, 64)
+	return sortKey{typ: c.Type, name: c.Name, value: value, updated: c.Updated}
+}
+
+func numericValue(m MetricsJSON) float64 {
+	switch {
+	case m.Value != nil:
+		return *m.Value
+	case m.Delta != nil:
+		return float64(*m.Delta)
+	}
+	return math.NaN()
+}
+
+func encodeCursor(c QueryCursor) string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(raw string) (*QueryCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(raw)
+	if err != nil {
+		return nil, err
+	}
+	var c QueryCursor
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, err
+	}
+	if c.Value != "" {
+		if _, err := strconv.ParseFloat(c.Value, 64); err != nil {
+			return nil, err
+		}
+	}
+	return &c, nil
+}
+
+// pageHeap keeps the best items seen so far with the worst one on top,
+// so it can be replaced when a better item comes along
+type pageHeap struct {
+	query *MetricQuery
+	items []QueryItem
+}
+
+func (h *pageHeap) Len() int { return len(h.items) }
+func (h *pageHeap) Less(i, j int) bool {
+	return h.query.compare(h.items[i].key(), h.items[j].key()) > 0
+}
+func (h *pageHeap) Swap(i, j int) { h.items[i], h.items[j] = h.items[j], h.items[i] }
+func (h *pageHeap) Push(x any)    { h.items = append(h.items, x.(QueryItem)) }
+func (h *pageHeap) Pop() any {
+	last := h.items[len(h.items)-1]
+	h.items = h.items[:len(h.items)-1]
+	return last
+}
@@ -0,0 +1,199 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseLabelMatcher(t *testing.T) {
+	labels := map[string]string{"host": "web-1"}
+
+	tests := []struct {
+		raw  string
+		want bool
+	}{
+		{`host=web-1`, true},
+		{`host="web-2"`, false},
+		{`host!=web-2`, true},
+		{`host=~"web.*"`, true},
+		{`host=~web`, false}, // регулярные выражения привязаны к началу и концу
+		{`host!~"db.*"`, true},
+		{`zone=""`, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.raw, func(t *testing.T) {
+			m, err := ParseLabelMatcher(tt.raw)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, m.Match(labels))
+		})
+	}
+
+	for _, raw := range []string{"host", "=web", "host=~[web", "host~web"} {
+		_, err := ParseLabelMatcher(raw)
+		assert.Error(t, err, raw)
+	}
+}
+
+func TestParseMetricQuery_Errors(t *testing.T) {
+	for _, raw := range []string{
+		"type=histogram",
+		"name=[Heap",
+		"name_re=(",
+		"label=host",
+		"min=abc",
+		"sort=size",
+		"order=up",
+		"limit=0",
+		"cursor=%21%21",
+	} {
+		values, _ := url.ParseQuery(raw)
+		_, err := ParseMetricQuery(values)
+		assert.Error(t, err, raw)
+	}
+}
+
+func newQueryMock() *mockStorager {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	m := &mockStorager{
+		gauges:   map[string]float64{},
+		counters: map[string]int64{"PollCount": 7},
+		meta:     map[string]MetricMeta{"counter/PollCount": {UpdatedAt: now, Source: "web-1"}},
+	}
+	for i := 0; i < 25; i++ {
+		name := fmt.Sprintf("Heap%02d", i)
+		m.gauges[name] = float64(i % 5)
+		source := "web-1"
+		if i%2 == 1 {
+			source = "db-1"
+		}
+		m.meta["gauge/"+name] = MetricMeta{UpdatedAt: now.Add(time.Duration(i) * time.Second), Source: source}
+	}
+	return m
+}
+
+func TestMetricQuery_Filters(t *testing.T) {
+	s := newQueryMock()
+
+	tests := []struct {
+		query string
+		want  int
+	}{
+		{"", 26},
+		{"type=counter", 1},
+		{"name=Heap1*", 10},
+		{"name=Heap0*,PollCount", 11},
+		{"name_re=Heap(00|24)", 2},
+		{"label=host=~web.*", 14},
+		{"label=source!=web-1&type=gauge", 12},
+		{"min=3&max=4", 10},
+		{"min=5", 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			values, _ := url.ParseQuery(tt.query + "&limit=1000")
+			q, err := ParseMetricQuery(values)
+			require.NoError(t, err)
+			page := q.Run(s)
+			assert.Len(t, page.Metrics, tt.want)
+			assert.Empty(t, page.NextCursor)
+		})
+	}
+}
+
+func TestMetricQuery_Pagination(t *testing.T) {
+	s := newQueryMock()
+
+	for _, sortBy := range []string{"name", "type", "value", "updated"} {
+		for _, order := range []string{"asc", "desc"} {
+			t.Run(sortBy+" "+order, func(t *testing.T) {
+				all := queryAll(t, s, url.Values{"sort": {sortBy}, "order": {order}, "limit": {"1000"}})
+				require.Len(t, all, 26)
+
+				var paged []string
+				cursor := ""
+				for pages := 0; ; pages++ {
+					require.Less(t, pages, 10, "pagination does not terminate")
+					values := url.Values{"sort": {sortBy}, "order": {order}, "limit": {"4"}}
+					if cursor != "" {
+						values.Set("cursor", cursor)
+					}
+					q, err := ParseMetricQuery(values)
+					require.NoError(t, err)
+					page := q.Run(s)
+					for _, m := range page.Metrics {
+						paged = append(paged, m.MType+"/"+m.ID)
+					}
+					if page.NextCursor == "" {
+						break
+					}
+					cursor = page.NextCursor
+				}
+				assert.Equal(t, all, paged)
+			})
+		}
+	}
+}
+
+func TestMetricQuery_StableCursor(t *testing.T) {
+	s := newQueryMock()
+
+	q, err := ParseMetricQuery(url.Values{"type": {"gauge"}, "limit": {"5"}})
+	require.NoError(t, err)
+	first := q.Run(s)
+	require.Len(t, first.Metrics, 5)
+	assert.Equal(t, "Heap04", first.Metrics[4].ID)
+
+	// Metrics added before the cursor do not shift the next page
+	s.gauges["Heap00a"] = 1
+	q, err = ParseMetricQuery(url.Values{"type": {"gauge"}, "limit": {"5"}, "cursor": {first.NextCursor}})
+	require.NoError(t, err)
+	second := q.Run(s)
+	assert.Equal(t, "Heap05", second.Metrics[0].ID)
+
+	// A cursor only works with the ordering it was made for
+	_, err = ParseMetricQuery(url.Values{"sort": {"value"}, "cursor": {first.NextCursor}})
+	assert.Error(t, err)
+}
+
+func TestQueryMetrics(t *testing.T) {
+	h := QueryMetrics(newQueryMock())
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/metrics?type=gauge&name=Heap*&sort=value&order=desc&limit=3", nil)
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "application/json", rr.Header().Get("Content-Type"))
+
+	var page QueryPage
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &page))
+	require.Len(t, page.Metrics, 3)
+	assert.Equal(t, "Heap24", page.Metrics[0].ID)
+	assert.Equal(t, 4.0, *page.Metrics[0].Value)
+	assert.Equal(t, "web-1", page.Metrics[0].Source)
+	assert.NotEmpty(t, page.NextCursor)
+
+	req = httptest.NewRequest(http.MethodGet, "/api/v1/metrics?limit=-1", nil)
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+}
+
+func queryAll(t *testing.T, s Storager, values url.Values) []string {
+	t.Helper()
+	q, err := ParseMetricQuery(values)
+	require.NoError(t, err)
+	var ids []string
+	for _, m := range q.Run(s).Metrics {
+		ids = append(ids, m.MType+"/"+m.ID)
+	}
+	return ids
+}
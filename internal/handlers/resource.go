@@ -0,0 +1,296 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// maxBatchSize caps the updates of one batch request
+const maxBatchSize = 10000
+
+// metricKind returns the Metricer of a metric type, or nil for unknown types
+func metricKind(metricType string) Metricer {
+	switch metricType {
+	case "gauge":
+		return GaugeMetricType{}
+	case "counter":
+		return CounterMetricType{}
+	}
+	return nil
+}
+
+// checkUpdate validates an update before anything is stored,
+// the problems point into the update object
+func checkUpdate(m MetricsJSON) *Problem {
+	if m.ID == "" {
+		return NewProblem(http.StatusBadRequest, CodeInvalidValue, "metric id is required").
+			AtPointer("/id", "must be a non-empty string")
+	}
+
+	switch m.MType {
+	case "gauge":
+		if m.Value == nil {
+			return NewProblem(http.StatusBadRequest, CodeInvalidValue, "value is required for gauge type").
+				AtPointer("/value", "must be a number")
+		}
+	case "counter":
+		if m.Delta == nil {
+			return NewProblem(http.StatusBadRequest, CodeInvalidValue, "delta is required for counter type").
+				AtPointer("/delta", "must be an integer")
+		}
+	default:
+		return UnknownTypeProblem(m.MType).AtPointer("/type", "must be gauge or counter")
+	}
+	return nil
+}
+
+// withQuota runs store, within the quota of a Limiter storage. Updates
+// that would create more metrics than the storage may hold are rejected
+func withQuota(s Storager, store func() *Problem, updates ...MetricsJSON) *Problem {
+	limiter, ok := s.(Limiter)
+	if !ok {
+		return store()
+	}
+
+	var p *Problem
+	if err := limiter.Admit(updates, func() { p = store() }); err != nil {
+		return NewProblem(http.StatusForbidden, CodeQuotaExceeded, err.Error())
+	}
+	return p
+}
+
+// storeUpdate applies an update that passed checkUpdate and returns
+// the new value of the metric: the gauge value or the counter total
+func storeUpdate(s Storager, m MetricsJSON, source string) (MetricsJSON, *Problem) {
+	switch m.MType {
+	case "gauge":
+		s.SetGauge(m.ID, *m.Value)
+	case "counter":
+		s.SetCounter(m.ID, *m.Delta)
+	}
+	return stored(s, m, source)
+}
+
+// updateMetric is storeUpdate for a single update of a request,
+// a gauge is only set if it still matches the If-Match header
+func updateMetric(s Storager, r *http.Request, m MetricsJSON) (MetricsJSON, *Problem) {
+	version, conditional, p := checkIfMatch(s, r, m)
+	if p != nil {
+		return MetricsJSON{}, p
+	}
+
+	var current MetricsJSON
+	p = withQuota(s, func() (p *Problem) {
+		if !conditional {
+			current, p = storeUpdate(s, m, SourceOf(r))
+			return p
+		}
+		if !s.SetGaugeIfVersion(m.ID, *m.Value, version) {
+			return preconditionFailed(m)
+		}
+		current, p = stored(s, m, SourceOf(r))
+		return p
+	}, m)
+	if p != nil {
+		return MetricsJSON{}, p
+	}
+	return current, nil
+}
+
+// stored records the source of a stored update and reads the new value back
+func stored(s Storager, m MetricsJSON, source string) (MetricsJSON, *Problem) {
+	s.SetSource(m.MType, m.ID, source)
+
+	current, ok := loadMetric(s, m.MType, m.ID)
+	if !ok {
+		return MetricsJSON{}, NewProblem(http.StatusInternalServerError, CodeInternal, "failed to get latest value")
+	}
+	return current, nil
+}
+
+// loadMetric reads a metric of a known type from the storage
+func loadMetric(s Storager, metricType, name string) (MetricsJSON, bool) {
+	m := MetricsJSON{ID: name, MType: metricType}
+	switch metricType {
+	case "gauge":
+		value, ok := s.GetGauge(name)
+		if !ok {
+			return m, false
+		}
+		m.Value = &value
+	case "counter":
+		delta, ok := s.GetCounter(name)
+		if !ok {
+			return m, false
+		}
+		m.Delta = &delta
+	default:
+		return m, false
+	}
+	return m, true
+}
+
+// pathMetricType returns the metricType path parameter, or writes
+// the problem and returns "" when the type is unknown
+func pathMetricType(w http.ResponseWriter, r *http.Request) string {
+	metricType := chi.URLParam(r, "metricType")
+	if metricKind(metricType) == nil {
+		WriteProblem(w, r, UnknownTypeProblem(metricType).AtParameter("metricType", "must be gauge or counter"))
+		return ""
+	}
+	return metricType
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// GetMetric is an HTTP handler that returns one metric with its
+// update time and source, e.g. GET /api/v1/metrics/gauge/Alloc
+func GetMetric(s Storager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		metricType := pathMetricType(w, r)
+		if metricType == "" {
+			return
+		}
+		metricName := chi.URLParam(r, "metricName")
+
+		m, ok := loadMetric(s, metricType, metricName)
+		if !ok {
+			WriteProblem(w, r, NotFoundProblem(metricType, metricName))
+			return
+		}
+		meta, _ := s.GetMeta(metricType, metricName)
+		if NotModified(w, r, ETag(meta.Version), meta.UpdatedAt) {
+			return
+		}
+		writeJSON(w, http.StatusOK, newQueryItem(m, meta))
+	}
+}
+
+// PutMetric is an HTTP handler that updates the metric named by the path
+// with a body like {"value": 1.5} for gauges or {"delta": 3} for counters.
+// The body may repeat id and type, but they must match the path
+func PutMetric(s Storager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		metricType := pathMetricType(w, r)
+		if metricType == "" {
+			return
+		}
+		metricName := chi.URLParam(r, "metricName")
+
+		var m MetricsJSON
+		if err := json.NewDecoder(r.Body).Decode(&m); err != nil {
+			WriteProblem(w, r, DecodeProblem(err))
+			return
+		}
+		if m.ID != "" && m.ID != metricName {
+			WriteProblem(w, r, NewProblem(http.StatusBadRequest, CodeInvalidValue,
+				fmt.Sprintf("body id %q does not match the path", m.ID)).
+				AtPointer("/id", "must be omitted or equal "+strconv.Quote(metricName)))
+			return
+		}
+		if m.MType != "" && m.MType != metricType {
+			WriteProblem(w, r, NewProblem(http.StatusBadRequest, CodeInvalidValue,
+				fmt.Sprintf("body type %q does not match the path", m.MType)).
+				AtPointer("/type", "must be omitted or equal "+strconv.Quote(metricType)))
+			return
+		}
+		m.ID, m.MType = metricName, metricType
+
+		if p := checkUpdate(m); p != nil {
+			WriteProblem(w, r, p)
+			return
+		}
+		current, p := updateMetric(s, r, m)
+		if p != nil {
+			WriteProblem(w, r, p)
+			return
+		}
+		setMetricETag(w, s, current)
+		writeJSON(w, http.StatusOK, current)
+	}
+}
+
+// DeleteMetric is an HTTP handler that removes a metric
+func DeleteMetric(s Storager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		metricType := pathMetricType(w, r)
+		if metricType == "" {
+			return
+		}
+		metricName := chi.URLParam(r, "metricName")
+
+		if !s.Delete(metricType, metricName) {
+			WriteProblem(w, r, NotFoundProblem(metricType, metricName))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// BatchUpdate is an HTTP handler that applies a JSON array of updates.
+// Every update is checked first, so a bad one rejects the whole batch
+// and nothing is stored. The response holds the new values in order
+func BatchUpdate(s Storager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-Match") != "" {
+			WriteProblem(w, r, NewProblem(http.StatusBadRequest, CodeInvalidValue,
+				"If-Match applies to a single metric, use PUT /api/v1/metrics/gauge/{name}").
+				AtParameter("If-Match", "must be omitted for batches"))
+			return
+		}
+
+		var batch []MetricsJSON
+		if err := json.NewDecoder(r.Body).Decode(&batch); err != nil {
+			WriteProblem(w, r, DecodeProblem(err))
+			return
+		}
+		if len(batch) == 0 {
+			WriteProblem(w, r, NewProblem(http.StatusBadRequest, CodeInvalidValue, "batch is empty").
+				AtPointer("", "must have at least 1 item"))
+			return
+		}
+		if len(batch) > maxBatchSize {
+			WriteProblem(w, r, NewProblem(http.StatusRequestEntityTooLarge, CodeInvalidValue,
+				fmt.Sprintf("batch has %d updates, the limit is %d", len(batch), maxBatchSize)))
+			return
+		}
+
+		for i, m := range batch {
+			if p := checkUpdate(m); p != nil {
+				// Point into the array instead of the single update
+				for j := range p.Errors {
+					p.Errors[j].Pointer = "/" + strconv.Itoa(i) + p.Errors[j].Pointer
+				}
+				p.Detail = fmt.Sprintf("update %d: %s", i, p.Detail)
+				WriteProblem(w, r, p)
+				return
+			}
+		}
+
+		source := SourceOf(r)
+		result := make([]MetricsJSON, len(batch))
+		p := withQuota(s, func() *Problem {
+			for i, m := range batch {
+				current, p := storeUpdate(s, m, source)
+				if p != nil {
+					return p
+				}
+				result[i] = current
+			}
+			return nil
+		}, batch...)
+		if p != nil {
+			WriteProblem(w, r, p)
+			return
+		}
+		writeJSON(w, http.StatusOK, result)
+	}
+}
@@ -0,0 +1,147 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newResourceRouter(s Storager) *chi.Mux {
+	r := chi.NewRouter()
+	r.Post("/api/v1/metrics", BatchUpdate(s))
+	r.Get("/api/v1/metrics/{metricType}/{metricName}", GetMetric(s))
+	r.Put("/api/v1/metrics/{metricType}/{metricName}", PutMetric(s))
+	r.Delete("/api/v1/metrics/{metricType}/{metricName}", DeleteMetric(s))
+	return r
+}
+
+func serve(r http.Handler, method, target, body string) *httptest.ResponseRecorder {
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, httptest.NewRequest(method, target, strings.NewReader(body)))
+	return rr
+}
+
+func TestPutMetric(t *testing.T) {
+	s := &mockStorager{gauges: map[string]float64{}, counters: map[string]int64{"Polls": 2}}
+	r := newResourceRouter(s)
+
+	tests := []struct {
+		name    string
+		target  string
+		body    string
+		status  int
+		want    string // response body or problem code
+		pointer string
+	}{
+		{"gauge", "/api/v1/metrics/gauge/Alloc", `{"value": 1.5}`, http.StatusOK, `{"id":"Alloc","type":"gauge","value":1.5}`, ""},
+		{"counter total", "/api/v1/metrics/counter/Polls", `{"delta": 3}`, http.StatusOK, `{"id":"Polls","type":"counter","delta":3}`, ""},
+		{"repeated id and type", "/api/v1/metrics/gauge/Alloc", `{"id": "Alloc", "type": "gauge", "value": 2}`, http.StatusOK, `{"id":"Alloc","type":"gauge","value":2}`, ""},
+		{"other id", "/api/v1/metrics/gauge/Alloc", `{"id": "Frees", "value": 2}`, http.StatusBadRequest, CodeInvalidValue, "/id"},
+		{"other type", "/api/v1/metrics/gauge/Alloc", `{"type": "counter", "delta": 2}`, http.StatusBadRequest, CodeInvalidValue, "/type"},
+		{"missing value", "/api/v1/metrics/gauge/Alloc", `{"delta": 2}`, http.StatusBadRequest, CodeInvalidValue, "/value"},
+		{"missing delta", "/api/v1/metrics/counter/Polls", `{}`, http.StatusBadRequest, CodeInvalidValue, "/delta"},
+		{"unknown type", "/api/v1/metrics/histogram/Alloc", `{"value": 1}`, http.StatusBadRequest, CodeUnknownType, ""},
+		{"malformed", "/api/v1/metrics/gauge/Alloc", `{`, http.StatusBadRequest, CodeInvalidBody, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := serve(r, http.MethodPut, tt.target, tt.body)
+
+			require.Equal(t, tt.status, rr.Code)
+			if tt.status != http.StatusOK {
+				p := assertProblem(t, rr, tt.want)
+				if tt.pointer != "" {
+					require.Len(t, p.Errors, 1)
+					assert.Equal(t, tt.pointer, p.Errors[0].Pointer)
+				}
+				return
+			}
+			assert.JSONEq(t, tt.want, rr.Body.String())
+		})
+	}
+
+	// The counter got 3 once, the failed requests stored nothing
+	assert.Equal(t, int64(3), s.counters["Polls"])
+	assert.Equal(t, 2.0, s.gauges["Alloc"])
+}
+
+func TestGetMetric(t *testing.T) {
+	s := &mockStorager{gauges: map[string]float64{"Alloc": 1.5}, counters: map[string]int64{}}
+	r := newResourceRouter(s)
+
+	rr := serve(r, http.MethodGet, "/api/v1/metrics/gauge/Alloc", "")
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "application/json", rr.Header().Get("Content-Type"))
+	assert.JSONEq(t, `{"id":"Alloc","type":"gauge","value":1.5}`, rr.Body.String())
+
+	rr = serve(r, http.MethodGet, "/api/v1/metrics/counter/Alloc", "")
+	require.Equal(t, http.StatusNotFound, rr.Code)
+	assertProblem(t, rr, CodeNotFound)
+
+	rr = serve(r, http.MethodGet, "/api/v1/metrics/histogram/Alloc", "")
+	require.Equal(t, http.StatusBadRequest, rr.Code)
+	assertProblem(t, rr, CodeUnknownType)
+}
+
+func TestDeleteMetric(t *testing.T) {
+	s := &mockStorager{gauges: map[string]float64{"Alloc": 1.5}, counters: map[string]int64{"Alloc": 1}}
+	r := newResourceRouter(s)
+
+	rr := serve(r, http.MethodDelete, "/api/v1/metrics/gauge/Alloc", "")
+	require.Equal(t, http.StatusNoContent, rr.Code)
+	assert.Empty(t, rr.Body.String())
+	assert.NotContains(t, s.gauges, "Alloc")
+	assert.Contains(t, s.counters, "Alloc")
+
+	rr = serve(r, http.MethodDelete, "/api/v1/metrics/gauge/Alloc", "")
+	require.Equal(t, http.StatusNotFound, rr.Code)
+	assertProblem(t, rr, CodeNotFound)
+}
+
+func TestBatchUpdate(t *testing.T) {
+	t.Run("applies every update", func(t *testing.T) {
+		s := &mockStorager{gauges: map[string]float64{}, counters: map[string]int64{}}
+		r := newResourceRouter(s)
+
+		rr := serve(r, http.MethodPost, "/api/v1/metrics", `[
+			{"id": "Alloc", "type": "gauge", "value": 1.5},
+			{"id": "Polls", "type": "counter", "delta": 2}
+		]`)
+		require.Equal(t, http.StatusOK, rr.Code)
+
+		var got []MetricsJSON
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &got))
+		require.Len(t, got, 2)
+		assert.Equal(t, 1.5, *got[0].Value)
+		assert.Equal(t, int64(2), *got[1].Delta)
+	})
+
+	t.Run("one bad update rejects the batch", func(t *testing.T) {
+		s := &mockStorager{gauges: map[string]float64{}, counters: map[string]int64{}}
+		r := newResourceRouter(s)
+
+		rr := serve(r, http.MethodPost, "/api/v1/metrics", `[
+			{"id": "Alloc", "type": "gauge", "value": 1.5},
+			{"id": "Polls", "type": "counter"}
+		]`)
+		require.Equal(t, http.StatusBadRequest, rr.Code)
+		p := assertProblem(t, rr, CodeInvalidValue)
+		require.Len(t, p.Errors, 1)
+		assert.Equal(t, "/1/delta", p.Errors[0].Pointer)
+		assert.Empty(t, s.gauges)
+	})
+
+	t.Run("empty batch", func(t *testing.T) {
+		s := &mockStorager{gauges: map[string]float64{}, counters: map[string]int64{}}
+		rr := serve(newResourceRouter(s), http.MethodPost, "/api/v1/metrics", `[]`)
+		require.Equal(t, http.StatusBadRequest, rr.Code)
+		assertProblem(t, rr, CodeInvalidValue)
+	})
+}
@@ -0,0 +1,120 @@
+package handlers
+
+import (
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// historyRanges are the time ranges selectable on the detail page
+var historyRanges = []struct {
+	Label    string
+	Duration time.Duration
+}{
+	{"5m", 5 * time.Minute},
+	{"15m", 15 * time.Minute},
+	{"1h", time.Hour},
+	{"6h", 6 * time.Hour},
+	{"24h", 24 * time.Hour},
+}
+
+const defaultHistoryRange = "1h"
+
+// RangeLink is a link switching the chart to another time range
+type RangeLink struct {
+	Label  string
+	URL    string
+	Active bool
+}
+
+// MetricDetailView is the data passed to the metric detail template
+type MetricDetailView struct {
+	Name      string
+	Type      string
+	Value     string
+	Display   string
+	Source    string
+	UpdatedAt time.Time
+	Age       string
+	Ranges    []RangeLink
+	Chart     Chart
+	Points    int
+}
+
+// ShowMetric is an HTTP handler that shows one metric with a chart of its history
+func ShowMetric(s Storager, h Historian, tempFile string) http.HandlerFunc {
+	tmpl, errFunc := ParseTemplate(tempFile)
+	if errFunc != nil {
+		return errFunc
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		metricType := pathMetricType(w, r)
+		if metricType == "" {
+			return
+		}
+		metricName := chi.URLParam(r, "metricName")
+
+		m, ok := loadMetric(s, metricType, metricName)
+		if !ok {
+			WriteProblem(w, r, NotFoundProblem(metricType, metricName))
+			return
+		}
+
+		now := time.Now()
+		view := MetricDetailView{
+			Name: metricName,
+			Type: metricType,
+		}
+		mt := metricKind(metricType)
+		if m.Value != nil {
+			view.Value = mt.FormatValue(*m.Value)
+			view.Display = HumanizeValue(metricName, *m.Value)
+		} else {
+			view.Value = mt.FormatValue(*m.Delta)
+			view.Display = view.Value
+		}
+		if meta, ok := s.GetMeta(metricType, metricName); ok {
+			view.UpdatedAt = meta.UpdatedAt
+			view.Source = meta.Source
+		}
+		view.Age = HumanizeAge(view.UpdatedAt, now)
+
+		selected := r.URL.Query().Get("range")
+		span := time.Duration(0)
+		for _, hr := range historyRanges {
+			if hr.Label == selected {
+				span = hr.Duration
+			}
+		}
+		if span == 0 {
+			selected = defaultHistoryRange
+			span = time.Hour
+		}
+		for _, hr := range historyRanges {
+			view.Ranges = append(view.Ranges, RangeLink{
+				Label:  hr.Label,
+				URL:    "?" + url.Values{"range": {hr.Label}}.Encode(),
+				Active: hr.Label == selected,
+			})
+		}
+
+		since := now.Add(-span)
+		points := h.GetHistory(metricType, metricName, since)
+		view.Points = len(points)
+		view.Chart = NewChart(metricName, points, since, now)
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := tmpl.Execute(w, view); err != nil {
+			WriteProblem(w, r, NewProblem(http.StatusInternalServerError, CodeInternal, "failed to render the page").WithCause(err))
+			return
+		}
+	}
+}
+
+// DetailURL returns the path of the detail page of a metric
+func DetailURL(metricType, name string) string {
+	return "/metric/" + url.PathEscape(metricType) + "/" + url.PathEscape(name)
+}
@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+type mockHistorian struct {
+	points map[string][]HistoryPoint
+}
+
+func (m *mockHistorian) GetHistory(metricType, key string, since time.Time) []HistoryPoint {
+	var result []HistoryPoint
+	for _, p := range m.points[metricType+"/"+key] {
+		if !p.At.Before(since) {
+			result = append(result, p)
+		}
+	}
+	return result
+}
+
+func TestShowMetric(t *testing.T) {
+	now := time.Now()
+	s := newDashboardMock()
+	s.SetSource("gauge", "HeapAlloc", "web-1")
+	h := &mockHistorian{points: map[string][]HistoryPoint{
+		"gauge/HeapAlloc": {
+			{At: now.Add(-2 * time.Hour), Value: 1024},
+			{At: now.Add(-10 * time.Minute), Value: 2048},
+			{At: now.Add(-time.Minute), Value: 4096},
+		},
+	}}
+
+	r := chi.NewRouter()
+	r.Get("/metric/{metricType}/{metricName}", ShowMetric(s, h, "metric.page.tmpl"))
+
+	tests := []struct {
+		name       string
+		url        string
+		wantStatus int
+		wantBody   []string
+	}{
+		{
+			name:       "default range",
+			url:        "/metric/gauge/HeapAlloc",
+			wantStatus: http.StatusOK,
+			wantBody:   []string{"<polyline", "2.00 MiB", "web-1", "2 points", `class="active">1h</a>`},
+		},
+		{
+			name:       "selected range",
+			url:        "/metric/gauge/HeapAlloc?range=24h",
+			wantStatus: http.StatusOK,
+			wantBody:   []string{"3 points", `class="active">24h</a>`},
+		},
+		{
+			name:       "no history in range",
+			url:        "/metric/counter/PollCount?range=5m",
+			wantStatus: http.StatusOK,
+			wantBody:   []string{"No data in this range", "unknown"},
+		},
+		{"unknown metric", "/metric/gauge/Missing", http.StatusNotFound, []string{`"code":"not_found"`}},
+		{"invalid type", "/metric/wrong/HeapAlloc", http.StatusBadRequest, []string{`"code":"unknown_type"`, `"parameter":"metricType"`}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			r.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, tt.url, nil))
+
+			assert.Equal(t, tt.wantStatus, rr.Code)
+			for _, want := range tt.wantBody {
+				assert.Contains(t, rr.Body.String(), want)
+			}
+		})
+	}
+}
+
+func TestNewChart(t *testing.T) {
+	since := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	now := since.Add(time.Hour)
+
+	c := NewChart("RandomValue", []HistoryPoint{
+		{At: since, Value: 0},
+		{At: since.Add(10 * time.Minute), Value: math.NaN()},
+		{At: since.Add(30 * time.Minute), Value: 10},
+		{At: since.Add(40 * time.Minute), Value: math.Inf(1)},
+		{At: now, Value: 5},
+	}, since, now)
+
+	assert.False(t, c.Empty)
+	assert.Equal(t, "0", c.MinLabel)
+	assert.Equal(t, "10", c.MaxLabel)
+	points := strings.Split(c.Points, " ")
+	assert.Equal(t, []string{"10.0,230.0", "360.0,10.0", "710.0,120.0"}, points)
+
+	flat := NewChart("RandomValue", []HistoryPoint{{At: since, Value: 3}}, since, now)
+	assert.Equal(t, "10.0,120.0", flat.Points)
+
+	assert.True(t, NewChart("RandomValue", nil, since, now).Empty)
+	assert.True(t, NewChart("RandomValue", []HistoryPoint{{At: since, Value: math.Inf(-1)}}, since, now).Empty)
+}
@@ -5,13 +5,54 @@ import (
 	"html/template"
 	"log"
 	"net/http"
+	"net/url"
 	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 )
 
 //go:embed templates/*
 var templates embed.FS
 
-// ShowMetrics is an HTTP handler that shows all the metrics
+// maxRefresh caps the auto-refresh interval of the dashboard, in seconds
+const maxRefresh = 3600
+
+// MetricRow is one line of the dashboard table
+type MetricRow struct {
+	Name      string
+	Type      string
+	URL       string  // ссылка на страницу метрики
+	Value     string  // точное значение, как его отдаёт /value/
+	Display   string  // значение в удобных единицах
+	Numeric   float64 // значение для сортировки
+	UpdatedAt time.Time
+	Age       string
+}
+
+// MetricGroup is a set of rows sharing a name prefix
+type MetricGroup struct {
+	Name string
+	Rows []MetricRow
+}
+
+// DashboardView is the data passed to the dashboard template
+type DashboardView struct {
+	Query   string
+	Sort    string
+	Order   string
+	Grouped bool
+	Refresh int
+	Groups  []MetricGroup
+	Total   int
+	Shown   int
+	Now     time.Time
+}
+
+// ShowMetrics is an HTTP handler that shows all the metrics.
+// Clients that prefer another format than HTML get an export instead,
+// clients whose Accept header matches nothing still get the HTML page
 func ShowMetrics(s Storager, tempFile string) http.HandlerFunc {
 	// Parse the template file
 	tmpl, errFunc := ParseTemplate(tempFile)
@@ -21,30 +62,207 @@ func ShowMetrics(s Storager, tempFile string) http.HandlerFunc {
 
 	// Return the actual handler function
 	return func(w http.ResponseWriter, r *http.Request) {
+		format := NegotiateFormat(r, FormatHTML, FormatJSON, FormatCSV, FormatNDJSON, FormatText)
+		if format == "" {
+			format = FormatHTML
+		}
+		// Dashboards poll the page, most polls find nothing new
+		w.Header().Add("Vary", "Accept")
+		if notModifiedSince(w, r, s, format) {
+			return
+		}
+		if format != FormatHTML {
+			WriteExport(w, s, format)
+			return
+		}
+
 		// Set the content type
 		w.Header().Set("Content-Type", "text/html; charset=utf-8")
-		// Create a map of maps to hold the metrics
-		data := map[string]interface{}{
-			"GaugeMetrics":   GaugeMetricType{}.GetAll(s),
-			"CounterMetrics": CounterMetricType{}.GetAll(s),
-		}
+
+		view := NewDashboardView(r.URL.Query(), time.Now())
+		view.Fill(CollectRows(s, view.Now))
 
 		// Execute the template with the data
-		err := tmpl.Execute(w, data)
+		err := tmpl.Execute(w, view)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			WriteProblem(w, r, NewProblem(http.StatusInternalServerError, CodeInternal, "failed to render the page").WithCause(err))
 			return
 		}
 	}
 }
 
+// CollectRows reads every gauge and counter from the storage
+func CollectRows(s Storager, now time.Time) []MetricRow {
+	var rows []MetricRow
+
+	for _, item := range []struct {
+		name string
+		mt   Metricer
+	}{
+		{"gauge", GaugeMetricType{}},
+		{"counter", CounterMetricType{}},
+	} {
+		for name, value := range item.mt.GetAll(s) {
+			row := MetricRow{
+				Name:  name,
+				Type:  item.name,
+				URL:   DetailURL(item.name, name),
+				Value: item.mt.FormatValue(value),
+			}
+			switch v := value.(type) {
+			case float64:
+				row.Numeric = v
+				row.Display = HumanizeValue(name, v)
+			case int64:
+				row.Numeric = float64(v)
+				row.Display = row.Value
+			}
+			if meta, ok := s.GetMeta(item.name, name); ok {
+				row.UpdatedAt = meta.UpdatedAt
+			}
+			row.Age = HumanizeAge(row.UpdatedAt, now)
+			rows = append(rows, row)
+		}
+	}
+
+	return rows
+}
+
+// NewDashboardView reads the search, sorting, grouping
+// and refresh settings from the query string
+func NewDashboardView(q url.Values, now time.Time) *DashboardView {
+	v := &DashboardView{
+		Query: strings.TrimSpace(q.Get("q")),
+		Sort:  q.Get("sort"),
+		Order: q.Get("order"),
+		Now:   now,
+	}
+
+	switch v.Sort {
+	case "name", "type", "value", "updated":
+	default:
+		v.Sort = "name"
+	}
+	if v.Order != "desc" {
+		v.Order = "asc"
+	}
+
+	switch q.Get("group") {
+	case "1", "on", "true":
+		v.Grouped = true
+	}
+
+	if refresh, err := strconv.Atoi(q.Get("refresh")); err == nil && refresh > 0 {
+		v.Refresh = min(refresh, maxRefresh)
+	}
+
+	return v
+}
+
+// Fill filters, sorts and groups the rows according to the view settings
+func (v *DashboardView) Fill(rows []MetricRow) {
+	v.Total = len(rows)
+
+	query := strings.ToLower(v.Query)
+	filtered := rows[:0]
+	for _, row := range rows {
+		if query == "" || strings.Contains(strings.ToLower(row.Name), query) {
+			filtered = append(filtered, row)
+		}
+	}
+	v.Shown = len(filtered)
+
+	sort.SliceStable(filtered, func(i, j int) bool {
+		a, b := filtered[i], filtered[j]
+		if v.Order == "desc" {
+			a, b = b, a
+		}
+		switch v.Sort {
+		case "type":
+			if a.Type != b.Type {
+				return a.Type < b.Type
+			}
+		case "value":
+			if a.Numeric != b.Numeric {
+				return a.Numeric < b.Numeric
+			}
+		case "updated":
+			if !a.UpdatedAt.Equal(b.UpdatedAt) {
+				return a.UpdatedAt.Before(b.UpdatedAt)
+			}
+		}
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return a.Type < b.Type
+	})
+
+	if !v.Grouped {
+		v.Groups = []MetricGroup{{Rows: filtered}}
+		return
+	}
+
+	index := make(map[string]int)
+	v.Groups = nil
+	for _, row := range filtered {
+		prefix := NamePrefix(row.Name)
+		i, ok := index[prefix]
+		if !ok {
+			i = len(v.Groups)
+			index[prefix] = i
+			v.Groups = append(v.Groups, MetricGroup{Name: prefix})
+		}
+		v.Groups[i].Rows = append(v.Groups[i].Rows, row)
+	}
+	sort.SliceStable(v.Groups, func(i, j int) bool {
+		return v.Groups[i].Name < v.Groups[j].Name
+	})
+}
+
+// SortURL returns the link of a column header: sorting by that column,
+// or flipping the order if the table is already sorted by it
+func (v *DashboardView) SortURL(column string) string {
+	order := "asc"
+	if v.Sort == column && v.Order == "asc" {
+		order = "desc"
+	}
+	return "?" + v.values(column, order).Encode()
+}
+
+// SortMark returns an arrow for the column the table is sorted by
+func (v *DashboardView) SortMark(column string) string {
+	if v.Sort != column {
+		return ""
+	}
+	if v.Order == "desc" {
+		return "▼"
+	}
+	return "▲"
+}
+
+func (v *DashboardView) values(sortBy, order string) url.Values {
+	q := url.Values{}
+	if v.Query != "" {
+		q.Set("q", v.Query)
+	}
+	q.Set("sort", sortBy)
+	q.Set("order", order)
+	if v.Grouped {
+		q.Set("group", "1")
+	}
+	if v.Refresh > 0 {
+		q.Set("refresh", strconv.Itoa(v.Refresh))
+	}
+	return q
+}
+
 // ParseTemplate parses the template file and returns the parsed template
 func ParseTemplate(tempFile string) (*template.Template, func(w http.ResponseWriter, r *http.Request)) {
-	tmpl, err := template.ParseFS(templates, filepath.Join("templates", tempFile))
+	tmpl, err := template.New(tempFile).ParseFS(templates, filepath.Join("templates", tempFile))
 	if err != nil {
 		log.Printf("Error parsing template: %v", err)
 		return nil, func(w http.ResponseWriter, r *http.Request) {
-			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			WriteProblem(w, r, NewProblem(http.StatusInternalServerError, CodeInternal, "page template is not available").WithCause(err))
 		}
 	}
 	return tmpl, nil
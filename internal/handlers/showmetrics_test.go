@@ -0,0 +1,112 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newDashboardMock() *mockStorager {
+	now := time.Now()
+	return &mockStorager{
+		gauges: map[string]float64{
+			"HeapAlloc":   2 * 1024 * 1024,
+			"HeapSys":     8 * 1024 * 1024,
+			"RandomValue": 0.25,
+		},
+		counters: map[string]int64{
+			"PollCount": 42,
+		},
+		meta: map[string]MetricMeta{
+			"gauge/HeapAlloc":   {UpdatedAt: now.Add(-3 * time.Second)},
+			"gauge/HeapSys":     {UpdatedAt: now.Add(-time.Minute)},
+			"gauge/RandomValue": {UpdatedAt: now.Add(-time.Hour)},
+			"counter/PollCount": {UpdatedAt: now},
+		},
+	}
+}
+
+func rowNames(v *DashboardView) []string {
+	var names []string
+	for _, g := range v.Groups {
+		for _, row := range g.Rows {
+			names = append(names, row.Name)
+		}
+	}
+	return names
+}
+
+func TestDashboardView_Fill(t *testing.T) {
+	tests := []struct {
+		name      string
+		query     string
+		wantNames []string
+		wantShown int
+	}{
+		{"default sort by name", "", []string{"HeapAlloc", "HeapSys", "PollCount", "RandomValue"}, 4},
+		{"filter is case-insensitive", "q=heap", []string{"HeapAlloc", "HeapSys"}, 2},
+		{"sort by value descending", "sort=value&order=desc", []string{"HeapSys", "HeapAlloc", "PollCount", "RandomValue"}, 4},
+		{"sort by last update", "sort=updated", []string{"RandomValue", "HeapSys", "HeapAlloc", "PollCount"}, 4},
+		{"unknown sort falls back to name", "sort=bogus&order=desc", []string{"RandomValue", "PollCount", "HeapSys", "HeapAlloc"}, 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q, err := url.ParseQuery(tt.query)
+			assert.NoError(t, err)
+
+			now := time.Now()
+			v := NewDashboardView(q, now)
+			v.Fill(CollectRows(newDashboardMock(), now))
+
+			assert.Equal(t, tt.wantNames, rowNames(v))
+			assert.Equal(t, 4, v.Total)
+			assert.Equal(t, tt.wantShown, v.Shown)
+		})
+	}
+}
+
+func TestDashboardView_Grouping(t *testing.T) {
+	now := time.Now()
+	v := NewDashboardView(url.Values{"group": {"1"}}, now)
+	v.Fill(CollectRows(newDashboardMock(), now))
+
+	var groups []string
+	for _, g := range v.Groups {
+		groups = append(groups, g.Name)
+	}
+	assert.Equal(t, []string{"Heap", "Poll", "Random"}, groups)
+	assert.Len(t, v.Groups[0].Rows, 2)
+}
+
+func TestDashboardView_SortURL(t *testing.T) {
+	v := NewDashboardView(url.Values{"q": {"heap"}, "sort": {"value"}, "refresh": {"10"}}, time.Now())
+
+	assert.Equal(t, "?order=desc&q=heap&refresh=10&sort=value", v.SortURL("value"))
+	assert.Equal(t, "?order=asc&q=heap&refresh=10&sort=name", v.SortURL("name"))
+	assert.Equal(t, "▲", v.SortMark("value"))
+	assert.Equal(t, "", v.SortMark("name"))
+}
+
+func TestShowMetrics(t *testing.T) {
+	handler := ShowMetrics(newDashboardMock(), "metrix.page.tmpl")
+
+	req := httptest.NewRequest(http.MethodGet, "/?q=heap&refresh=5", nil)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "text/html; charset=utf-8", rr.Header().Get("Content-Type"))
+
+	body := rr.Body.String()
+	assert.Contains(t, body, "<table>")
+	assert.Contains(t, body, "2.00 MiB")
+	assert.Contains(t, body, `<meta http-equiv="refresh" content="5">`)
+	assert.Contains(t, body, "Showing 2 of 4 metrics")
+	assert.False(t, strings.Contains(body, "PollCount"), "filtered rows must not be rendered")
+}
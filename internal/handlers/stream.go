@@ -0,0 +1,262 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// streamBuffer is how many events a slow client may fall behind
+	// before new events for it are dropped
+	streamBuffer = 256
+
+	heartbeatInterval = 15 * time.Second
+	wsWriteTimeout    = 10 * time.Second
+)
+
+// StreamFilter selects the metric changes a client wants to receive.
+// Empty lists match everything
+type StreamFilter struct {
+	Names []string `json:"names"` // шаблоны имён, например Heap*
+	Types []string `json:"types"` // gauge и/или counter
+}
+
+// ParseStreamFilter reads the filter from the "name" and "type" query
+// parameters. Both may be repeated or hold comma-separated values
+func ParseStreamFilter(q url.Values) (StreamFilter, error) {
+	f := StreamFilter{
+		Names: splitValues(q["name"]),
+		Types: splitValues(q["type"]),
+	}
+	return f, f.Validate()
+}
+
+// Validate checks the name patterns and metric types of the filter
+func (f StreamFilter) Validate() error {
+	for _, pattern := range f.Names {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid name pattern %q", pattern)
+		}
+	}
+	for _, t := range f.Types {
+		if t != "gauge" && t != "counter" {
+			return fmt.Errorf("invalid metric type %q", t)
+		}
+	}
+	return nil
+}
+
+// Match reports whether the change passes the filter
+func (f StreamFilter) Match(m MetricsJSON) bool {
+	if len(f.Types) > 0 && !contains(f.Types, m.MType) {
+		return false
+	}
+	if len(f.Names) == 0 {
+		return true
+	}
+	for _, pattern := range f.Names {
+		if ok, _ := path.Match(pattern, m.ID); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// StreamSSE is an HTTP handler that pushes metric changes as Server-Sent Events
+func StreamSSE(s Storager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		filter, err := ParseStreamFilter(r.URL.Query())
+		if err != nil {
+			WriteProblem(w, r, NewProblem(http.StatusBadRequest, CodeInvalidValue, err.Error()))
+			return
+		}
+
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			WriteProblem(w, r, NewProblem(http.StatusInternalServerError, CodeInternal, "streaming is not supported"))
+			return
+		}
+
+		sub := newSubscription(s, filter)
+		defer sub.close()
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprint(w, ": connected\n\n")
+		flusher.Flush()
+
+		heartbeat := time.NewTicker(heartbeatInterval)
+		defer heartbeat.Stop()
+
+		var id uint64
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-heartbeat.C:
+				fmt.Fprint(w, ": ping\n\n")
+			case event := <-sub.events:
+				data, err := json.Marshal(event)
+				if err != nil {
+					log.Printf("Failed to marshal metric event: %v", err)
+					continue
+				}
+				id++
+				fmt.Fprintf(w, "id: %d\nevent: metric\ndata: %s\n\n", id, data)
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+}
+
+// StreamWS is an HTTP handler that pushes metric changes over a WebSocket.
+// The client may replace its filter at any time by sending a StreamFilter
+// as a JSON text message
+func StreamWS(s Storager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		filter, err := ParseStreamFilter(r.URL.Query())
+		if err != nil {
+			WriteProblem(w, r, NewProblem(http.StatusBadRequest, CodeInvalidValue, err.Error()))
+			return
+		}
+
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			// Upgrade has already answered the client
+			log.Printf("Failed to upgrade to websocket: %v", err)
+			return
+		}
+		defer conn.Close()
+
+		sub := newSubscription(s, filter)
+		defer sub.close()
+
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			for {
+				var f StreamFilter
+				if err := conn.ReadJSON(&f); err != nil {
+					return
+				}
+				if err := f.Validate(); err != nil {
+					log.Printf("Ignoring invalid websocket filter: %v", err)
+					continue
+				}
+				sub.setFilter(f)
+			}
+		}()
+
+		heartbeat := time.NewTicker(heartbeatInterval)
+		defer heartbeat.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-r.Context().Done():
+				// The server is shutting down
+				conn.WriteControl(websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.CloseGoingAway, "server is shutting down"),
+					time.Now().Add(wsWriteTimeout))
+				return
+			case <-heartbeat.C:
+				if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteTimeout)); err != nil {
+					return
+				}
+			case event := <-sub.events:
+				conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+				if err := conn.WriteJSON(event); err != nil {
+					return
+				}
+			}
+		}
+	}
+}
+
+// subscription buffers the changes matching a filter for one client
+type subscription struct {
+	events      chan MetricsJSON
+	unsubscribe func()
+
+	mu      sync.Mutex
+	filter  StreamFilter
+	dropped int
+}
+
+func newSubscription(s Storager, filter StreamFilter) *subscription {
+	sub := &subscription{
+		events: make(chan MetricsJSON, streamBuffer),
+		filter: filter,
+	}
+	sub.unsubscribe = s.Subscribe(sub.push)
+	return sub
+}
+
+// push runs on the goroutine that updated the storage, so it never blocks
+func (sub *subscription) push(m MetricsJSON) {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+
+	if !sub.filter.Match(m) {
+		return
+	}
+	select {
+	case sub.events <- m:
+	default:
+		sub.dropped++
+	}
+}
+
+func (sub *subscription) setFilter(f StreamFilter) {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+	sub.filter = f
+}
+
+func (sub *subscription) close() {
+	sub.unsubscribe()
+
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+	if sub.dropped > 0 {
+		log.Printf("Stream client fell behind, %d events dropped", sub.dropped)
+	}
+}
+
+func splitValues(values []string) []string {
+	var result []string
+	for _, v := range values {
+		for _, part := range strings.Split(v, ",") {
+			if part = strings.TrimSpace(part); part != "" {
+				result = append(result, part)
+			}
+		}
+	}
+	return result
+}
+
+func contains(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
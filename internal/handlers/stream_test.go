@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStreamFilter(t *testing.T) {
+	f, err := ParseStreamFilter(url.Values{"name": {"Heap*,NumGC"}, "type": {"gauge"}})
+	require.NoError(t, err)
+
+	gauge := func(id string) MetricsJSON { return MetricsJSON{ID: id, MType: "gauge"} }
+
+	assert.True(t, f.Match(gauge("HeapAlloc")))
+	assert.True(t, f.Match(gauge("NumGC")))
+	assert.False(t, f.Match(gauge("Alloc")))
+	assert.False(t, f.Match(MetricsJSON{ID: "HeapAlloc", MType: "counter"}))
+
+	all, err := ParseStreamFilter(url.Values{})
+	require.NoError(t, err)
+	assert.True(t, all.Match(MetricsJSON{ID: "PollCount", MType: "counter"}))
+
+	_, err = ParseStreamFilter(url.Values{"type": {"histogram"}})
+	assert.Error(t, err)
+	_, err = ParseStreamFilter(url.Values{"name": {"[Heap"}})
+	assert.Error(t, err)
+}
+
+func TestStreamSSE(t *testing.T) {
+	s := &mockStorager{gauges: map[string]float64{}, counters: map[string]int64{}}
+	server := httptest.NewServer(StreamSSE(s))
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"?name=Heap*", nil)
+	require.NoError(t, err)
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	reader := bufio.NewReader(resp.Body)
+	line, err := reader.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, ": connected\n", line)
+
+	require.Eventually(t, func() bool { return s.subscribers() == 1 }, time.Second, 10*time.Millisecond)
+	s.SetGauge("Alloc", 1)
+	s.SetGauge("HeapAlloc", 2)
+
+	var data string
+	for data == "" {
+		line, err := reader.ReadString('\n')
+		require.NoError(t, err)
+		if strings.HasPrefix(line, "data: ") {
+			data = strings.TrimSpace(strings.TrimPrefix(line, "data: "))
+		}
+	}
+
+	var event MetricsJSON
+	require.NoError(t, json.Unmarshal([]byte(data), &event))
+	assert.Equal(t, "HeapAlloc", event.ID, "Alloc must be filtered out")
+	assert.Equal(t, 2.0, *event.Value)
+}
+
+func TestStreamSSE_InvalidFilter(t *testing.T) {
+	s := &mockStorager{gauges: map[string]float64{}, counters: map[string]int64{}}
+
+	rr := httptest.NewRecorder()
+	StreamSSE(s).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/stream?type=bogus", nil))
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+}
+
+func TestStreamWS(t *testing.T) {
+	s := &mockStorager{gauges: map[string]float64{}, counters: map[string]int64{}}
+	server := httptest.NewServer(StreamWS(s))
+	defer server.Close()
+
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + "?type=counter"
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	require.Eventually(t, func() bool { return s.subscribers() == 1 }, time.Second, 10*time.Millisecond)
+	s.SetGauge("Alloc", 1)
+	s.SetCounter("PollCount", 3)
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var event MetricsJSON
+	require.NoError(t, conn.ReadJSON(&event))
+	assert.Equal(t, "PollCount", event.ID)
+	assert.Equal(t, int64(3), *event.Delta)
+
+	// Switch the subscription to gauges only
+	require.NoError(t, conn.WriteJSON(StreamFilter{Types: []string{"gauge"}}))
+	require.Eventually(t, func() bool {
+		s.SetGauge("Alloc", 5)
+		conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+		var e MetricsJSON
+		return conn.ReadJSON(&e) == nil && e.ID == "Alloc"
+	}, 5*time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+	"unicode"
+)
+
+// Unit tells the dashboard how to print a metric value
+type Unit int
+
+const (
+	UnitNone Unit = iota
+	UnitBytes
+	UnitNanoseconds
+	UnitTimestampNs
+)
+
+// metricUnits lists the runtime.MemStats gauges the agent reports
+// whose values are not plain numbers
+var metricUnits = map[string]Unit{
+	"Alloc":        UnitBytes,
+	"BuckHashSys":  UnitBytes,
+	"GCSys":        UnitBytes,
+	"HeapAlloc":    UnitBytes,
+	"HeapIdle":     UnitBytes,
+	"HeapInuse":    UnitBytes,
+	"HeapReleased": UnitBytes,
+	"HeapSys":      UnitBytes,
+	"MCacheInuse":  UnitBytes,
+	"MCacheSys":    UnitBytes,
+	"MSpanInuse":   UnitBytes,
+	"MSpanSys":     UnitBytes,
+	"NextGC":       UnitBytes,
+	"OtherSys":     UnitBytes,
+	"StackInuse":   UnitBytes,
+	"StackSys":     UnitBytes,
+	"Sys":          UnitBytes,
+	"TotalAlloc":   UnitBytes,
+	"PauseTotalNs": UnitNanoseconds,
+	"LastGC":       UnitTimestampNs,
+}
+
+// UnitOf returns the unit of a metric by its name
+func UnitOf(name string) Unit {
+	return metricUnits[name]
+}
+
+// HumanizeValue prints a value in the unit of the metric:
+// bytes as KiB/MiB/GiB, nanoseconds as a duration,
+// nanosecond timestamps as a date and everything else as a plain number
+func HumanizeValue(name string, value float64) string {
+	switch UnitOf(name) {
+	case UnitBytes:
+		return humanizeBytes(value)
+	case UnitNanoseconds:
+		return time.Duration(value).String()
+	case UnitTimestampNs:
+		if value == 0 {
+			return "never"
+		}
+		return time.Unix(0, int64(value)).UTC().Format(time.RFC3339)
+	default:
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	}
+}
+
+func humanizeBytes(value float64) string {
+	const unit = 1024
+	if value < unit {
+		return fmt.Sprintf("%.0f B", value)
+	}
+	div, exp := float64(unit), 0
+	for n := value / unit; n >= unit && exp < 4; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.2f %ciB", value/div, "KMGTP"[exp])
+}
+
+// HumanizeAge prints how long ago t was, e.g. "5s ago"
+func HumanizeAge(t, now time.Time) string {
+	if t.IsZero() {
+		return "unknown"
+	}
+	age := now.Sub(t)
+	if age < time.Second {
+		return "just now"
+	}
+	return age.Truncate(time.Second).String() + " ago"
+}
+
+// NamePrefix returns the group a metric belongs to on the dashboard:
+// the first word of a CamelCase name, so HeapAlloc and HeapSys
+// both land in "Heap"
+func NamePrefix(name string) string {
+	runes := []rune(name)
+	for i := 1; i < len(runes); i++ {
+		if unicode.IsUpper(runes[i]) && unicode.IsLower(runes[i-1]) {
+			return string(runes[:i])
+		}
+	}
+	return name
+}
@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHumanizeValue(t *testing.T) {
+	tests := []struct {
+		name  string
+		value float64
+		want  string
+	}{
+		{"HeapAlloc", 512, "512 B"},
+		{"HeapAlloc", 1536, "1.50 KiB"},
+		{"Sys", 3 * 1024 * 1024 * 1024, "3.00 GiB"},
+		{"PauseTotalNs", 1500000, "1.5ms"},
+		{"LastGC", 0, "never"},
+		{"LastGC", 1704067200000000000, "2024-01-01T00:00:00Z"},
+		{"RandomValue", 0.125, "0.125"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, HumanizeValue(tt.name, tt.value))
+		})
+	}
+}
+
+func TestHumanizeAge(t *testing.T) {
+	now := time.Now()
+	assert.Equal(t, "unknown", HumanizeAge(time.Time{}, now))
+	assert.Equal(t, "just now", HumanizeAge(now, now))
+	assert.Equal(t, "1m30s ago", HumanizeAge(now.Add(-90*time.Second), now))
+}
+
+func TestNamePrefix(t *testing.T) {
+	tests := map[string]string{
+		"HeapAlloc":     "Heap",
+		"MSpanInuse":    "MSpan",
+		"PollCount":     "Poll",
+		"GCSys":         "GCSys",
+		"Alloc":         "Alloc",
+		"gauge_counter": "gauge_counter",
+	}
+	for name, want := range tests {
+		assert.Equal(t, want, NamePrefix(name), name)
+	}
+}
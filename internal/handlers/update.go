@@ -2,7 +2,8 @@ package handlers
 
 import (
 	"encoding/json"
-	"log"
+	"fmt"
+	"net"
 	"net/http"
 
 	"github.com/go-chi/chi/v5"
@@ -10,37 +11,62 @@ import (
 
 func HandleUpdateText(s Storager) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		metricType := chi.URLParam(r, "metricType")
+		metricType := pathMetricType(w, r)
+		if metricType == "" {
+			return
+		}
 		metricName := chi.URLParam(r, "metricName")
 		metricValue := chi.URLParam(r, "metricValue")
 
-		var mt Metricer
-		switch metricType {
-		case "gauge":
-			mt = GaugeMetricType{}
-		case "counter":
-			mt = CounterMetricType{}
-		default:
-			log.Printf("Invalid metric type: %s", metricType)
-			http.Error(w, "Invalid metric type", http.StatusBadRequest)
+		value, err := metricKind(metricType).ParseValue(metricValue)
+		if err != nil {
+			WriteProblem(w, r, NewProblem(http.StatusBadRequest, CodeInvalidValue,
+				fmt.Sprintf("%q is not a valid %s value", metricValue, metricType)).
+				AtParameter("metricValue", valueHint(metricType)).
+				WithCause(err))
 			return
 		}
 
-		value, err := mt.ParseValue(metricValue)
-		if err != nil {
-			logAndRespondError(w, err, "Invalid metric value", http.StatusBadRequest)
+		m := MetricsJSON{ID: metricName, MType: metricType}
+		switch v := value.(type) {
+		case float64:
+			m.Value = &v
+		case int64:
+			m.Delta = &v
+		}
+		current, p := updateMetric(s, r, m)
+		if p != nil {
+			WriteProblem(w, r, p)
 			return
 		}
 
-		mt.Store(s, metricName, value)
-
+		setMetricETag(w, s, current)
 		w.WriteHeader(http.StatusOK)
 	}
 }
 
-func logAndRespondError(w http.ResponseWriter, err error, message string, code int) {
-	log.Printf("%s: %s", message, err)
-	http.Error(w, message, code)
+// AgentIDHeader names the agent that sent an update
+const AgentIDHeader = "X-Agent-ID"
+
+// SourceOf returns the agent ID of the request, or the client address
+// for clients that do not send one
+func SourceOf(r *http.Request) string {
+	if id := r.Header.Get(AgentIDHeader); id != "" {
+		return id
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// valueHint describes the values a metric type accepts
+func valueHint(metricType string) string {
+	if metricType == "counter" {
+		return "must be an integer"
+	}
+	return "must be a number"
 }
 
 func HandleUpdateJSON(s Storager) http.HandlerFunc {
@@ -48,68 +74,22 @@ func HandleUpdateJSON(s Storager) http.HandlerFunc {
 		var metrics MetricsJSON
 		err := json.NewDecoder(r.Body).Decode(&metrics)
 		if err != nil {
-			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			WriteProblem(w, r, DecodeProblem(err))
 			return
 		}
 
-		if metrics.ID == "" {
-			http.Error(w, "Missing id", http.StatusBadRequest)
+		if p := checkUpdate(metrics); p != nil {
+			WriteProblem(w, r, p)
 			return
 		}
 
-		var mt Metricer
-		var value interface{}
-		switch metrics.MType {
-		case "gauge":
-			mt = GaugeMetricType{}
-			if metrics.Value != nil {
-				value = *metrics.Value
-			} else {
-				http.Error(w, "Value is required for gauge type", http.StatusBadRequest)
-				return
-			}
-		case "counter":
-			mt = CounterMetricType{}
-			if metrics.Delta != nil {
-				value = *metrics.Delta
-			}
-		default:
-			log.Printf("Invalid metric type: %s", metrics.MType)
-			http.Error(w, "Invalid metric type", http.StatusBadRequest)
-			return
-		}
-
-		mt.Store(s, metrics.ID, value)
-
-		// Get the latest value from the storage
-		latestValue, ok := mt.GetValue(s, metrics.ID)
-		if !ok {
-			http.Error(w, "Failed to get latest value", http.StatusInternalServerError)
+		// Respond with the latest value from the storage
+		current, p := updateMetric(s, r, metrics)
+		if p != nil {
+			WriteProblem(w, r, p)
 			return
 		}
-
-		// Update the metrics value based on the type
-		if metrics.MType == "counter" {
-			if val, ok := latestValue.(int64); ok {
-				metrics.Delta = &val
-			} else {
-				log.Printf("Expected *int64, got %T", latestValue)
-				http.Error(w, "Internal server error", http.StatusInternalServerError)
-				return
-			}
-		} else if metrics.MType == "gauge" {
-			if val, ok := latestValue.(float64); ok {
-				metrics.Value = &val
-			} else {
-				log.Printf("Expected *float64, got %T", latestValue)
-				http.Error(w, "Internal server error", http.StatusInternalServerError)
-				return
-			}
-		}
-
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(metrics)
-
-		w.WriteHeader(http.StatusOK)
+		setMetricETag(w, s, current)
+		writeJSON(w, http.StatusOK, current)
 	}
 }
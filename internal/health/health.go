@@ -0,0 +1,165 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// checkTimeout bounds every readiness check, a hanging dependency
+// must not hang the probe
+const checkTimeout = 2 * time.Second
+
+// Check reports why a dependency cannot be used, or nil when it can
+type Check func(ctx context.Context) error
+
+// Checker answers the liveness and readiness probes of an orchestrator
+type Checker struct {
+	started  time.Time
+	draining atomic.Bool
+
+	mu     sync.RWMutex
+	checks map[string]Check
+}
+
+// CheckResult is the outcome of one readiness check
+type CheckResult struct {
+	Name       string  `json:"name"`
+	Status     string  `json:"status"` // ok или failing
+	Error      string  `json:"error,omitempty"`
+	DurationMS float64 `json:"duration_ms"`
+}
+
+// Readiness is the body of /readyz
+type Readiness struct {
+	Status   string        `json:"status"` // ready или not_ready
+	Draining bool          `json:"draining"`
+	Checks   []CheckResult `json:"checks"`
+}
+
+// Liveness is the body of /healthz
+type Liveness struct {
+	Status        string  `json:"status"`
+	UptimeSeconds float64 `json:"uptime_seconds"`
+}
+
+func New() *Checker {
+	return &Checker{
+		started: time.Now(),
+		checks:  make(map[string]Check),
+	}
+}
+
+// Add registers a readiness check under a name, replacing one with the same name
+func (c *Checker) Add(name string, check Check) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.checks[name] = check
+}
+
+// Drain makes the server report not ready, so load balancers stop sending
+// new requests while the ones in flight are finished
+func (c *Checker) Drain() {
+	c.draining.Store(true)
+}
+
+// Ready runs every check concurrently and reports the results by name
+func (c *Checker) Ready(ctx context.Context) Readiness {
+	c.mu.RLock()
+	names := make([]string, 0, len(c.checks))
+	for name := range c.checks {
+		names = append(names, name)
+	}
+	checks := make([]Check, len(names))
+	sort.Strings(names)
+	for i, name := range names {
+		checks[i] = c.checks[name]
+	}
+	c.mu.RUnlock()
+
+	results := make([]CheckResult, len(names))
+	var wg sync.WaitGroup
+	for i := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = run(ctx, names[i], checks[i])
+		}()
+	}
+	wg.Wait()
+
+	readiness := Readiness{Status: "ready", Draining: c.draining.Load(), Checks: results}
+	if readiness.Draining {
+		readiness.Status = "not_ready"
+	}
+	for _, result := range results {
+		if result.Status != "ok" {
+			readiness.Status = "not_ready"
+		}
+	}
+	return readiness
+}
+
+func run(ctx context.Context, name string, check Check) CheckResult {
+	ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+	defer cancel()
+
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() {
+		done <- check(ctx)
+	}()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	result := CheckResult{
+		Name:       name,
+		Status:     "ok",
+		DurationMS: float64(time.Since(start).Microseconds()) / 1000,
+	}
+	if err != nil {
+		result.Status = "failing"
+		result.Error = err.Error()
+	}
+	return result
+}
+
+// HandleLive answers /healthz: the process is up and serving requests
+func (c *Checker) HandleLive() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, Liveness{
+			Status:        "ok",
+			UptimeSeconds: time.Since(c.started).Seconds(),
+		})
+	}
+}
+
+// HandleReady answers /readyz with 200 when every check passes and the
+// server is not draining, and with 503 otherwise
+func (c *Checker) HandleReady() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		readiness := c.Ready(r.Context())
+		status := http.StatusOK
+		if readiness.Status != "ready" {
+			status = http.StatusServiceUnavailable
+		}
+		writeJSON(w, status, readiness)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	// Probes must always see the current state
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
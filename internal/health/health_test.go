@@ -0,0 +1,91 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func readyz(t *testing.T, c *Checker) (int, Readiness) {
+	t.Helper()
+	rr := httptest.NewRecorder()
+	c.HandleReady()(rr, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+	var readiness Readiness
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &readiness))
+	assert.Equal(t, "application/json", rr.Header().Get("Content-Type"))
+	return rr.Code, readiness
+}
+
+func TestHandleLive(t *testing.T) {
+	c := New()
+	c.Add("broken", func(context.Context) error { return errors.New("down") })
+
+	rr := httptest.NewRecorder()
+	c.HandleLive()(rr, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+
+	// Liveness does not depend on the checks
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var live Liveness
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &live))
+	assert.Equal(t, "ok", live.Status)
+}
+
+func TestHandleReady(t *testing.T) {
+	c := New()
+	code, readiness := readyz(t, c)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "ready", readiness.Status)
+	assert.Empty(t, readiness.Checks)
+
+	c.Add("storage", func(context.Context) error { return nil })
+	c.Add("snapshot", func(context.Context) error { return errors.New("disk full") })
+
+	code, readiness = readyz(t, c)
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, "not_ready", readiness.Status)
+	require.Len(t, readiness.Checks, 2)
+	// Checks are listed by name
+	assert.Equal(t, CheckResult{Name: "snapshot", Status: "failing", Error: "disk full"}, withoutDuration(readiness.Checks[0]))
+	assert.Equal(t, CheckResult{Name: "storage", Status: "ok"}, withoutDuration(readiness.Checks[1]))
+}
+
+func TestHandleReady_Draining(t *testing.T) {
+	c := New()
+	c.Add("storage", func(context.Context) error { return nil })
+	c.Drain()
+
+	code, readiness := readyz(t, c)
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.True(t, readiness.Draining)
+	assert.Equal(t, "not_ready", readiness.Status)
+}
+
+func TestReady_Timeout(t *testing.T) {
+	c := New()
+	c.Add("hanging", func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	readiness := c.Ready(ctx)
+	assert.Less(t, time.Since(start), time.Second)
+	assert.Equal(t, "not_ready", readiness.Status)
+	assert.Equal(t, "failing", readiness.Checks[0].Status)
+}
+
+func withoutDuration(r CheckResult) CheckResult {
+	r.DurationMS = 0
+	return r
+}
@@ -0,0 +1,123 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"Vova4o/metrix/internal/audit"
+	"Vova4o/metrix/internal/auth"
+	"Vova4o/metrix/internal/handlers"
+	"Vova4o/metrix/internal/tenant"
+
+	"github.com/go-chi/chi/middleware"
+	"github.com/go-chi/chi/v5"
+)
+
+// Audit records every update or delete of metrics that succeeded: the
+// metrics as the client sent them, its address, agent ID, tenant and token.
+// It has to run inside RequireScope to know the token. The tenant is only
+// recorded when the server has tenants; an update that succeeded has passed
+// Tenant, so the token's tenant or X-Tenant-ID is the one it was stored
+// for. With a nil log nothing is recorded
+func Audit(log *audit.Logger, tenants *tenant.Registry) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if log == nil {
+			return next
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// Keep what the handler reads of the body
+			var body bytes.Buffer
+			r.Body = io.NopCloser(io.TeeReader(r.Body, &body))
+
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+			next.ServeHTTP(ww, r)
+
+			if status := ww.Status(); status != 0 && (status < 200 || status >= 300) {
+				return
+			}
+
+			e := audit.Event{
+				Time:     time.Now().UTC(),
+				Action:   "update",
+				Metrics:  auditedMetrics(r, body.Bytes()),
+				ClientIP: clientIP(r),
+				AgentID:  r.Header.Get(handlers.AgentIDHeader),
+			}
+			if r.Method == http.MethodDelete {
+				e.Action = "delete"
+			}
+			token, ok := auth.FromContext(r.Context())
+			if ok {
+				e.Token = token.Name
+			}
+			if tenants != nil {
+				e.Tenant = strings.TrimSpace(r.Header.Get(tenant.Header))
+				if ok && token.Tenant != "" {
+					e.Tenant = token.Tenant
+				}
+			}
+			log.Record(e)
+		})
+	}
+}
+
+// auditedMetrics reads the metrics of a successful request from its path
+// or body. The body is a single update or, for batches, an array
+func auditedMetrics(r *http.Request, body []byte) []handlers.MetricsJSON {
+	metricType, metricName := chi.URLParam(r, "metricType"), chi.URLParam(r, "metricName")
+	path := handlers.MetricsJSON{ID: metricName, MType: metricType}
+
+	if value := chi.URLParam(r, "metricValue"); value != "" {
+		switch metricType {
+		case "gauge":
+			if v, err := strconv.ParseFloat(value, 64); err == nil {
+				path.Value = &v
+			}
+		case "counter":
+			if v, err := strconv.ParseInt(value, 10, 64); err == nil {
+				path.Delta = &v
+			}
+		}
+		return []handlers.MetricsJSON{path}
+	}
+
+	body = bytes.TrimSpace(body)
+	if len(body) == 0 {
+		return []handlers.MetricsJSON{path}
+	}
+	if body[0] == '[' {
+		var batch []handlers.MetricsJSON
+		json.Unmarshal(body, &batch)
+		return batch
+	}
+
+	var m handlers.MetricsJSON
+	json.Unmarshal(body, &m)
+	if m.ID == "" {
+		m.ID = metricName
+	}
+	if m.MType == "" {
+		m.MType = metricType
+	}
+	return []handlers.MetricsJSON{m}
+}
+
+// clientIP is the address the client reports in X-Real-IP, or the one
+// the request came from
+func clientIP(r *http.Request) string {
+	if ip := strings.TrimSpace(r.Header.Get("X-Real-IP")); ip != "" {
+		return ip
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
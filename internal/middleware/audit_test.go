@@ -0,0 +1,128 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"Vova4o/metrix/internal/audit"
+	"Vova4o/metrix/internal/auth"
+	"Vova4o/metrix/internal/handlers"
+	"Vova4o/metrix/internal/tenant"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuditedMetrics(t *testing.T) {
+	tests := []struct {
+		name    string
+		method  string
+		pattern string
+		target  string
+		body    string
+		want    string
+	}{
+		{"text gauge", http.MethodPost, "/update/{metricType}/{metricName}/{metricValue}", "/update/gauge/Alloc/1.5", "", `[{"id":"Alloc","type":"gauge","value":1.5}]`},
+		{"text counter", http.MethodPost, "/update/{metricType}/{metricName}/{metricValue}", "/update/counter/Polls/3", "", `[{"id":"Polls","type":"counter","delta":3}]`},
+		{"json", http.MethodPost, "/update/", "/update/", `{"id":"Alloc","type":"gauge","value":2}`, `[{"id":"Alloc","type":"gauge","value":2}]`},
+		{"batch", http.MethodPost, "/api/v1/metrics", "/api/v1/metrics", ` [{"id":"A","type":"gauge","value":1},{"id":"B","type":"counter","delta":2}]`, `[{"id":"A","type":"gauge","value":1},{"id":"B","type":"counter","delta":2}]`},
+		{"put", http.MethodPut, "/api/v1/metrics/{metricType}/{metricName}", "/api/v1/metrics/counter/Polls", `{"delta":4}`, `[{"id":"Polls","type":"counter","delta":4}]`},
+		{"delete", http.MethodDelete, "/api/v1/metrics/{metricType}/{metricName}", "/api/v1/metrics/gauge/Alloc", "", `[{"id":"Alloc","type":"gauge"}]`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []handlers.MetricsJSON
+			mux := chi.NewRouter()
+			mux.MethodFunc(tt.method, tt.pattern, func(w http.ResponseWriter, r *http.Request) {
+				got = auditedMetrics(r, []byte(tt.body))
+			})
+			mux.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(tt.method, tt.target, nil))
+
+			assert.JSONEq(t, tt.want, string(mustJSON(t, got)))
+		})
+	}
+}
+
+func TestAudit(t *testing.T) {
+	tenants, err := tenant.New(tenant.Config{Tenants: []tenant.TenantConfig{{Name: "team-a"}}},
+		tenant.Options{HistoryRetention: time.Hour})
+	require.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "audit.log")
+	sink, err := audit.NewFileSink(path, 0, 0)
+	require.NoError(t, err)
+	log := audit.New(sink)
+
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		var m handlers.MetricsJSON
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&m))
+		if m.ID == "" {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}
+	withTenants := chi.NewRouter()
+	withTenants.With(Audit(log, tenants)).Post("/update/", handler)
+	withoutTenants := chi.NewRouter()
+	withoutTenants.With(Audit(log, nil)).Post("/update/", handler)
+
+	post := func(mux http.Handler, body string, token *auth.Token, tenantID string) {
+		req := httptest.NewRequest(http.MethodPost, "/update/", strings.NewReader(body))
+		req.RemoteAddr = "192.168.0.7:51234"
+		req.Header.Set(handlers.AgentIDHeader, "agent-1")
+		if tenantID != "" {
+			req.Header.Set(tenant.Header, tenantID)
+		}
+		if token != nil {
+			req = req.WithContext(auth.WithToken(req.Context(), token))
+		}
+		mux.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	post(withTenants, `{"id":"Alloc","type":"gauge","value":1.5}`, &auth.Token{Name: "agent", Tenant: "team-a"}, "")
+	post(withTenants, `{"type":"gauge","value":1.5}`, nil, "") // rejected, not audited
+	post(withTenants, `{"id":"Heap","type":"gauge","value":1}`, nil, "team-a")
+	// Without tenants the header names nothing the update was stored for
+	post(withoutTenants, `{"id":"Sys","type":"gauge","value":1}`, nil, "team-a")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	log.Run(ctx)
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	require.Len(t, lines, 3)
+
+	var e audit.Event
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &e))
+	assert.Equal(t, "update", e.Action)
+	assert.Equal(t, "192.168.0.7", e.ClientIP)
+	assert.Equal(t, "agent-1", e.AgentID)
+	assert.Equal(t, "agent", e.Token)
+	assert.Equal(t, "team-a", e.Tenant)
+	assert.False(t, e.Time.IsZero())
+	require.Len(t, e.Metrics, 1)
+	assert.Equal(t, "Alloc", e.Metrics[0].ID)
+
+	var byHeader, untenanted audit.Event
+	require.NoError(t, json.Unmarshal([]byte(lines[1]), &byHeader))
+	assert.Equal(t, "team-a", byHeader.Tenant)
+	require.NoError(t, json.Unmarshal([]byte(lines[2]), &untenanted))
+	assert.Equal(t, "Sys", untenanted.Metrics[0].ID)
+	assert.Empty(t, untenanted.Tenant)
+}
+
+func mustJSON(t *testing.T, v interface{}) []byte {
+	t.Helper()
+	data, err := json.Marshal(v)
+	require.NoError(t, err)
+	return data
+}
@@ -0,0 +1,60 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"Vova4o/metrix/internal/auth"
+	"Vova4o/metrix/internal/handlers"
+)
+
+// RequireScope lets through requests with a bearer token that grants scope.
+// Requests without a known token are answered with 401, tokens without the
+// scope with 403 (RFC 6750). With nil tokens nothing is checked
+func RequireScope(tokens *auth.Tokens, scope auth.Scope) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if tokens == nil {
+			return next
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			secret, ok := bearerToken(r.Header.Get("Authorization"))
+			if !ok {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="metrix"`)
+				handlers.WriteProblem(w, r, handlers.NewProblem(http.StatusUnauthorized, handlers.CodeUnauthorized,
+					"a bearer token is required").
+					AtParameter("Authorization", "must be Bearer and the token"))
+				return
+			}
+
+			token, ok := tokens.Lookup(secret)
+			if !ok {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="metrix", error="invalid_token"`)
+				handlers.WriteProblem(w, r, handlers.NewProblem(http.StatusUnauthorized, handlers.CodeUnauthorized,
+					"the bearer token is not known").
+					AtParameter("Authorization", "must be Bearer and the token"))
+				return
+			}
+
+			if !token.Allows(scope) {
+				w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="metrix", error="insufficient_scope", scope="%s"`, scope))
+				handlers.WriteProblem(w, r, handlers.NewProblem(http.StatusForbidden, handlers.CodeForbidden,
+					fmt.Sprintf("token %s does not have the %s scope", token.Name, scope)))
+				return
+			}
+
+			next.ServeHTTP(w, r.WithContext(auth.WithToken(r.Context(), token)))
+		})
+	}
+}
+
+// bearerToken takes the token out of an Authorization header
+func bearerToken(header string) (string, bool) {
+	scheme, token, ok := strings.Cut(strings.TrimSpace(header), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	token = strings.TrimSpace(token)
+	return token, token != ""
+}
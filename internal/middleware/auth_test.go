@@ -0,0 +1,70 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"Vova4o/metrix/internal/auth"
+	"Vova4o/metrix/internal/handlers"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequireScope(t *testing.T) {
+	tokens, err := auth.New(auth.Config{Tokens: []auth.TokenConfig{
+		{Name: "dashboard", SHA256: auth.Hash("read-secret"), Scopes: []auth.Scope{auth.ScopeRead}},
+		{Name: "agent", SHA256: auth.Hash("write-secret"), Scopes: []auth.Scope{auth.ScopeWrite}},
+	}})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name          string
+		tokens        *auth.Tokens
+		authorization string
+		status        int
+		code          string
+		challenge     string
+	}{
+		{"allowed", tokens, "Bearer write-secret", http.StatusOK, "", ""},
+		{"scheme case", tokens, "bearer write-secret", http.StatusOK, "", ""},
+		{"missing", tokens, "", http.StatusUnauthorized, handlers.CodeUnauthorized, `Bearer realm="metrix"`},
+		{"basic", tokens, "Basic YWdlbnQ6c2VjcmV0", http.StatusUnauthorized, handlers.CodeUnauthorized, `Bearer realm="metrix"`},
+		{"unknown", tokens, "Bearer guess", http.StatusUnauthorized, handlers.CodeUnauthorized, `Bearer realm="metrix", error="invalid_token"`},
+		{"read only", tokens, "Bearer read-secret", http.StatusForbidden, handlers.CodeForbidden, `Bearer realm="metrix", error="insufficient_scope", scope="write"`},
+		{"no tokens", nil, "", http.StatusOK, "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got *auth.Token
+			h := RequireScope(tt.tokens, auth.ScopeWrite)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got, _ = auth.FromContext(r.Context())
+			}))
+
+			req := httptest.NewRequest(http.MethodPost, "/update/", nil)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, req)
+
+			require.Equal(t, tt.status, rr.Code)
+			if tt.status == http.StatusOK {
+				if tt.tokens != nil {
+					require.NotNil(t, got)
+					assert.Equal(t, "agent", got.Name)
+				}
+				return
+			}
+
+			assert.Equal(t, tt.challenge, rr.Header().Get("WWW-Authenticate"))
+			assert.Equal(t, handlers.ProblemContentType, rr.Header().Get("Content-Type"))
+			var p handlers.Problem
+			require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &p))
+			assert.Equal(t, tt.code, p.Code)
+		})
+	}
+}
@@ -0,0 +1,345 @@
+package middleware
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// DefaultMinCompressSize is the smallest response body worth compressing,
+// below it the encoding overhead eats the gain
+const DefaultMinCompressSize = 1024
+
+// serverPreference breaks ties between encodings the client accepts
+// equally, better ratios first
+var serverPreference = []string{"zstd", "br", "gzip", "deflate"}
+
+// compressibleTypes are the media types worth compressing, matched by
+// prefix. Types ending in +json are compressible as well
+var compressibleTypes = []string{
+	"text/",
+	"application/json",
+	"application/javascript",
+	"application/xml",
+	"image/svg+xml",
+}
+
+// CompressConfig tunes the Compress middleware
+type CompressConfig struct {
+	Level   int // от 1 (быстрее) до 9 (меньше), 0 — уровень кодека по умолчанию
+	MinSize int // меньшие ответы не сжимаются, 0 — DefaultMinCompressSize
+}
+
+// encoder is a compressing writer that can be reused for another response
+type encoder interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+// Compress compresses responses with the encoding the client prefers in
+// Accept-Encoding: zstd, br, gzip or deflate. Only text, HTML and JSON
+// bodies of at least MinSize bytes are compressed, smaller bodies are
+// held back until the size is known. Encoders are pooled per encoding
+func Compress(cfg CompressConfig) func(http.Handler) http.Handler {
+	if cfg.MinSize <= 0 {
+		cfg.MinSize = DefaultMinCompressSize
+	}
+	cfg.Level = min(max(cfg.Level, 0), 9)
+
+	pools := make(map[string]*sync.Pool, len(serverPreference))
+	for _, encoding := range serverPreference {
+		pools[encoding] = &sync.Pool{New: func() any {
+			return newEncoder(encoding, cfg.Level)
+		}}
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// WebSocket handshakes are passed through, the connection gets hijacked
+			if r.Header.Get("Upgrade") != "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			// Caches must keep the copies for each encoding apart
+			w.Header().Add("Vary", "Accept-Encoding")
+
+			encoding := negotiate(r.Header.Get("Accept-Encoding"))
+			if encoding == "" || r.Method == http.MethodHead {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			cw := &compressWriter{
+				ResponseWriter: w,
+				encoding:       encoding,
+				pool:           pools[encoding],
+				minSize:        cfg.MinSize,
+			}
+			defer cw.Close()
+
+			next.ServeHTTP(cw, r)
+		})
+	}
+}
+
+func newEncoder(encoding string, level int) encoder {
+	switch encoding {
+	case "zstd":
+		speed := zstd.SpeedDefault
+		if level > 0 {
+			speed = zstd.EncoderLevelFromZstd(level)
+		}
+		// The options are valid, NewWriter cannot fail
+		enc, _ := zstd.NewWriter(nil, zstd.WithEncoderLevel(speed), zstd.WithEncoderConcurrency(1))
+		return enc
+	case "br":
+		if level == 0 {
+			level = brotli.DefaultCompression
+		}
+		return brotli.NewWriterLevel(io.Discard, level)
+	case "deflate":
+		if level == 0 {
+			level = zlib.DefaultCompression
+		}
+		enc, _ := zlib.NewWriterLevel(io.Discard, level)
+		return enc
+	default:
+		if level == 0 {
+			level = gzip.DefaultCompression
+		}
+		enc, _ := gzip.NewWriterLevel(io.Discard, level)
+		return enc
+	}
+}
+
+// negotiate picks the encoding of a response from Accept-Encoding by the
+// q-values, or returns "" when the body should be sent as it is
+func negotiate(acceptEncoding string) string {
+	if acceptEncoding == "" {
+		return ""
+	}
+
+	weights := make(map[string]float64)
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		name, params, _ := strings.Cut(part, ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "x-gzip" {
+			name = "gzip"
+		}
+		weights[name] = qValue(params)
+	}
+
+	best, bestWeight := "", 0.0
+	for _, encoding := range serverPreference {
+		weight, ok := weights[encoding]
+		if !ok {
+			// * stands for every encoding the client did not name
+			if weight, ok = weights["*"]; !ok {
+				continue
+			}
+		}
+		if weight > bestWeight {
+			best, bestWeight = encoding, weight
+		}
+	}
+	return best
+}
+
+// qValue reads the weight of an Accept-Encoding element, 1 when it has none
+func qValue(params string) float64 {
+	for _, param := range strings.Split(params, ";") {
+		key, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+		if !strings.EqualFold(key, "q") {
+			continue
+		}
+		q, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil || q < 0 {
+			return 0
+		}
+		return min(q, 1)
+	}
+	return 1
+}
+
+// compressible reports whether a response of the media type is worth compressing
+func compressible(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	if strings.HasSuffix(mediaType, "+json") {
+		return true
+	}
+	for _, prefix := range compressibleTypes {
+		if strings.HasPrefix(mediaType, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// compressWriter holds the body back until it knows whether to compress
+// it: once MinSize bytes were written, the handler flushed, or the handler
+// returned. Until then the status code is held back as well
+type compressWriter struct {
+	http.ResponseWriter
+	encoding string
+	pool     *sync.Pool
+	minSize  int
+
+	status  int
+	started bool    // заголовки ответа отправлены
+	buf     []byte  // тело, пока размер не известен
+	enc     encoder // nil, если ответ не сжимается
+}
+
+func (cw *compressWriter) WriteHeader(status int) {
+	if cw.status != 0 || cw.started {
+		return
+	}
+	if status < http.StatusOK {
+		// Informational responses go out at once
+		cw.ResponseWriter.WriteHeader(status)
+		return
+	}
+	cw.status = status
+
+	if !cw.mayCompress() {
+		cw.start(false)
+	} else if n, err := strconv.Atoi(cw.Header().Get("Content-Length")); err == nil && n >= cw.minSize {
+		cw.start(true)
+	}
+}
+
+func (cw *compressWriter) Write(p []byte) (int, error) {
+	if cw.status == 0 {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if cw.started {
+		return cw.write(p)
+	}
+
+	cw.buf = append(cw.buf, p...)
+	if len(cw.buf) < cw.minSize {
+		return len(p), nil
+	}
+	if err := cw.start(true); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Flush starts a streamed response compressed, whatever its size
+func (cw *compressWriter) Flush() {
+	if !cw.started {
+		if cw.status == 0 {
+			cw.status = http.StatusOK
+		}
+		cw.start(cw.mayCompress())
+	}
+	if cw.enc != nil {
+		cw.enc.Flush()
+	}
+	if f, ok := cw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the connection
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// Close sends a body held back because it was too small to compress,
+// or finishes the compressed stream and returns the encoder to the pool
+func (cw *compressWriter) Close() error {
+	if !cw.started {
+		if cw.status == 0 && len(cw.buf) == 0 {
+			// Nothing was written, net/http answers 200 with no body
+			return nil
+		}
+		if cw.status == 0 {
+			cw.status = http.StatusOK
+		}
+		if err := cw.start(false); err != nil {
+			return err
+		}
+	}
+	if cw.enc == nil {
+		return nil
+	}
+
+	err := cw.enc.Close()
+	cw.enc.Reset(io.Discard)
+	cw.pool.Put(cw.enc)
+	cw.enc = nil
+	return err
+}
+
+// mayCompress reports whether the headers and status allow compression
+func (cw *compressWriter) mayCompress() bool {
+	h := cw.Header()
+	switch {
+	case cw.status == http.StatusNoContent, cw.status == http.StatusNotModified,
+		cw.status == http.StatusPartialContent:
+		return false
+	case h.Get("Content-Encoding") != "":
+		return false
+	case strings.Contains(h.Get("Cache-Control"), "no-transform"):
+		return false
+	}
+	if ct := h.Get("Content-Type"); ct != "" && !compressible(ct) {
+		return false
+	}
+	if n, err := strconv.Atoi(h.Get("Content-Length")); err == nil && n < cw.minSize {
+		return false
+	}
+	return true
+}
+
+// start sends the headers and the body held back so far
+func (cw *compressWriter) start(compress bool) error {
+	cw.started = true
+	h := cw.Header()
+
+	if compress && h.Get("Content-Type") == "" && len(cw.buf) > 0 {
+		// net/http would sniff the compressed bytes instead
+		h.Set("Content-Type", http.DetectContentType(cw.buf))
+		compress = compressible(h.Get("Content-Type"))
+	}
+	if compress {
+		h.Set("Content-Encoding", cw.encoding)
+		h.Del("Content-Length")
+		// The compressed bytes differ from the ones the strong tag names
+		if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+			h.Set("ETag", "W/"+etag)
+		}
+		cw.enc = cw.pool.Get().(encoder)
+		cw.enc.Reset(cw.ResponseWriter)
+	}
+	cw.ResponseWriter.WriteHeader(cw.status)
+
+	if len(cw.buf) == 0 {
+		return nil
+	}
+	_, err := cw.write(cw.buf)
+	cw.buf = nil
+	return err
+}
+
+func (cw *compressWriter) write(p []byte) (int, error) {
+	if cw.enc != nil {
+		return cw.enc.Write(p)
+	}
+	return cw.ResponseWriter.Write(p)
+}
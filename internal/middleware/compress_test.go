@@ -0,0 +1,241 @@
+package middleware
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func decompress(t *testing.T, encoding string, body []byte) string {
+	t.Helper()
+	var r io.Reader
+	switch encoding {
+	case "gzip":
+		gz, err := gzip.NewReader(bytes.NewReader(body))
+		require.NoError(t, err)
+		r = gz
+	case "deflate":
+		zr, err := zlib.NewReader(bytes.NewReader(body))
+		require.NoError(t, err)
+		r = zr
+	case "zstd":
+		zr, err := zstd.NewReader(bytes.NewReader(body))
+		require.NoError(t, err)
+		defer zr.Close()
+		r = zr
+	case "br":
+		r = brotli.NewReader(bytes.NewReader(body))
+	default:
+		return string(body)
+	}
+	data, err := io.ReadAll(r)
+	require.NoError(t, err)
+	return string(data)
+}
+
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		acceptEncoding string
+		want           string
+	}{
+		{"", ""},
+		{"gzip", "gzip"},
+		{"x-gzip", "gzip"},
+		{"gzip, deflate, br, zstd", "zstd"},
+		{"gzip;q=1.0, br;q=0.8", "gzip"},
+		{"gzip;q=0, deflate", "deflate"},
+		{"*", "zstd"},
+		{"*;q=0.5, gzip", "gzip"},
+		{"zstd;q=0, *", "br"},
+		{"identity", ""},
+		{"compress, sdch", ""},
+		{"GZIP; Q=0.5", "gzip"},
+		{"gzip;q=bad", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.acceptEncoding, func(t *testing.T) {
+			assert.Equal(t, tt.want, negotiate(tt.acceptEncoding))
+		})
+	}
+}
+
+func TestCompress(t *testing.T) {
+	large := strings.Repeat(`{"id":"Alloc","type":"gauge","value":1.5}`, 100)
+
+	tests := []struct {
+		name           string
+		acceptEncoding string
+		contentType    string
+		body           string
+		status         int
+		wantEncoding   string
+	}{
+		{"gzip", "gzip", "application/json", large, http.StatusOK, "gzip"},
+		{"deflate", "deflate", "application/json", large, http.StatusOK, "deflate"},
+		{"zstd", "zstd", "application/json", large, http.StatusOK, "zstd"},
+		{"brotli", "br", "text/html; charset=utf-8", large, http.StatusOK, "br"},
+		{"problem", "gzip", "application/problem+json", large, http.StatusBadRequest, "gzip"},
+		{"sniffed", "gzip", "", "<html>" + large, http.StatusOK, "gzip"},
+		{"not accepted", "", "application/json", large, http.StatusOK, ""},
+		{"small body", "gzip", "application/json", `{"status":"ok"}`, http.StatusOK, ""},
+		{"no body", "gzip", "", "", http.StatusOK, ""},
+		{"image", "gzip", "image/png", large, http.StatusOK, ""},
+		{"no content", "gzip", "application/json", "", http.StatusNoContent, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := Compress(CompressConfig{})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if tt.contentType != "" {
+					w.Header().Set("Content-Type", tt.contentType)
+				}
+				w.WriteHeader(tt.status)
+				// Several small writes must be held back together
+				for i := 0; i < len(tt.body); i += 100 {
+					w.Write([]byte(tt.body[i:min(i+100, len(tt.body))]))
+				}
+			}))
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.acceptEncoding != "" {
+				req.Header.Set("Accept-Encoding", tt.acceptEncoding)
+			}
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, req)
+
+			assert.Equal(t, tt.status, rr.Code)
+			assert.Equal(t, tt.wantEncoding, rr.Header().Get("Content-Encoding"))
+			assert.Equal(t, []string{"Accept-Encoding"}, rr.Header().Values("Vary"))
+			assert.Equal(t, tt.body, decompress(t, tt.wantEncoding, rr.Body.Bytes()))
+		})
+	}
+}
+
+func TestCompress_Headers(t *testing.T) {
+	body := strings.Repeat("a", 2048)
+	h := Compress(CompressConfig{Level: 9})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("Content-Length", "2048")
+		w.Header().Set("ETag", `"7"`)
+		w.Header().Add("Vary", "Accept")
+		w.Write([]byte(body))
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+
+	assert.Equal(t, "gzip", rr.Header().Get("Content-Encoding"))
+	assert.Empty(t, rr.Header().Get("Content-Length"))
+	assert.Equal(t, `W/"7"`, rr.Header().Get("ETag"))
+	assert.Equal(t, []string{"Accept-Encoding", "Accept"}, rr.Header().Values("Vary"))
+	assert.Less(t, rr.Body.Len(), 100)
+	assert.Equal(t, body, decompress(t, "gzip", rr.Body.Bytes()))
+}
+
+func TestCompress_MinSize(t *testing.T) {
+	h := Compress(CompressConfig{MinSize: 4})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte("hello"))
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+
+	assert.Equal(t, "gzip", rr.Header().Get("Content-Encoding"))
+	assert.Equal(t, "hello", decompress(t, "gzip", rr.Body.Bytes()))
+}
+
+func TestCompress_SkipsEncodedAndNoTransform(t *testing.T) {
+	body := strings.Repeat("a", 2048)
+	for _, header := range []string{"Content-Encoding", "Cache-Control"} {
+		t.Run(header, func(t *testing.T) {
+			h := Compress(CompressConfig{})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "text/plain")
+				if header == "Content-Encoding" {
+					w.Header().Set("Content-Encoding", "identity")
+				} else {
+					w.Header().Set("Cache-Control", "no-transform")
+				}
+				w.Write([]byte(body))
+			}))
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("Accept-Encoding", "gzip")
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, req)
+
+			assert.NotEqual(t, "gzip", rr.Header().Get("Content-Encoding"))
+			assert.Equal(t, body, rr.Body.String())
+		})
+	}
+}
+
+func TestCompress_Flush(t *testing.T) {
+	h := Compress(CompressConfig{})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte("event"))
+		flusher, ok := w.(http.Flusher)
+		require.True(t, ok, "compress writer does not implement http.Flusher")
+		flusher.Flush()
+
+		// Everything written so far must already be readable by the client
+		rr := w.(*compressWriter).ResponseWriter.(*httptest.ResponseRecorder)
+		assert.Equal(t, "gzip", rr.Header().Get("Content-Encoding"))
+		reader, err := gzip.NewReader(bytes.NewReader(rr.Body.Bytes()))
+		require.NoError(t, err)
+		buf := make([]byte, 5)
+		_, err = io.ReadFull(reader, buf)
+		assert.NoError(t, err)
+		assert.Equal(t, "event", string(buf))
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/stream", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+}
+
+func TestCompress_SkipsUpgrade(t *testing.T) {
+	h := Compress(CompressConfig{})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := w.(*compressWriter); ok {
+			t.Error("websocket handshake must not be compressed")
+		}
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/ws", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	req.Header.Set("Upgrade", "websocket")
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+
+	assert.Empty(t, rr.Header().Get("Content-Encoding"))
+}
+
+func BenchmarkCompress(b *testing.B) {
+	body := []byte(strings.Repeat(`{"id":"Alloc","type":"gauge","value":1.5}`, 100))
+	h := Compress(CompressConfig{})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(body)
+	}))
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		h.ServeHTTP(httptest.NewRecorder(), req)
+	}
+}
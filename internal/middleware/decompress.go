@@ -0,0 +1,143 @@
+package middleware
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"Vova4o/metrix/internal/handlers"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// acceptedEncodings are the request encodings Decompress understands,
+// 415 responses list them in Accept-Encoding (RFC 7694)
+const acceptedEncodings = "gzip, deflate, zstd"
+
+// errTooLarge reports a body that expands past the limit
+var errTooLarge = errors.New("decompressed body is too large")
+
+// decoders open a reader for each Content-Encoding token
+var decoders = map[string]func(io.Reader) (io.ReadCloser, error){
+	"gzip":    newGzipReader,
+	"x-gzip":  newGzipReader,
+	"deflate": newDeflateReader,
+	"zstd":    newZstdReader,
+}
+
+// Decompress decodes request bodies sent with Content-Encoding gzip, deflate
+// or zstd before the handlers read them. A body that expands past limit
+// bytes is rejected with 413, so a small compressed request cannot take
+// the memory of the server, and unknown encodings are rejected with 415
+func Decompress(limit int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			encodings := contentEncodings(r.Header)
+			if len(encodings) == 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			for _, encoding := range encodings {
+				if _, ok := decoders[encoding]; !ok {
+					w.Header().Set("Accept-Encoding", acceptedEncodings)
+					handlers.WriteProblem(w, r, handlers.NewProblem(http.StatusUnsupportedMediaType, handlers.CodeUnsupportedEncoding,
+						fmt.Sprintf("content encoding %q is not supported", encoding)).
+						AtParameter("Content-Encoding", "must be one of "+acceptedEncodings))
+					return
+				}
+			}
+
+			body, err := decode(r.Body, encodings, limit)
+			if errors.Is(err, errTooLarge) {
+				handlers.WriteProblem(w, r, handlers.NewProblem(http.StatusRequestEntityTooLarge, handlers.CodeInvalidBody,
+					fmt.Sprintf("request body expands to more than %d bytes", limit)))
+				return
+			}
+			if err != nil {
+				handlers.WriteProblem(w, r, handlers.NewProblem(http.StatusBadRequest, handlers.CodeInvalidBody,
+					"request body does not match its Content-Encoding").WithCause(err))
+				return
+			}
+
+			// The handlers see a plain body
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			r.ContentLength = int64(len(body))
+			r.Header.Del("Content-Encoding")
+			r.Header.Set("Content-Length", strconv.Itoa(len(body)))
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// contentEncodings returns the codings of a request in the order they were
+// applied, without identity
+func contentEncodings(h http.Header) []string {
+	var encodings []string
+	for _, value := range h.Values("Content-Encoding") {
+		for _, token := range strings.Split(value, ",") {
+			token = strings.ToLower(strings.TrimSpace(token))
+			if token != "" && token != "identity" {
+				encodings = append(encodings, token)
+			}
+		}
+	}
+	return encodings
+}
+
+// decode undoes the codings in reverse order and reads at most limit bytes
+func decode(body io.Reader, encodings []string, limit int64) ([]byte, error) {
+	reader := body
+	for i := len(encodings) - 1; i >= 0; i-- {
+		decoder, err := decoders[encodings[i]](reader)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", encodings[i], err)
+		}
+		defer decoder.Close()
+		reader = decoder
+	}
+
+	data, err := io.ReadAll(io.LimitReader(reader, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > limit {
+		return nil, errTooLarge
+	}
+	return data, nil
+}
+
+func newGzipReader(r io.Reader) (io.ReadCloser, error) {
+	return gzip.NewReader(r)
+}
+
+// newDeflateReader reads the zlib format RFC 9110 means by deflate, and
+// the raw deflate stream some clients send instead
+func newDeflateReader(r io.Reader) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+	header, err := br.Peek(2)
+	if err != nil {
+		return nil, err
+	}
+	if header[0]&0x0f == 8 && (uint16(header[0])<<8|uint16(header[1]))%31 == 0 {
+		return zlib.NewReader(br)
+	}
+	return flate.NewReader(br), nil
+}
+
+func newZstdReader(r io.Reader) (io.ReadCloser, error) {
+	decoder, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1), zstd.WithDecoderLowmem(true))
+	if err != nil {
+		return nil, err
+	}
+	return decoder.IOReadCloser(), nil
+}
@@ -0,0 +1,118 @@
+package middleware
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"Vova4o/metrix/internal/handlers"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func compress(t *testing.T, encoding string, data []byte) []byte {
+	t.Helper()
+	var b bytes.Buffer
+	var w io.WriteCloser
+	switch encoding {
+	case "gzip":
+		w = gzip.NewWriter(&b)
+	case "deflate":
+		w = zlib.NewWriter(&b)
+	case "raw-deflate":
+		fw, err := flate.NewWriter(&b, flate.DefaultCompression)
+		require.NoError(t, err)
+		w = fw
+	case "zstd":
+		zw, err := zstd.NewWriter(&b)
+		require.NoError(t, err)
+		w = zw
+	default:
+		t.Fatalf("unknown encoding %s", encoding)
+	}
+	_, err := w.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	return b.Bytes()
+}
+
+func TestDecompress(t *testing.T) {
+	const payload = `{"id":"Alloc","type":"gauge","value":1.5}`
+
+	tests := []struct {
+		name     string
+		encoding string
+		body     []byte
+		status   int
+		code     string
+	}{
+		{"plain", "", []byte(payload), http.StatusOK, ""},
+		{"identity", "identity", []byte(payload), http.StatusOK, ""},
+		{"gzip", "gzip", compress(t, "gzip", []byte(payload)), http.StatusOK, ""},
+		{"case insensitive", "GZIP", compress(t, "gzip", []byte(payload)), http.StatusOK, ""},
+		{"deflate", "deflate", compress(t, "deflate", []byte(payload)), http.StatusOK, ""},
+		{"raw deflate", "deflate", compress(t, "raw-deflate", []byte(payload)), http.StatusOK, ""},
+		{"zstd", "zstd", compress(t, "zstd", []byte(payload)), http.StatusOK, ""},
+		{"stacked", "deflate, gzip", compress(t, "gzip", compress(t, "deflate", []byte(payload))), http.StatusOK, ""},
+		{"unknown", "br", []byte(payload), http.StatusUnsupportedMediaType, handlers.CodeUnsupportedEncoding},
+		{"corrupt", "gzip", []byte(payload), http.StatusBadRequest, handlers.CodeInvalidBody},
+		{"bomb", "gzip", compress(t, "gzip", make([]byte, 1<<20)), http.StatusRequestEntityTooLarge, handlers.CodeInvalidBody},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []byte
+			h := Decompress(1024)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if tt.encoding != "identity" {
+					assert.Empty(t, r.Header.Get("Content-Encoding"))
+				}
+				var err error
+				got, err = io.ReadAll(r.Body)
+				require.NoError(t, err)
+				assert.Equal(t, int64(len(got)), r.ContentLength)
+			}))
+
+			req := httptest.NewRequest(http.MethodPost, "/update/", bytes.NewReader(tt.body))
+			if tt.encoding != "" {
+				req.Header.Set("Content-Encoding", tt.encoding)
+			}
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, req)
+
+			require.Equal(t, tt.status, rr.Code)
+			if tt.status == http.StatusOK {
+				assert.Equal(t, payload, string(got))
+				return
+			}
+
+			assert.Equal(t, handlers.ProblemContentType, rr.Header().Get("Content-Type"))
+			var p handlers.Problem
+			require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &p))
+			assert.Equal(t, tt.code, p.Code)
+			if tt.status == http.StatusUnsupportedMediaType {
+				assert.Equal(t, "gzip, deflate, zstd", rr.Header().Get("Accept-Encoding"))
+			}
+		})
+	}
+}
+
+func TestDecompress_LimitIsInclusive(t *testing.T) {
+	body := strings.Repeat("a", 1024)
+	h := Decompress(1024)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(compress(t, "zstd", []byte(body))))
+	req.Header.Set("Content-Encoding", "zstd")
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+}
@@ -0,0 +1,75 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"Vova4o/metrix/internal/encryption"
+	"Vova4o/metrix/internal/handlers"
+)
+
+// maxEncryptedBytes caps the encrypted request bodies Decrypt reads
+const maxEncryptedBytes = 10 << 20
+
+// Decrypt opens request bodies the agent encrypted for the public half of
+// key, marked with the X-Encryption header. Plain requests are passed
+// through. Encryption wraps the compressed body, so Decrypt has to run
+// before Decompress. With a nil key encrypted requests are rejected
+func Decrypt(key *rsa.PrivateKey) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			scheme := r.Header.Get(encryption.Header)
+			if scheme == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if scheme != encryption.Scheme {
+				handlers.WriteProblem(w, r, handlers.NewProblem(http.StatusBadRequest, handlers.CodeDecryptionFailed,
+					fmt.Sprintf("encryption scheme %q is not supported", scheme)).
+					AtParameter(encryption.Header, "must be "+encryption.Scheme))
+				return
+			}
+			if key == nil {
+				handlers.WriteProblem(w, r, handlers.NewProblem(http.StatusBadRequest, handlers.CodeDecryptionFailed,
+					"the server has no private key, send the body unencrypted"))
+				return
+			}
+
+			data, err := io.ReadAll(io.LimitReader(r.Body, maxEncryptedBytes+1))
+			if err != nil {
+				handlers.WriteProblem(w, r, handlers.NewProblem(http.StatusBadRequest, handlers.CodeInvalidBody,
+					"failed to read request body").WithCause(err))
+				return
+			}
+			if len(data) > maxEncryptedBytes {
+				handlers.WriteProblem(w, r, handlers.NewProblem(http.StatusRequestEntityTooLarge, handlers.CodeInvalidBody,
+					fmt.Sprintf("request body is larger than %d bytes", maxEncryptedBytes)))
+				return
+			}
+
+			body, err := encryption.Decrypt(key, data)
+			if err != nil {
+				detail := "failed to decrypt the request body"
+				if errors.Is(err, encryption.ErrKeyMismatch) {
+					detail = "the body is encrypted for another key, check the agent's crypto key"
+				}
+				handlers.WriteProblem(w, r, handlers.NewProblem(http.StatusBadRequest, handlers.CodeDecryptionFailed,
+					detail).WithCause(err))
+				return
+			}
+
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			r.ContentLength = int64(len(body))
+			r.Header.Del(encryption.Header)
+			r.Header.Set("Content-Length", strconv.Itoa(len(body)))
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,78 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"Vova4o/metrix/internal/encryption"
+	"Vova4o/metrix/internal/handlers"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecrypt(t *testing.T) {
+	const payload = `{"id":"Alloc","type":"gauge","value":1.5}`
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	sealed, err := encryption.Encrypt(&key.PublicKey, []byte(payload))
+	require.NoError(t, err)
+	sealedForOther, err := encryption.Encrypt(&otherKey.PublicKey, []byte(payload))
+	require.NoError(t, err)
+
+	tests := []struct {
+		name   string
+		key    *rsa.PrivateKey
+		scheme string
+		body   []byte
+		status int
+		detail string
+	}{
+		{"plain", key, "", []byte(payload), http.StatusOK, ""},
+		{"encrypted", key, encryption.Scheme, sealed, http.StatusOK, ""},
+		{"plain without a key", nil, "", []byte(payload), http.StatusOK, ""},
+		{"other key", key, encryption.Scheme, sealedForOther, http.StatusBadRequest, "another key"},
+		{"not encrypted", key, encryption.Scheme, []byte(payload), http.StatusBadRequest, "failed to decrypt"},
+		{"unknown scheme", key, "rot13", sealed, http.StatusBadRequest, "not supported"},
+		{"server without a key", nil, encryption.Scheme, sealed, http.StatusBadRequest, "no private key"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []byte
+			h := Decrypt(tt.key)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				assert.Empty(t, r.Header.Get(encryption.Header))
+				got, _ = io.ReadAll(r.Body)
+				assert.Equal(t, int64(len(got)), r.ContentLength)
+			}))
+
+			req := httptest.NewRequest(http.MethodPost, "/update/", bytes.NewReader(tt.body))
+			if tt.scheme != "" {
+				req.Header.Set(encryption.Header, tt.scheme)
+			}
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, req)
+
+			require.Equal(t, tt.status, rr.Code)
+			if tt.status == http.StatusOK {
+				assert.Equal(t, payload, string(got))
+				return
+			}
+			var p handlers.Problem
+			require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &p))
+			assert.Equal(t, handlers.CodeDecryptionFailed, p.Code)
+			assert.True(t, strings.Contains(p.Detail, tt.detail), "detail %q", p.Detail)
+		})
+	}
+}
@@ -1,9 +1,7 @@
 package middleware
 
 import (
-	"compress/gzip"
 	"net/http"
-	"strings"
 	"time"
 
 	"Vova4o/metrix/internal/logger"
@@ -12,11 +10,6 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
-type gzipWriter struct {
-	http.ResponseWriter
-	Writer *gzip.Writer
-}
-
 func RequestLogger(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
@@ -38,24 +31,15 @@ func RequestLogger(next http.Handler) http.Handler {
 	})
 }
 
-func (w gzipWriter) Write(b []byte) (int, error) {
-	// w.Writer будет отвечать за gzip-сжатие, поэтому пишем в него
-	return w.Writer.Write(b)
-}
-
-// GzipMiddleware compresses response body in gzip format if the client supports it
-func GzipMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Check if the client accepts gzip compression
-		if !strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+// Deprecated marks the responses of a legacy route with the Deprecation
+// header and links the route that replaces it. successor builds the link
+// from the request, so it can point at the same metric
+func Deprecated(successor func(r *http.Request) string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Deprecation", "true")
+			w.Header().Add("Link", "<"+successor(r)+`>; rel="successor-version"`)
 			next.ServeHTTP(w, r)
-			return
-		}
-
-		w.Header().Set("Content-Encoding", "gzip")
-		gz := gzip.NewWriter(w)
-		defer gz.Close()
-
-		next.ServeHTTP(gzipWriter{ResponseWriter: w, Writer: gz}, r)
-	})
+		})
+	}
 }
@@ -0,0 +1,155 @@
+package middleware
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+
+	"Vova4o/metrix/internal/handlers"
+	"Vova4o/metrix/internal/signature"
+)
+
+// maxSignedBytes caps the request bodies Sign reads to check them
+const maxSignedBytes = 10 << 20
+
+// Sign checks the HashSHA256 signature of requests that carry one and
+// signs the responses with the shared key. A mismatch is answered with 400,
+// RequireSignature makes the signature mandatory on the routes that need
+// it. Signatures cover the bodies before compression, so Sign has to run
+// inside Decompress and Compress. With an empty key nothing is checked
+// or signed
+func Sign(key string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if key == "" {
+			return next
+		}
+		secret := []byte(key)
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// WebSocket handshakes are passed through, the connection gets hijacked
+			if r.Header.Get("Upgrade") != "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			sw := &signingWriter{ResponseWriter: w, key: secret}
+			defer sw.finish()
+
+			body, err := io.ReadAll(io.LimitReader(r.Body, maxSignedBytes+1))
+			if err != nil {
+				handlers.WriteProblem(sw, r, handlers.NewProblem(http.StatusBadRequest, handlers.CodeInvalidBody,
+					"failed to read request body").WithCause(err))
+				return
+			}
+			if len(body) > maxSignedBytes {
+				handlers.WriteProblem(sw, r, handlers.NewProblem(http.StatusRequestEntityTooLarge, handlers.CodeInvalidBody,
+					fmt.Sprintf("request body is larger than %d bytes", maxSignedBytes)))
+				return
+			}
+
+			if sum := r.Header.Get(signature.Header); sum != "" && !signature.Valid(secret, body, sum) {
+				handlers.WriteProblem(sw, r, handlers.NewProblem(http.StatusBadRequest, handlers.CodeInvalidSignature,
+					"request signature does not match the body").
+					AtParameter(signature.Header, "must be the hex HMAC-SHA256 of the body"))
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+
+			next.ServeHTTP(sw, r)
+		})
+	}
+}
+
+// RequireSignature rejects requests without a HashSHA256 signature when
+// a key is set. It guards the routes agents send metrics to and relies
+// on Sign, which runs before it, to check the signatures that are sent
+func RequireSignature(key string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if key == "" {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get(signature.Header) == "" {
+				handlers.WriteProblem(w, r, handlers.NewProblem(http.StatusBadRequest, handlers.CodeInvalidSignature,
+					"request is not signed").
+					AtParameter(signature.Header, "must be the hex HMAC-SHA256 of the body"))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// signingWriter holds the response back until the handler returns, so the
+// signature of the whole body can go into the headers. Streamed responses
+// are sent unsigned from their first Flush
+type signingWriter struct {
+	http.ResponseWriter
+	key []byte
+
+	status    int
+	body      bytes.Buffer
+	streaming bool
+}
+
+func (sw *signingWriter) WriteHeader(status int) {
+	if sw.streaming {
+		sw.ResponseWriter.WriteHeader(status)
+		return
+	}
+	if status < http.StatusOK {
+		// Informational responses go out at once
+		sw.ResponseWriter.WriteHeader(status)
+		return
+	}
+	if sw.status == 0 {
+		sw.status = status
+	}
+}
+
+func (sw *signingWriter) Write(p []byte) (int, error) {
+	if sw.streaming {
+		return sw.ResponseWriter.Write(p)
+	}
+	if sw.status == 0 {
+		sw.status = http.StatusOK
+	}
+	return sw.body.Write(p)
+}
+
+// Flush gives up signing and sends what was held back
+func (sw *signingWriter) Flush() {
+	if !sw.streaming {
+		sw.streaming = true
+		sw.send()
+	}
+	if f, ok := sw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the connection
+func (sw *signingWriter) Unwrap() http.ResponseWriter {
+	return sw.ResponseWriter
+}
+
+// finish signs and sends the response once the handler has returned
+func (sw *signingWriter) finish() {
+	if sw.streaming {
+		return
+	}
+	sw.Header().Set(signature.Header, signature.Sum(sw.key, sw.body.Bytes()))
+	sw.send()
+}
+
+func (sw *signingWriter) send() {
+	if sw.status == 0 {
+		sw.status = http.StatusOK
+	}
+	sw.ResponseWriter.WriteHeader(sw.status)
+	if sw.body.Len() > 0 {
+		sw.ResponseWriter.Write(sw.body.Bytes())
+		sw.body.Reset()
+	}
+}
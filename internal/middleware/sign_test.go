@@ -0,0 +1,119 @@
+package middleware
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"Vova4o/metrix/internal/handlers"
+	"Vova4o/metrix/internal/signature"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSign(t *testing.T) {
+	const (
+		key  = "secret"
+		body = `{"id":"Alloc","type":"gauge","value":1.5}`
+	)
+	echo := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		w.Write(data)
+	})
+
+	tests := []struct {
+		name   string
+		method string
+		body   string
+		sum    string
+		status int
+	}{
+		{"signed update", http.MethodPost, body, signature.Sum([]byte(key), []byte(body)), http.StatusCreated},
+		{"unsigned update", http.MethodPost, body, "", http.StatusCreated},
+		{"other key", http.MethodPost, body, signature.Sum([]byte("other"), []byte(body)), http.StatusBadRequest},
+		{"changed body", http.MethodPut, `{"value":2}`, signature.Sum([]byte(key), []byte(body)), http.StatusBadRequest},
+		{"unsigned delete", http.MethodDelete, "", "", http.StatusCreated},
+		{"unsigned read", http.MethodGet, "", "", http.StatusCreated},
+		{"read with a bad signature", http.MethodGet, "", "00", http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/update/", strings.NewReader(tt.body))
+			if tt.sum != "" {
+				req.Header.Set(signature.Header, tt.sum)
+			}
+			rr := httptest.NewRecorder()
+			Sign(key)(echo).ServeHTTP(rr, req)
+
+			require.Equal(t, tt.status, rr.Code)
+			assert.True(t, signature.Valid([]byte(key), rr.Body.Bytes(), rr.Header().Get(signature.Header)),
+				"every response must be signed")
+			if tt.status == http.StatusCreated {
+				assert.Equal(t, tt.body, rr.Body.String())
+				return
+			}
+			var p handlers.Problem
+			require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &p))
+			assert.Equal(t, handlers.CodeInvalidSignature, p.Code)
+		})
+	}
+}
+
+func TestRequireSignature(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/update/", strings.NewReader("1"))
+	rr := httptest.NewRecorder()
+	RequireSignature("secret")(ok).ServeHTTP(rr, req)
+	require.Equal(t, http.StatusBadRequest, rr.Code)
+	var p handlers.Problem
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &p))
+	assert.Equal(t, handlers.CodeInvalidSignature, p.Code)
+
+	req.Header.Set(signature.Header, signature.Sum([]byte("secret"), []byte("1")))
+	rr = httptest.NewRecorder()
+	RequireSignature("secret")(ok).ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+
+	// Without a key nothing has to be signed
+	rr = httptest.NewRecorder()
+	RequireSignature("")(ok).ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/update/", nil))
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+}
+
+func TestSign_NoKey(t *testing.T) {
+	h := Sign("")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/update/", strings.NewReader("1")))
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Empty(t, rr.Header().Get(signature.Header))
+}
+
+func TestSign_Stream(t *testing.T) {
+	h := Sign("secret")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte("data: 1\n\n"))
+		w.(http.Flusher).Flush()
+		w.Write([]byte("data: 2\n\n"))
+	}))
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/stream", nil))
+
+	assert.True(t, rr.Flushed)
+	assert.Equal(t, "data: 1\n\ndata: 2\n\n", rr.Body.String())
+	assert.Empty(t, rr.Header().Get(signature.Header))
+}
@@ -0,0 +1,45 @@
+package middleware
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"Vova4o/metrix/internal/handlers"
+)
+
+// TrustedSubnet answers 403 to requests whose X-Real-IP header is missing
+// or outside subnet. With a nil subnet every request is let through
+func TrustedSubnet(subnet *net.IPNet) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if subnet == nil {
+			return next
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			realIP := strings.TrimSpace(r.Header.Get("X-Real-IP"))
+			if realIP == "" {
+				handlers.WriteProblem(w, r, handlers.NewProblem(http.StatusForbidden, handlers.CodeForbidden,
+					"X-Real-IP is required to check the trusted subnet").
+					AtParameter("X-Real-IP", "must be the address of the client"))
+				return
+			}
+
+			ip := net.ParseIP(realIP)
+			if ip == nil {
+				handlers.WriteProblem(w, r, handlers.NewProblem(http.StatusForbidden, handlers.CodeForbidden,
+					fmt.Sprintf("X-Real-IP %q is not an IP address", realIP)).
+					AtParameter("X-Real-IP", "must be the address of the client"))
+				return
+			}
+			if !subnet.Contains(ip) {
+				handlers.WriteProblem(w, r, handlers.NewProblem(http.StatusForbidden, handlers.CodeForbidden,
+					fmt.Sprintf("%s is outside the trusted subnet", ip)))
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,55 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"Vova4o/metrix/internal/handlers"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTrustedSubnet(t *testing.T) {
+	_, subnet, err := net.ParseCIDR("192.168.1.0/24")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name   string
+		subnet *net.IPNet
+		realIP string
+		status int
+	}{
+		{"inside", subnet, "192.168.1.17", http.StatusOK},
+		{"outside", subnet, "10.0.0.1", http.StatusForbidden},
+		{"missing", subnet, "", http.StatusForbidden},
+		{"not an address", subnet, "agent-1", http.StatusForbidden},
+		{"ipv6 outside", subnet, "::1", http.StatusForbidden},
+		{"no subnet", nil, "", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := TrustedSubnet(tt.subnet)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+			req := httptest.NewRequest(http.MethodPost, "/update/", nil)
+			if tt.realIP != "" {
+				req.Header.Set("X-Real-IP", tt.realIP)
+			}
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, req)
+
+			require.Equal(t, tt.status, rr.Code)
+			if tt.status == http.StatusOK {
+				return
+			}
+			assert.Equal(t, handlers.ProblemContentType, rr.Header().Get("Content-Type"))
+			var p handlers.Problem
+			require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &p))
+			assert.Equal(t, handlers.CodeForbidden, p.Code)
+		})
+	}
+}
@@ -0,0 +1,73 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"Vova4o/metrix/internal/auth"
+	"Vova4o/metrix/internal/handlers"
+	"Vova4o/metrix/internal/tenant"
+)
+
+// Tenant picks the tenant of a request: the one its token belongs to, or
+// the one named by X-Tenant-ID. Requests with neither use the default
+// storage. A token may not be used for another tenant than its own.
+// With nil tenants the header is ignored
+func Tenant(tenants *tenant.Registry) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if tenants == nil {
+			return next
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			name := strings.TrimSpace(r.Header.Get(tenant.Header))
+			if token, ok := auth.FromContext(r.Context()); ok && token.Tenant != "" {
+				if name != "" && name != token.Tenant {
+					handlers.WriteProblem(w, r, handlers.NewProblem(http.StatusForbidden, handlers.CodeForbidden,
+						fmt.Sprintf("token %s belongs to another tenant", token.Name)).
+						AtParameter(tenant.Header, "must be omitted or name the tenant of the token"))
+					return
+				}
+				name = token.Tenant
+			}
+
+			if name != "" {
+				if _, ok := tenants.Get(name); !ok {
+					handlers.WriteProblem(w, r, handlers.NewProblem(http.StatusBadRequest, handlers.CodeInvalidValue,
+						fmt.Sprintf("tenant %q is not known", name)).
+						AtParameter(tenant.Header, "must name a configured tenant"))
+					return
+				}
+			}
+
+			next.ServeHTTP(w, r.WithContext(tenant.WithTenant(r.Context(), name)))
+		})
+	}
+}
+
+// Shared guards the routes that serve every tenant at once, such as alerts
+// and silences. With tenants configured it refuses tokens bound to a tenant
+// and requests that name one, since the result would not be scoped to it
+func Shared(tenants *tenant.Registry) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if tenants == nil {
+			return next
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if token, ok := auth.FromContext(r.Context()); ok && token.Tenant != "" {
+				handlers.WriteProblem(w, r, handlers.NewProblem(http.StatusForbidden, handlers.CodeForbidden,
+					fmt.Sprintf("token %s belongs to tenant %s, this route is shared by all tenants", token.Name, token.Tenant)))
+				return
+			}
+			if r.Header.Get(tenant.Header) != "" {
+				handlers.WriteProblem(w, r, handlers.NewProblem(http.StatusBadRequest, handlers.CodeInvalidValue,
+					"this route is shared by all tenants").
+					AtParameter(tenant.Header, "must be omitted"))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
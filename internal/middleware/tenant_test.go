@@ -0,0 +1,113 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"Vova4o/metrix/internal/auth"
+	"Vova4o/metrix/internal/handlers"
+	"Vova4o/metrix/internal/tenant"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTenant(t *testing.T) {
+	tenants, err := tenant.New(tenant.Config{Tenants: []tenant.TenantConfig{{Name: "team-a"}, {Name: "team-b"}}},
+		tenant.Options{HistoryRetention: time.Hour})
+	require.NoError(t, err)
+
+	teamToken := &auth.Token{Name: "agent-a", Scopes: []auth.Scope{auth.ScopeWrite}, Tenant: "team-a"}
+	openToken := &auth.Token{Name: "ops", Scopes: []auth.Scope{auth.ScopeAdmin}}
+
+	tests := []struct {
+		name    string
+		tenants *tenant.Registry
+		token   *auth.Token
+		header  string
+		status  int
+		code    string
+		want    string
+	}{
+		{"no tenant", tenants, nil, "", http.StatusOK, "", ""},
+		{"header", tenants, nil, "team-b", http.StatusOK, "", "team-b"},
+		{"token", tenants, teamToken, "", http.StatusOK, "", "team-a"},
+		{"token and same header", tenants, teamToken, "team-a", http.StatusOK, "", "team-a"},
+		{"token and other header", tenants, teamToken, "team-b", http.StatusForbidden, handlers.CodeForbidden, ""},
+		{"token without tenant", tenants, openToken, "team-b", http.StatusOK, "", "team-b"},
+		{"unknown", tenants, nil, "team-c", http.StatusBadRequest, handlers.CodeInvalidValue, ""},
+		{"no tenants", nil, nil, "team-c", http.StatusOK, "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got string
+			h := Tenant(tt.tenants)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got, _ = tenant.FromContext(r.Context())
+			}))
+
+			req := httptest.NewRequest(http.MethodGet, "/api/v1/metrics", nil)
+			if tt.token != nil {
+				req = req.WithContext(auth.WithToken(req.Context(), tt.token))
+			}
+			if tt.header != "" {
+				req.Header.Set(tenant.Header, tt.header)
+			}
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, req)
+
+			require.Equal(t, tt.status, rr.Code)
+			if tt.status == http.StatusOK {
+				assert.Equal(t, tt.want, got)
+				return
+			}
+			var p handlers.Problem
+			require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &p))
+			assert.Equal(t, tt.code, p.Code)
+		})
+	}
+}
+
+func TestShared(t *testing.T) {
+	tenants, err := tenant.New(tenant.Config{Tenants: []tenant.TenantConfig{{Name: "team-a"}}},
+		tenant.Options{HistoryRetention: time.Hour})
+	require.NoError(t, err)
+
+	teamToken := &auth.Token{Name: "agent-a", Scopes: []auth.Scope{auth.ScopeRead}, Tenant: "team-a"}
+	openToken := &auth.Token{Name: "ops", Scopes: []auth.Scope{auth.ScopeAdmin}}
+
+	tests := []struct {
+		name    string
+		tenants *tenant.Registry
+		token   *auth.Token
+		header  string
+		status  int
+	}{
+		{"open token", tenants, openToken, "", http.StatusOK},
+		{"no token", tenants, nil, "", http.StatusOK},
+		{"tenant token", tenants, teamToken, "", http.StatusForbidden},
+		{"tenant header", tenants, openToken, "team-a", http.StatusBadRequest},
+		{"no tenants", nil, teamToken, "team-a", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := Shared(tt.tenants)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+			req := httptest.NewRequest(http.MethodGet, "/api/silences", nil)
+			if tt.token != nil {
+				req = req.WithContext(auth.WithToken(req.Context(), tt.token))
+			}
+			if tt.header != "" {
+				req.Header.Set(tenant.Header, tt.header)
+			}
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, req)
+
+			assert.Equal(t, tt.status, rr.Code)
+		})
+	}
+}
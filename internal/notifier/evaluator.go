@@ -0,0 +1,205 @@
+package notifier
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"Vova4o/metrix/internal/expr"
+	"Vova4o/metrix/internal/handlers"
+	"Vova4o/metrix/internal/logger"
+
+	"github.com/sirupsen/logrus"
+)
+
+type compiledAlert struct {
+	AlertRule
+	node   expr.Node
+	active map[string]*activeAlert // по метрике, см. sampleKey
+}
+
+// activeAlert is a metric selected by a rule, pending until For has
+// passed and firing after that
+type activeAlert struct {
+	alert  Alert
+	firing bool
+}
+
+// Evaluator checks the alert rules against the storage and hands the
+// alerts that fire or resolve to the Notifier. Silenced alerts are
+// still evaluated and notified once their silence ends. An alert whose
+// firing was never notified resolves without a notification
+type Evaluator struct {
+	storage  handlers.Storager
+	history  handlers.Historian
+	notifier *Notifier
+	interval time.Duration
+	rules    []*compiledAlert
+}
+
+// NewEvaluator parses the alert rules of cfg. It fails on unnamed
+// or duplicate rules and on invalid expressions
+func NewEvaluator(cfg Config, s handlers.Storager, h handlers.Historian, n *Notifier) (*Evaluator, error) {
+	cfg.setDefaults()
+
+	e := &Evaluator{
+		storage:  s,
+		history:  h,
+		notifier: n,
+		interval: cfg.EvalInterval.Duration,
+	}
+
+	seen := make(map[string]bool, len(cfg.Alerts))
+	for i, rule := range cfg.Alerts {
+		if rule.Name == "" {
+			return nil, fmt.Errorf("alert %d: name is required", i)
+		}
+		if seen[rule.Name] {
+			return nil, fmt.Errorf("alert %d: name %q is defined more than once", i, rule.Name)
+		}
+		seen[rule.Name] = true
+
+		node, err := expr.Parse(rule.Expr)
+		if err != nil {
+			return nil, fmt.Errorf("alert %q: %w", rule.Name, err)
+		}
+		e.rules = append(e.rules, &compiledAlert{
+			AlertRule: rule,
+			node:      node,
+			active:    make(map[string]*activeAlert),
+		})
+	}
+	return e, nil
+}
+
+// Run evaluates the rules on every tick until the context is done
+func (e *Evaluator) Run(ctx context.Context) {
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+
+	e.EvalAll(time.Now())
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			e.EvalAll(now)
+		}
+	}
+}
+
+// EvalAll evaluates every rule once. A failing rule keeps its alerts
+// as they were and does not stop the others
+func (e *Evaluator) EvalAll(now time.Time) {
+	for _, rule := range e.rules {
+		if err := e.eval(rule, now); err != nil {
+			logger.Log.WithError(err).WithField("rule", rule.Name).Warn("Failed to evaluate alert rule")
+		}
+	}
+}
+
+func (e *Evaluator) eval(rule *compiledAlert, now time.Time) error {
+	value, err := expr.Eval(rule.node, expr.Env{Storage: e.storage, History: e.history, Now: now})
+	if err != nil {
+		return err
+	}
+	vector, ok := value.(expr.Vector)
+	if !ok {
+		return fmt.Errorf("expression returned a %s, alert rules must select metrics", value.Type())
+	}
+
+	selected := make(map[string]bool, len(vector))
+	for _, sample := range vector {
+		key := sampleKey(sample)
+		selected[key] = true
+
+		active, ok := rule.active[key]
+		if !ok {
+			active = &activeAlert{alert: Alert{
+				Rule:     rule.Name,
+				MetricID: sample.Name,
+				MType:    sample.MType,
+				StartsAt: now,
+				Labels:   mergeLabels(sample.Labels, rule.Labels),
+			}}
+			rule.active[key] = active
+		}
+		active.alert.Value = float64(sample.Value)
+
+		if !active.firing && now.Sub(active.alert.StartsAt) >= rule.For.Duration {
+			alert := active.alert
+			alert.Status = StatusFiring
+			// Не удалось поставить в очередь или алерт заглушён - попробуем на следующем такте
+			active.firing = e.notify(alert)
+		}
+	}
+
+	for key, active := range rule.active {
+		if selected[key] {
+			continue
+		}
+		if active.firing {
+			alert := active.alert
+			alert.Status = StatusResolved
+			alert.EndsAt = now
+			if !e.notify(alert) {
+				continue
+			}
+		}
+		delete(rule.active, key)
+	}
+	return nil
+}
+
+// notify hands the alert to the Notifier and reports whether it was queued
+func (e *Evaluator) notify(alert Alert) bool {
+	err := e.notifier.Notify(alert)
+	if err == nil {
+		return true
+	}
+
+	log := logger.Log.WithFields(logrus.Fields{
+		"status": alert.Status,
+		"rule":   alert.Rule,
+		"id":     alert.MetricID,
+	})
+	if errors.Is(err, ErrSilenced) {
+		log.Debug("Alert is silenced, not notifying")
+	} else {
+		log.WithError(err).Error("Failed to notify alert")
+	}
+	return false
+}
+
+// sampleKey identifies the metric behind a sample between evaluations
+func sampleKey(s expr.Sample) string {
+	names := make([]string, 0, len(s.Labels))
+	for name := range s.Labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	b.WriteString(s.MType + "/" + s.Name)
+	for _, name := range names {
+		b.WriteString("," + name + "=" + s.Labels[name])
+	}
+	return b.String()
+}
+
+func mergeLabels(metric, rule map[string]string) map[string]string {
+	if len(metric)+len(rule) == 0 {
+		return nil
+	}
+	labels := make(map[string]string, len(metric)+len(rule))
+	for k, v := range metric {
+		labels[k] = v
+	}
+	for k, v := range rule {
+		labels[k] = v
+	}
+	return labels
+}
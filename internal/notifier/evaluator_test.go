@@ -0,0 +1,164 @@
+package notifier
+
+import (
+	"testing"
+	"time"
+
+	"Vova4o/metrix/internal/logger"
+	"Vova4o/metrix/internal/storage"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// queued returns the alerts waiting for delivery, oldest first
+func queued(n *Notifier) []Alert {
+	var alerts []Alert
+	for _, d := range n.queue.Due(time.Now().Add(time.Hour)) {
+		alerts = append(alerts, d.Alert)
+	}
+	return alerts
+}
+
+func TestEvaluator_FiresAndResolves(t *testing.T) {
+	_ = logger.New("test.log")
+
+	s := storage.NewMemStorage()
+	s.SetGauge("HeapAlloc", 2048)
+	s.SetGauge("HeapSys", 512)
+
+	cfg := testConfig(Route{Webhooks: []string{"http://alerts.example"}})
+	cfg.Alerts = []AlertRule{{
+		Name:   "HighHeap",
+		Expr:   `{type="gauge"} > 1024`,
+		For:    Duration{time.Minute},
+		Labels: map[string]string{"severity": "page"},
+	}}
+	n, err := New(cfg, nil)
+	require.NoError(t, err)
+	e, err := NewEvaluator(cfg, s, nil, n)
+	require.NoError(t, err)
+
+	start := time.Now()
+	e.EvalAll(start)
+	assert.Empty(t, queued(n), "pending until For has passed")
+
+	e.EvalAll(start.Add(time.Minute))
+	alerts := queued(n)
+	require.Len(t, alerts, 1)
+	assert.Equal(t, StatusFiring, alerts[0].Status)
+	assert.Equal(t, "HighHeap", alerts[0].Rule)
+	assert.Equal(t, "HeapAlloc", alerts[0].MetricID)
+	assert.Equal(t, "gauge", alerts[0].MType)
+	assert.Equal(t, 2048.0, alerts[0].Value)
+	assert.Equal(t, start, alerts[0].StartsAt)
+	assert.Equal(t, "page", alerts[0].Labels["severity"])
+
+	// A firing alert is not sent again while it keeps firing
+	e.EvalAll(start.Add(2 * time.Minute))
+	assert.Len(t, queued(n), 1)
+
+	s.SetGauge("HeapAlloc", 100)
+	e.EvalAll(start.Add(3 * time.Minute))
+	alerts = queued(n)
+	require.Len(t, alerts, 2)
+	assert.Equal(t, StatusResolved, alerts[1].Status)
+	assert.Equal(t, "HeapAlloc", alerts[1].MetricID)
+	assert.Equal(t, start.Add(3*time.Minute), alerts[1].EndsAt)
+}
+
+func TestEvaluator_PendingAlertResolvesSilently(t *testing.T) {
+	_ = logger.New("test.log")
+
+	s := storage.NewMemStorage()
+	s.SetGauge("HeapAlloc", 2048)
+
+	cfg := testConfig(Route{Webhooks: []string{"http://alerts.example"}})
+	cfg.Alerts = []AlertRule{{Name: "HighHeap", Expr: "HeapAlloc > 1024", For: Duration{time.Minute}}}
+	n, err := New(cfg, nil)
+	require.NoError(t, err)
+	e, err := NewEvaluator(cfg, s, nil, n)
+	require.NoError(t, err)
+
+	start := time.Now()
+	e.EvalAll(start)
+	s.SetGauge("HeapAlloc", 100)
+	e.EvalAll(start.Add(30 * time.Second))
+
+	// Crossing the threshold again starts For over
+	s.SetGauge("HeapAlloc", 2048)
+	e.EvalAll(start.Add(time.Minute))
+	assert.Empty(t, queued(n))
+}
+
+func TestNewEvaluator_InvalidRules(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules []AlertRule
+	}{
+		{"no name", []AlertRule{{Expr: "HeapAlloc > 1"}}},
+		{"duplicate", []AlertRule{{Name: "a", Expr: "HeapAlloc > 1"}, {Name: "a", Expr: "NumGC > 1"}}},
+		{"bad expression", []AlertRule{{Name: "a", Expr: "HeapAlloc >"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig()
+			cfg.Alerts = tt.rules
+			_, err := NewEvaluator(cfg, storage.NewMemStorage(), nil, nil)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestEvaluator_SilencedAlertsAreNotifiedAfterTheSilence(t *testing.T) {
+	_ = logger.New("test.log")
+
+	s := storage.NewMemStorage()
+	s.SetGauge("HeapAlloc", 2048)
+	s.SetGauge("HeapIdle", 2048)
+	s.SetGauge("HeapSys", 2048)
+
+	silencer, err := NewSilencer(nil)
+	require.NoError(t, err)
+	now := time.Now()
+	var silences []string
+	for _, id := range []string{"HeapAlloc", "HeapIdle"} {
+		silence, err := silencer.Add(Silence{
+			Matchers: []Matcher{{Name: "id", Value: id}},
+			StartsAt: now.Add(-time.Minute),
+			EndsAt:   now.Add(time.Hour),
+		})
+		require.NoError(t, err)
+		silences = append(silences, silence.ID)
+	}
+
+	cfg := testConfig(Route{Webhooks: []string{"http://alerts.example"}})
+	cfg.Alerts = []AlertRule{{Name: "HighHeap", Expr: `{type="gauge"} > 1024`}}
+	n, err := New(cfg, silencer)
+	require.NoError(t, err)
+	e, err := NewEvaluator(cfg, s, nil, n)
+	require.NoError(t, err)
+
+	// All metrics fire, only the one outside the silences is queued
+	e.EvalAll(now)
+	alerts := queued(n)
+	require.Len(t, alerts, 1)
+	assert.Equal(t, "HeapSys", alerts[0].MetricID)
+
+	// Its firing was never notified, so neither is its resolution
+	s.SetGauge("HeapIdle", 100)
+	e.EvalAll(now.Add(time.Minute))
+	assert.Len(t, queued(n), 1)
+
+	// Once the silences end the alert still firing is notified
+	for _, id := range silences {
+		require.True(t, silencer.Delete(id))
+	}
+	e.EvalAll(now.Add(2 * time.Minute))
+	alerts = queued(n)
+	require.Len(t, alerts, 2)
+	assert.Equal(t, "HeapAlloc", alerts[1].MetricID)
+	assert.Equal(t, StatusFiring, alerts[1].Status)
+	assert.Equal(t, now, alerts[1].StartsAt)
+}
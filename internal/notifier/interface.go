@@ -0,0 +1,115 @@
+package notifier
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+const (
+	StatusFiring   = "firing"
+	StatusResolved = "resolved"
+)
+
+// Alert is the JSON payload posted to webhook receivers
+// when an alert fires or resolves
+type Alert struct {
+	Status   string    `json:"status"`    // firing или resolved
+	Rule     string    `json:"rule"`      // имя правила, вызвавшего алерт
+	MetricID string    `json:"id"`        // имя метрики
+	MType    string    `json:"type"`      // gauge или counter
+	Value    float64   `json:"value"`     // значение метрики в момент срабатывания
+	StartsAt time.Time `json:"starts_at"` // время срабатывания
+	EndsAt   time.Time `json:"ends_at,omitempty"`
+
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// Route sends alerts of the listed rules to the listed webhooks.
+// A route without rules matches every rule
+type Route struct {
+	Rules    []string `json:"rules"`
+	Webhooks []string `json:"webhooks"`
+}
+
+// AlertRule fires for every metric its expression selects, for example
+// "HeapAlloc > 1e9", once the metric has stayed selected for For
+type AlertRule struct {
+	Name   string            `json:"name"`
+	Expr   string            `json:"expr"`
+	For    Duration          `json:"for"`    // 0 - срабатывает сразу
+	Labels map[string]string `json:"labels"` // добавляются к меткам метрики
+}
+
+// Config describes which alerts fire, and where and how they are delivered
+type Config struct {
+	Alerts       []AlertRule `json:"alerts"`
+	EvalInterval Duration    `json:"eval_interval"`
+
+	Routes         []Route  `json:"routes"`
+	QueuePath      string   `json:"queue_path"`
+	MaxAttempts    int      `json:"max_attempts"`
+	InitialBackoff Duration `json:"initial_backoff"`
+	MaxBackoff     Duration `json:"max_backoff"`
+	Timeout        Duration `json:"timeout"`
+
+	MaintenanceWindows []MaintenanceWindow `json:"maintenance_windows"`
+}
+
+// Duration is a time.Duration that is written as "1m30s" in config files
+type Duration struct {
+	time.Duration
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration must be a string like \"10s\": %w", err)
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	d.Duration = v
+	return nil
+}
+
+// LoadConfig reads the notifier config from a JSON file
+// and fills in defaults for the values that are not set
+func LoadConfig(path string) (Config, error) {
+	var cfg Config
+
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, fmt.Errorf("failed to read notifier config %s: %w", path, err)
+	}
+	if err := json.Unmarshal(contents, &cfg); err != nil {
+		return cfg, fmt.Errorf("failed to parse notifier config %s: %w", path, err)
+	}
+
+	cfg.setDefaults()
+	return cfg, nil
+}
+
+func (c *Config) setDefaults() {
+	if c.EvalInterval.Duration <= 0 {
+		c.EvalInterval.Duration = 15 * time.Second
+	}
+	if c.MaxAttempts <= 0 {
+		c.MaxAttempts = 10
+	}
+	if c.InitialBackoff.Duration <= 0 {
+		c.InitialBackoff.Duration = time.Second
+	}
+	if c.MaxBackoff.Duration <= 0 {
+		c.MaxBackoff.Duration = 5 * time.Minute
+	}
+	if c.Timeout.Duration <= 0 {
+		c.Timeout.Duration = 10 * time.Second
+	}
+}
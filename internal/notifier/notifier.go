@@ -0,0 +1,202 @@
+package notifier
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"Vova4o/metrix/internal/logger"
+
+	"github.com/sirupsen/logrus"
+)
+
+// pollInterval is how often the delivery loop looks for retries that became due
+const pollInterval = 500 * time.Millisecond
+
+// ErrSilenced is returned by Notify for the alerts muted by the silencer
+var ErrSilenced = errors.New("alert is silenced")
+
+// Notifier routes alerts to webhooks and delivers them from a persistent
+// queue, retrying failed posts with exponential backoff
+type Notifier struct {
+	cfg      Config
+	queue    *Queue
+	silencer *Silencer
+	client   *http.Client
+	wake     chan struct{}
+}
+
+// New creates a Notifier and restores the deliveries queued by a previous run.
+// Alerts muted by the silencer are refused; silencer may be nil
+func New(cfg Config, silencer *Silencer) (*Notifier, error) {
+	cfg.setDefaults()
+
+	queue, err := NewQueue(cfg.QueuePath)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Notifier{
+		cfg:      cfg,
+		queue:    queue,
+		silencer: silencer,
+		client:   &http.Client{Timeout: cfg.Timeout.Duration},
+		wake:     make(chan struct{}, 1),
+	}, nil
+}
+
+// Notify queues the alert for every webhook whose route matches its rule.
+// A silenced alert is not queued and ErrSilenced is returned, so the
+// caller can notify it again once the silence ends
+func (n *Notifier) Notify(alert Alert) error {
+	if n.silencer != nil && n.silencer.IsSilenced(alert) {
+		return ErrSilenced
+	}
+
+	for _, url := range n.webhooksFor(alert.Rule) {
+		if err := n.queue.Push(url, alert); err != nil {
+			return fmt.Errorf("failed to queue alert %s for %s: %w", alert.Rule, url, err)
+		}
+	}
+
+	select {
+	case n.wake <- struct{}{}:
+	default:
+	}
+
+	return nil
+}
+
+// Pending returns the number of deliveries that have not succeeded yet
+func (n *Notifier) Pending() int {
+	return n.queue.Len()
+}
+
+// Run delivers queued alerts until ctx is cancelled
+func (n *Notifier) Run(ctx context.Context) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		n.deliverDue(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-n.wake:
+		}
+	}
+}
+
+func (n *Notifier) webhooksFor(rule string) []string {
+	seen := make(map[string]bool)
+	var urls []string
+
+	for _, route := range n.cfg.Routes {
+		if !route.matches(rule) {
+			continue
+		}
+		for _, url := range route.Webhooks {
+			if !seen[url] {
+				seen[url] = true
+				urls = append(urls, url)
+			}
+		}
+	}
+	return urls
+}
+
+func (r Route) matches(rule string) bool {
+	if len(r.Rules) == 0 {
+		return true
+	}
+	for _, name := range r.Rules {
+		if name == rule {
+			return true
+		}
+	}
+	return false
+}
+
+func (n *Notifier) deliverDue(ctx context.Context) {
+	for _, d := range n.queue.Due(time.Now()) {
+		if ctx.Err() != nil {
+			return
+		}
+		n.deliver(ctx, d)
+	}
+}
+
+func (n *Notifier) deliver(ctx context.Context, d Delivery) {
+	err := n.post(ctx, d.URL, d.Alert)
+	if err == nil {
+		if err := n.queue.Remove(d.ID); err != nil {
+			logger.Log.WithError(err).Error("Failed to remove delivered alert from queue")
+		}
+		return
+	}
+
+	d.Attempts++
+	d.LastError = err.Error()
+	fields := logrus.Fields{
+		"rule":     d.Alert.Rule,
+		"url":      d.URL,
+		"attempts": d.Attempts,
+	}
+
+	if d.Attempts >= n.cfg.MaxAttempts {
+		logger.Log.WithError(err).WithFields(fields).Error("Giving up on alert delivery")
+		if err := n.queue.Remove(d.ID); err != nil {
+			logger.Log.WithError(err).Error("Failed to remove alert from queue")
+		}
+		return
+	}
+
+	d.NextAttempt = time.Now().Add(n.backoff(d.Attempts))
+	logger.Log.WithError(err).WithFields(fields).Warn("Alert delivery failed, will retry")
+	if err := n.queue.Update(d); err != nil {
+		logger.Log.WithError(err).Error("Failed to update alert in queue")
+	}
+}
+
+// backoff returns the delay before the next attempt:
+// InitialBackoff doubled for every failed attempt, capped at MaxBackoff
+func (n *Notifier) backoff(attempts int) time.Duration {
+	delay := n.cfg.InitialBackoff.Duration
+	for i := 1; i < attempts; i++ {
+		delay *= 2
+		if delay >= n.cfg.MaxBackoff.Duration {
+			return n.cfg.MaxBackoff.Duration
+		}
+	}
+	return delay
+}
+
+func (n *Notifier) post(ctx context.Context, url string, alert Alert) error {
+	body, err := json.Marshal(alert)
+	if err != nil {
+		return fmt.Errorf("failed to marshal alert: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned non-2xx status: %s", resp.Status)
+	}
+	return nil
+}
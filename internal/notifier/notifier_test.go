@@ -0,0 +1,125 @@
+package notifier
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"Vova4o/metrix/internal/logger"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testConfig(routes ...Route) Config {
+	return Config{
+		Routes:         routes,
+		MaxAttempts:    5,
+		InitialBackoff: Duration{time.Millisecond},
+		MaxBackoff:     Duration{10 * time.Millisecond},
+	}
+}
+
+func TestNotifier_DeliversToReceiver(t *testing.T) {
+	_ = logger.New("test.log")
+
+	receiver := NewReceiver()
+	server := httptest.NewServer(receiver.HandleReceive())
+	defer server.Close()
+
+	n, err := New(testConfig(Route{Rules: []string{"HighHeap"}, Webhooks: []string{server.URL}}), nil)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go n.Run(ctx)
+
+	alert := Alert{Status: StatusFiring, Rule: "HighHeap", MetricID: "HeapAlloc", MType: "gauge", Value: 1024}
+	require.NoError(t, n.Notify(alert))
+
+	assert.Eventually(t, func() bool { return len(receiver.Alerts()) == 1 }, time.Second, 10*time.Millisecond)
+	got := receiver.Alerts()[0]
+	assert.Equal(t, "HighHeap", got.Rule)
+	assert.Equal(t, "HeapAlloc", got.MetricID)
+	assert.Equal(t, "gauge", got.MType)
+	assert.Equal(t, 1024.0, got.Value)
+	assert.Equal(t, 0, n.Pending())
+}
+
+func TestNotifier_RetriesFailedDeliveries(t *testing.T) {
+	_ = logger.New("test.log")
+
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	n, err := New(testConfig(Route{Webhooks: []string{server.URL}}), nil)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go n.Run(ctx)
+
+	require.NoError(t, n.Notify(Alert{Status: StatusFiring, Rule: "any"}))
+
+	assert.Eventually(t, func() bool { return n.Pending() == 0 }, 3*time.Second, 10*time.Millisecond)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&calls))
+}
+
+func TestNotifier_GivesUpAfterMaxAttempts(t *testing.T) {
+	_ = logger.New("test.log")
+
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	cfg := testConfig(Route{Webhooks: []string{server.URL}})
+	cfg.MaxAttempts = 2
+	n, err := New(cfg, nil)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go n.Run(ctx)
+
+	require.NoError(t, n.Notify(Alert{Status: StatusFiring, Rule: "any"}))
+
+	assert.Eventually(t, func() bool { return n.Pending() == 0 }, 3*time.Second, 10*time.Millisecond)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+}
+
+func TestNotifier_Routes(t *testing.T) {
+	n, err := New(testConfig(
+		Route{Rules: []string{"HighHeap"}, Webhooks: []string{"http://a", "http://b"}},
+		Route{Webhooks: []string{"http://b", "http://c"}},
+	), nil)
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"http://a", "http://b", "http://c"}, n.webhooksFor("HighHeap"))
+	assert.Equal(t, []string{"http://b", "http://c"}, n.webhooksFor("ManyGC"))
+}
+
+func TestNotifier_Backoff(t *testing.T) {
+	n, err := New(Config{
+		InitialBackoff: Duration{time.Second},
+		MaxBackoff:     Duration{5 * time.Second},
+	}, nil)
+	require.NoError(t, err)
+
+	assert.Equal(t, time.Second, n.backoff(1))
+	assert.Equal(t, 2*time.Second, n.backoff(2))
+	assert.Equal(t, 4*time.Second, n.backoff(3))
+	assert.Equal(t, 5*time.Second, n.backoff(4))
+}
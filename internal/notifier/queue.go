@@ -0,0 +1,160 @@
+package notifier
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Delivery is a single alert waiting to be posted to a single webhook
+type Delivery struct {
+	ID          uint64    `json:"id"`
+	URL         string    `json:"url"`
+	Alert       Alert     `json:"alert"`
+	Attempts    int       `json:"attempts"`
+	NextAttempt time.Time `json:"next_attempt"`
+	LastError   string    `json:"last_error,omitempty"`
+}
+
+// Queue keeps pending deliveries and writes them to disk on every change,
+// so notifications survive a server restart.
+// An empty path keeps the queue in memory only
+type Queue struct {
+	mu     sync.Mutex
+	path   string
+	nextID uint64
+	items  []Delivery
+}
+
+// NewQueue creates a queue backed by the file at path
+// and loads the deliveries left there by a previous run
+func NewQueue(path string) (*Queue, error) {
+	q := &Queue{path: path, nextID: 1}
+	if path == "" {
+		return q, nil
+	}
+
+	contents, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return q, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read notification queue %s: %w", path, err)
+	}
+	if len(contents) == 0 {
+		return q, nil
+	}
+
+	if err := json.Unmarshal(contents, &q.items); err != nil {
+		return nil, fmt.Errorf("failed to parse notification queue %s: %w", path, err)
+	}
+	for _, d := range q.items {
+		if d.ID >= q.nextID {
+			q.nextID = d.ID + 1
+		}
+	}
+
+	return q, nil
+}
+
+// Push adds a new delivery that is due immediately
+func (q *Queue) Push(url string, alert Alert) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.items = append(q.items, Delivery{
+		ID:          q.nextID,
+		URL:         url,
+		Alert:       alert,
+		NextAttempt: time.Now(),
+	})
+	q.nextID++
+
+	return q.save()
+}
+
+// Due returns the deliveries whose next attempt is not after now
+func (q *Queue) Due(now time.Time) []Delivery {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var due []Delivery
+	for _, d := range q.items {
+		if !d.NextAttempt.After(now) {
+			due = append(due, d)
+		}
+	}
+	return due
+}
+
+// Update replaces the stored delivery with the same ID
+func (q *Queue) Update(d Delivery) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for i := range q.items {
+		if q.items[i].ID == d.ID {
+			q.items[i] = d
+			return q.save()
+		}
+	}
+	return nil
+}
+
+// Remove drops the delivery with the given ID
+func (q *Queue) Remove(id uint64) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for i := range q.items {
+		if q.items[i].ID == id {
+			q.items = append(q.items[:i], q.items[i+1:]...)
+			return q.save()
+		}
+	}
+	return nil
+}
+
+// Len returns the number of pending deliveries
+func (q *Queue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.items)
+}
+
+// save writes the queue to a temporary file and renames it over the old one,
+// so a crash in the middle of a write never leaves a truncated queue
+func (q *Queue) save() error {
+	if q.path == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(q.items)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(q.path), filepath.Base(q.path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary queue file: %w", err)
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	return os.Rename(tmp.Name(), q.path)
+}
@@ -0,0 +1,58 @@
+package notifier
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQueue_SurvivesRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queue.json")
+
+	q, err := NewQueue(path)
+	require.NoError(t, err)
+	require.NoError(t, q.Push("http://a", Alert{Rule: "first"}))
+	require.NoError(t, q.Push("http://b", Alert{Rule: "second"}))
+
+	due := q.Due(time.Now())
+	require.Len(t, due, 2)
+	require.NoError(t, q.Remove(due[0].ID))
+
+	// A new queue on the same file sees only the undelivered alert
+	restored, err := NewQueue(path)
+	require.NoError(t, err)
+	items := restored.Due(time.Now())
+	require.Len(t, items, 1)
+	assert.Equal(t, "second", items[0].Alert.Rule)
+
+	// IDs keep growing after a restart
+	require.NoError(t, restored.Push("http://c", Alert{Rule: "third"}))
+	items = restored.Due(time.Now())
+	require.Len(t, items, 2)
+	assert.Greater(t, items[1].ID, items[0].ID)
+}
+
+func TestQueue_DueRespectsNextAttempt(t *testing.T) {
+	q, err := NewQueue("")
+	require.NoError(t, err)
+	require.NoError(t, q.Push("http://a", Alert{Rule: "later"}))
+
+	d := q.Due(time.Now())[0]
+	d.NextAttempt = time.Now().Add(time.Hour)
+	require.NoError(t, q.Update(d))
+
+	assert.Empty(t, q.Due(time.Now()))
+	assert.Len(t, q.Due(time.Now().Add(2*time.Hour)), 1)
+}
+
+func TestNewQueue_InvalidFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queue.json")
+	require.NoError(t, os.WriteFile(path, []byte("not json"), 0o644))
+
+	_, err := NewQueue(path)
+	assert.Error(t, err)
+}
@@ -0,0 +1,73 @@
+package notifier
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+
+	"Vova4o/metrix/internal/handlers"
+	"Vova4o/metrix/internal/logger"
+
+	"github.com/sirupsen/logrus"
+)
+
+// receiverCapacity is how many alerts the local receiver remembers
+const receiverCapacity = 100
+
+// Receiver is a local webhook endpoint that accepts alert payloads
+// and keeps the most recent ones, so routes can be checked without
+// an external service
+type Receiver struct {
+	mu     sync.Mutex
+	alerts []Alert
+}
+
+func NewReceiver() *Receiver {
+	return &Receiver{}
+}
+
+// HandleReceive accepts an alert posted by a Notifier
+func (rc *Receiver) HandleReceive() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var alert Alert
+		if err := json.NewDecoder(r.Body).Decode(&alert); err != nil {
+			handlers.WriteProblem(w, r, handlers.DecodeProblem(err))
+			return
+		}
+
+		logger.Log.WithFields(logrus.Fields{
+			"status": alert.Status,
+			"rule":   alert.Rule,
+			"id":     alert.MetricID,
+			"type":   alert.MType,
+			"value":  alert.Value,
+		}).Info("Received alert")
+
+		rc.mu.Lock()
+		rc.alerts = append(rc.alerts, alert)
+		if len(rc.alerts) > receiverCapacity {
+			rc.alerts = rc.alerts[len(rc.alerts)-receiverCapacity:]
+		}
+		rc.mu.Unlock()
+
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// HandleList returns the received alerts, oldest first
+func (rc *Receiver) HandleList() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(rc.Alerts())
+	}
+}
+
+// Alerts returns a copy of the received alerts
+func (rc *Receiver) Alerts() []Alert {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	alerts := make([]Alert, len(rc.alerts))
+	copy(alerts, rc.alerts)
+	return alerts
+}
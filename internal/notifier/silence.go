@@ -0,0 +1,305 @@
+package notifier
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Matcher matches one alert label either literally or by a regular expression
+type Matcher struct {
+	Name    string `json:"name"`
+	Value   string `json:"value"`
+	IsRegex bool   `json:"regex,omitempty"`
+
+	re *regexp.Regexp
+}
+
+// Silence mutes notifications for alerts matching all of its matchers
+// between StartsAt and EndsAt
+type Silence struct {
+	ID        string    `json:"id"`
+	Matchers  []Matcher `json:"matchers"`
+	StartsAt  time.Time `json:"starts_at"`
+	EndsAt    time.Time `json:"ends_at"`
+	CreatedBy string    `json:"created_by,omitempty"`
+	Comment   string    `json:"comment,omitempty"`
+}
+
+// MaintenanceWindow is a silence that repeats every day,
+// or on the listed weekdays only, starting at Start for Duration
+type MaintenanceWindow struct {
+	Name     string    `json:"name"`
+	Matchers []Matcher `json:"matchers"`
+	Weekdays []string  `json:"weekdays,omitempty"` // Mon, Tue, ...; пусто — каждый день
+	Start    string    `json:"start"`              // HH:MM
+	Duration Duration  `json:"duration"`
+	Location string    `json:"location,omitempty"` // например Europe/Moscow; пусто — UTC
+
+	loc      *time.Location
+	startMin int
+	days     map[time.Weekday]bool
+}
+
+// Silencer decides whether an alert is muted by a silence
+// or by a maintenance window
+type Silencer struct {
+	mu       sync.RWMutex
+	silences map[string]Silence
+	windows  []MaintenanceWindow
+	now      func() time.Time
+}
+
+// matchLabels returns the labels silences are matched against:
+// the alert's own labels plus the metric ID as "id", its type and the rule name
+func (a Alert) matchLabels() map[string]string {
+	labels := make(map[string]string, len(a.Labels)+3)
+	for k, v := range a.Labels {
+		labels[k] = v
+	}
+	labels["id"] = a.MetricID
+	labels["type"] = a.MType
+	labels["rule"] = a.Rule
+	return labels
+}
+
+// NewSilencer creates a Silencer with the given maintenance windows
+func NewSilencer(windows []MaintenanceWindow) (*Silencer, error) {
+	for i := range windows {
+		if err := windows[i].compile(); err != nil {
+			return nil, fmt.Errorf("maintenance window %q: %w", windows[i].Name, err)
+		}
+	}
+
+	return &Silencer{
+		silences: make(map[string]Silence),
+		windows:  windows,
+		now:      time.Now,
+	}, nil
+}
+
+// Add validates the silence, assigns it an ID and stores it
+func (s *Silencer) Add(silence Silence) (Silence, error) {
+	if err := silence.compile(); err != nil {
+		return Silence{}, err
+	}
+	if silence.StartsAt.IsZero() {
+		silence.StartsAt = s.now()
+	}
+	if !silence.EndsAt.After(silence.StartsAt) {
+		return Silence{}, errors.New("ends_at must be after starts_at")
+	}
+
+	id, err := newSilenceID()
+	if err != nil {
+		return Silence{}, err
+	}
+	silence.ID = id
+
+	s.mu.Lock()
+	s.silences[id] = silence
+	s.mu.Unlock()
+
+	return silence, nil
+}
+
+// List returns the silences that have not expired yet, soonest ending first
+func (s *Silencer) List() []Silence {
+	now := s.now()
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	list := make([]Silence, 0, len(s.silences))
+	for _, silence := range s.silences {
+		if silence.EndsAt.After(now) {
+			list = append(list, silence)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].EndsAt.Equal(list[j].EndsAt) {
+			return list[i].ID < list[j].ID
+		}
+		return list[i].EndsAt.Before(list[j].EndsAt)
+	})
+	return list
+}
+
+// Delete removes the silence with the given ID
+func (s *Silencer) Delete(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.silences[id]; !ok {
+		return false
+	}
+	delete(s.silences, id)
+	return true
+}
+
+// IsSilenced reports whether the alert is muted right now
+func (s *Silencer) IsSilenced(alert Alert) bool {
+	now := s.now()
+	labels := alert.matchLabels()
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, silence := range s.silences {
+		if silence.activeAt(now) && matchAll(silence.Matchers, labels) {
+			return true
+		}
+	}
+	for _, w := range s.windows {
+		if w.activeAt(now) && matchAll(w.Matchers, labels) {
+			return true
+		}
+	}
+	return false
+}
+
+// MarshalJSON stores the unexpired silences in the snapshot file
+func (s *Silencer) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.List())
+}
+
+// UnmarshalJSON restores silences saved in the snapshot file
+func (s *Silencer) UnmarshalJSON(b []byte) error {
+	var list []Silence
+	if err := json.Unmarshal(b, &list); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, silence := range list {
+		if err := silence.compile(); err != nil {
+			return fmt.Errorf("silence %s: %w", silence.ID, err)
+		}
+		s.silences[silence.ID] = silence
+	}
+	return nil
+}
+
+func (m Matcher) matches(labels map[string]string) bool {
+	value := labels[m.Name]
+	if m.re != nil {
+		return m.re.MatchString(value)
+	}
+	return value == m.Value
+}
+
+func matchAll(matchers []Matcher, labels map[string]string) bool {
+	for _, m := range matchers {
+		if !m.matches(labels) {
+			return false
+		}
+	}
+	return true
+}
+
+func compileMatchers(matchers []Matcher) error {
+	if len(matchers) == 0 {
+		return errors.New("at least one matcher is required")
+	}
+	for i := range matchers {
+		if matchers[i].Name == "" {
+			return errors.New("matcher name cannot be empty")
+		}
+		if !matchers[i].IsRegex {
+			continue
+		}
+		// Anchor the expression so "Heap" does not match "HeapAlloc"
+		re, err := regexp.Compile("^(?:" + matchers[i].Value + ")$")
+		if err != nil {
+			return fmt.Errorf("invalid regex for %s: %w", matchers[i].Name, err)
+		}
+		matchers[i].re = re
+	}
+	return nil
+}
+
+func (s *Silence) compile() error {
+	return compileMatchers(s.Matchers)
+}
+
+func (s Silence) activeAt(now time.Time) bool {
+	return !now.Before(s.StartsAt) && now.Before(s.EndsAt)
+}
+
+func (w *MaintenanceWindow) compile() error {
+	if err := compileMatchers(w.Matchers); err != nil {
+		return err
+	}
+
+	start, err := time.Parse("15:04", w.Start)
+	if err != nil {
+		return fmt.Errorf("start must be HH:MM: %w", err)
+	}
+	w.startMin = start.Hour()*60 + start.Minute()
+
+	if w.Duration.Duration <= 0 {
+		return errors.New("duration must be positive")
+	}
+
+	w.loc = time.UTC
+	if w.Location != "" {
+		if w.loc, err = time.LoadLocation(w.Location); err != nil {
+			return err
+		}
+	}
+
+	w.days = make(map[time.Weekday]bool, len(w.Weekdays))
+	for _, name := range w.Weekdays {
+		day, ok := weekdays[strings.ToLower(name)]
+		if !ok {
+			return fmt.Errorf("unknown weekday %q", name)
+		}
+		w.days[day] = true
+	}
+	return nil
+}
+
+// activeAt checks the window that started today and the one that started
+// yesterday, because a window may run past midnight
+func (w MaintenanceWindow) activeAt(now time.Time) bool {
+	local := now.In(w.loc)
+	midnight := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, w.loc)
+
+	for _, day := range []time.Time{midnight, midnight.AddDate(0, 0, -1)} {
+		if len(w.days) > 0 && !w.days[day.Weekday()] {
+			continue
+		}
+		start := day.Add(time.Duration(w.startMin) * time.Minute)
+		if !local.Before(start) && local.Before(start.Add(w.Duration.Duration)) {
+			return true
+		}
+	}
+	return false
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+func newSilenceID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate silence id: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
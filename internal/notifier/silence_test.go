@@ -0,0 +1,214 @@
+package notifier
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"Vova4o/metrix/internal/logger"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSilencer_IsSilenced(t *testing.T) {
+	s, err := NewSilencer(nil)
+	require.NoError(t, err)
+
+	now := time.Now()
+	_, err = s.Add(Silence{
+		Matchers: []Matcher{{Name: "id", Value: "HeapAlloc|NumGC", IsRegex: true}},
+		StartsAt: now.Add(-time.Minute),
+		EndsAt:   now.Add(time.Hour),
+	})
+	require.NoError(t, err)
+
+	assert.True(t, s.IsSilenced(Alert{MetricID: "HeapAlloc", MType: "gauge"}))
+	assert.True(t, s.IsSilenced(Alert{MetricID: "NumGC", MType: "gauge"}))
+	assert.False(t, s.IsSilenced(Alert{MetricID: "HeapAllocMax", MType: "gauge"}))
+	assert.False(t, s.IsSilenced(Alert{MetricID: "PollCount", MType: "counter"}))
+}
+
+func TestSilencer_Labels(t *testing.T) {
+	s, err := NewSilencer(nil)
+	require.NoError(t, err)
+
+	_, err = s.Add(Silence{
+		Matchers: []Matcher{
+			{Name: "type", Value: "gauge"},
+			{Name: "host", Value: "web-.*", IsRegex: true},
+		},
+		EndsAt: time.Now().Add(time.Hour),
+	})
+	require.NoError(t, err)
+
+	assert.True(t, s.IsSilenced(Alert{MType: "gauge", Labels: map[string]string{"host": "web-1"}}))
+	assert.False(t, s.IsSilenced(Alert{MType: "gauge", Labels: map[string]string{"host": "db-1"}}))
+	assert.False(t, s.IsSilenced(Alert{MType: "counter", Labels: map[string]string{"host": "web-1"}}))
+}
+
+func TestSilencer_Expiry(t *testing.T) {
+	s, err := NewSilencer(nil)
+	require.NoError(t, err)
+
+	now := time.Now()
+	s.now = func() time.Time { return now }
+
+	_, err = s.Add(Silence{
+		Matchers: []Matcher{{Name: "id", Value: "HeapAlloc"}},
+		StartsAt: now.Add(time.Minute),
+		EndsAt:   now.Add(time.Hour),
+	})
+	require.NoError(t, err)
+
+	alert := Alert{MetricID: "HeapAlloc"}
+	assert.False(t, s.IsSilenced(alert), "silence has not started yet")
+
+	now = now.Add(30 * time.Minute)
+	assert.True(t, s.IsSilenced(alert))
+	assert.Len(t, s.List(), 1)
+
+	now = now.Add(time.Hour)
+	assert.False(t, s.IsSilenced(alert))
+	assert.Empty(t, s.List())
+}
+
+func TestSilencer_AddValidation(t *testing.T) {
+	s, err := NewSilencer(nil)
+	require.NoError(t, err)
+
+	_, err = s.Add(Silence{EndsAt: time.Now().Add(time.Hour)})
+	assert.Error(t, err, "no matchers")
+
+	_, err = s.Add(Silence{Matchers: []Matcher{{Name: "id", Value: "("}}, EndsAt: time.Now().Add(time.Hour)})
+	assert.NoError(t, err, "plain values are not compiled")
+
+	_, err = s.Add(Silence{Matchers: []Matcher{{Name: "id", Value: "(", IsRegex: true}}, EndsAt: time.Now().Add(time.Hour)})
+	assert.Error(t, err, "invalid regex")
+
+	_, err = s.Add(Silence{Matchers: []Matcher{{Name: "id", Value: "x"}}, EndsAt: time.Now().Add(-time.Hour)})
+	assert.Error(t, err, "ends before it starts")
+}
+
+func TestMaintenanceWindow_AcrossMidnight(t *testing.T) {
+	s, err := NewSilencer([]MaintenanceWindow{{
+		Name:     "nightly deploy",
+		Matchers: []Matcher{{Name: "id", Value: "Heap.*", IsRegex: true}},
+		Weekdays: []string{"Mon"},
+		Start:    "23:00",
+		Duration: Duration{2 * time.Hour},
+	}})
+	require.NoError(t, err)
+
+	alert := Alert{MetricID: "HeapAlloc"}
+	// 2024-01-01 is a Monday
+	at := func(value string) {
+		now, err := time.Parse(time.RFC3339, value)
+		require.NoError(t, err)
+		s.now = func() time.Time { return now }
+	}
+
+	at("2024-01-01T22:59:00Z")
+	assert.False(t, s.IsSilenced(alert))
+	at("2024-01-01T23:30:00Z")
+	assert.True(t, s.IsSilenced(alert))
+	at("2024-01-02T00:30:00Z")
+	assert.True(t, s.IsSilenced(alert), "window started on Monday runs into Tuesday")
+	at("2024-01-02T01:00:00Z")
+	assert.False(t, s.IsSilenced(alert))
+	at("2024-01-02T23:30:00Z")
+	assert.False(t, s.IsSilenced(alert), "window only starts on Mondays")
+}
+
+func TestNewSilencer_InvalidWindow(t *testing.T) {
+	_, err := NewSilencer([]MaintenanceWindow{{
+		Matchers: []Matcher{{Name: "id", Value: "x"}},
+		Start:    "25:00",
+		Duration: Duration{time.Hour},
+	}})
+	assert.Error(t, err)
+}
+
+func TestSilencer_SnapshotRoundTrip(t *testing.T) {
+	s, err := NewSilencer(nil)
+	require.NoError(t, err)
+	created, err := s.Add(Silence{
+		Matchers: []Matcher{{Name: "id", Value: "Num.*", IsRegex: true}},
+		EndsAt:   time.Now().Add(time.Hour),
+		Comment:  "deploy",
+	})
+	require.NoError(t, err)
+
+	data, err := json.Marshal(s)
+	require.NoError(t, err)
+
+	restored, err := NewSilencer(nil)
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(data, restored))
+
+	list := restored.List()
+	require.Len(t, list, 1)
+	assert.Equal(t, created.ID, list[0].ID)
+	assert.True(t, restored.IsSilenced(Alert{MetricID: "NumGC"}), "regex is compiled again after restore")
+}
+
+func TestNotifier_SkipsSilencedAlerts(t *testing.T) {
+	_ = logger.New("test.log")
+
+	s, err := NewSilencer(nil)
+	require.NoError(t, err)
+	_, err = s.Add(Silence{Matchers: []Matcher{{Name: "id", Value: "HeapAlloc"}}, EndsAt: time.Now().Add(time.Hour)})
+	require.NoError(t, err)
+
+	n, err := New(testConfig(Route{Webhooks: []string{"http://localhost"}}), s)
+	require.NoError(t, err)
+
+	assert.ErrorIs(t, n.Notify(Alert{Status: StatusFiring, Rule: "r", MetricID: "HeapAlloc"}), ErrSilenced)
+	assert.Equal(t, 0, n.Pending())
+
+	require.NoError(t, n.Notify(Alert{Status: StatusFiring, Rule: "r", MetricID: "PollCount"}))
+	assert.Equal(t, 1, n.Pending())
+}
+
+func TestSilenceAPI(t *testing.T) {
+	s, err := NewSilencer(nil)
+	require.NoError(t, err)
+
+	r := chi.NewRouter()
+	r.Post("/api/silences", s.HandleCreate())
+	r.Get("/api/silences", s.HandleList())
+	r.Delete("/api/silences/{silenceID}", s.HandleDelete())
+
+	body := []byte(`{"matchers":[{"name":"id","value":"HeapAlloc|NumGC","regex":true}],"duration":"30m","comment":"deploy"}`)
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api/silences", bytes.NewReader(body)))
+	require.Equal(t, http.StatusCreated, rr.Code)
+
+	var created Silence
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &created))
+	assert.NotEmpty(t, created.ID)
+	assert.InDelta(t, 30*time.Minute, created.EndsAt.Sub(created.StartsAt), float64(time.Second))
+
+	rr = httptest.NewRecorder()
+	r.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/silences", nil))
+	require.Equal(t, http.StatusOK, rr.Code)
+	var list []Silence
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &list))
+	assert.Len(t, list, 1)
+
+	rr = httptest.NewRecorder()
+	r.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api/silences", bytes.NewReader([]byte(`{"matchers":[]}`))))
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	rr = httptest.NewRecorder()
+	r.ServeHTTP(rr, httptest.NewRequest(http.MethodDelete, "/api/silences/"+created.ID, nil))
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+
+	rr = httptest.NewRecorder()
+	r.ServeHTTP(rr, httptest.NewRequest(http.MethodDelete, "/api/silences/"+created.ID, nil))
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
@@ -0,0 +1,71 @@
+package notifier
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"Vova4o/metrix/internal/handlers"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// silenceRequest is the body of POST /api/silences.
+// Either ends_at or duration must be set
+type silenceRequest struct {
+	Silence
+	Duration Duration `json:"duration"`
+}
+
+// HandleCreate creates a silence from the JSON body
+func (s *Silencer) HandleCreate() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req silenceRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			handlers.WriteProblem(w, r, handlers.DecodeProblem(err))
+			return
+		}
+
+		silence := req.Silence
+		if silence.EndsAt.IsZero() && req.Duration.Duration > 0 {
+			start := silence.StartsAt
+			if start.IsZero() {
+				start = time.Now()
+			}
+			silence.StartsAt = start
+			silence.EndsAt = start.Add(req.Duration.Duration)
+		}
+
+		created, err := s.Add(silence)
+		if err != nil {
+			handlers.WriteProblem(w, r, handlers.NewProblem(http.StatusBadRequest, handlers.CodeInvalidValue, err.Error()))
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(created)
+	}
+}
+
+// HandleList returns the silences that have not expired yet
+func (s *Silencer) HandleList() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(s.List())
+	}
+}
+
+// HandleDelete removes the silence named by the {silenceID} URL parameter
+func (s *Silencer) HandleDelete() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := chi.URLParam(r, "silenceID")
+		if !s.Delete(id) {
+			handlers.WriteProblem(w, r, handlers.NewProblem(http.StatusNotFound, handlers.CodeNotFound,
+				fmt.Sprintf("silence %q not found", id)))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
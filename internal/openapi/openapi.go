@@ -0,0 +1,221 @@
+package openapi
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+//go:embed openapi.json
+var document []byte
+
+// Document is the part of an OpenAPI 3 document the validator needs
+type Document struct {
+	Paths      map[string]map[string]*Operation `json:"paths"`
+	Components struct {
+		Parameters map[string]*Parameter `json:"parameters"`
+		Schemas    map[string]*Schema    `json:"schemas"`
+	} `json:"components"`
+
+	routes []route
+}
+
+// Operation is one method of a path
+type Operation struct {
+	OperationID string       `json:"operationId"`
+	Parameters  []*Parameter `json:"parameters"`
+	RequestBody *RequestBody `json:"requestBody"`
+}
+
+// Parameter is a path or query parameter
+type Parameter struct {
+	Ref      string  `json:"$ref"`
+	Name     string  `json:"name"`
+	In       string  `json:"in"`
+	Required bool    `json:"required"`
+	Schema   *Schema `json:"schema"`
+}
+
+// RequestBody describes the accepted bodies by media type
+type RequestBody struct {
+	Required bool                 `json:"required"`
+	Content  map[string]MediaType `json:"content"`
+}
+
+// MediaType holds the schema of one body format
+type MediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+// Schema is the subset of JSON Schema used by the document
+type Schema struct {
+	Ref           string             `json:"$ref"`
+	Type          string             `json:"type"`
+	Enum          []interface{}      `json:"enum"`
+	Required      []string           `json:"required"`
+	Properties    map[string]*Schema `json:"properties"`
+	Items         *Schema            `json:"items"`
+	OneOf         []*Schema          `json:"oneOf"`
+	Discriminator *Discriminator     `json:"discriminator"`
+	Minimum       *float64           `json:"minimum"`
+	MinLength     *int               `json:"minLength"`
+	MinItems      *int               `json:"minItems"`
+
+	// ProblemCode is the error code reported when a value breaks the schema,
+	// e.g. unknown_type for metric types. Defaults to invalid_value
+	ProblemCode string `json:"x-problem-code"`
+}
+
+// Discriminator picks the oneOf branch by the value of a property
+type Discriminator struct {
+	PropertyName string            `json:"propertyName"`
+	Mapping      map[string]string `json:"mapping"`
+}
+
+// Route is a method and path template of the document, like "POST /update/"
+type Route struct {
+	Method string
+	Path   string
+}
+
+type route struct {
+	Route
+	segments []string
+	op       *Operation
+}
+
+// Load parses the embedded document and resolves its references
+func Load() (*Document, error) {
+	var d Document
+	if err := json.Unmarshal(document, &d); err != nil {
+		return nil, fmt.Errorf("failed to parse OpenAPI document: %w", err)
+	}
+
+	for path, methods := range d.Paths {
+		for method, op := range methods {
+			for i, p := range op.Parameters {
+				resolved, err := d.resolveParameter(p)
+				if err != nil {
+					return nil, fmt.Errorf("%s %s: %w", method, path, err)
+				}
+				op.Parameters[i] = resolved
+			}
+			d.routes = append(d.routes, route{
+				Route:    Route{Method: strings.ToUpper(method), Path: path},
+				segments: strings.Split(path, "/"),
+				op:       op,
+			})
+		}
+	}
+
+	// Check every schema reference once, so the validator can rely on them
+	for name, s := range d.Components.Schemas {
+		if err := d.checkRefs(s, map[*Schema]bool{}); err != nil {
+			return nil, fmt.Errorf("schema %s: %w", name, err)
+		}
+	}
+	for _, rt := range d.routes {
+		for _, p := range rt.op.Parameters {
+			if err := d.checkRefs(p.Schema, map[*Schema]bool{}); err != nil {
+				return nil, fmt.Errorf("%s %s parameter %s: %w", rt.Method, rt.Path, p.Name, err)
+			}
+		}
+		if rt.op.RequestBody != nil {
+			for mediaType, content := range rt.op.RequestBody.Content {
+				if err := d.checkRefs(content.Schema, map[*Schema]bool{}); err != nil {
+					return nil, fmt.Errorf("%s %s body %s: %w", rt.Method, rt.Path, mediaType, err)
+				}
+			}
+		}
+	}
+
+	sort.Slice(d.routes, func(i, j int) bool {
+		if d.routes[i].Path != d.routes[j].Path {
+			return d.routes[i].Path < d.routes[j].Path
+		}
+		return d.routes[i].Method < d.routes[j].Method
+	})
+	return &d, nil
+}
+
+// Routes lists every operation of the document, ordered by path and method
+func (d *Document) Routes() []Route {
+	result := make([]Route, len(d.routes))
+	for i, rt := range d.routes {
+		result[i] = rt.Route
+	}
+	return result
+}
+
+// Handler serves the document
+func (d *Document) Handler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(document)
+	}
+}
+
+func (d *Document) resolveParameter(p *Parameter) (*Parameter, error) {
+	if p.Ref == "" {
+		return p, nil
+	}
+	name, ok := strings.CutPrefix(p.Ref, "#/components/parameters/")
+	if !ok {
+		return nil, fmt.Errorf("unsupported reference %q", p.Ref)
+	}
+	resolved, ok := d.Components.Parameters[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown parameter %q", p.Ref)
+	}
+	return resolved, nil
+}
+
+// resolve follows $ref to the schema it names
+func (d *Document) resolve(s *Schema) *Schema {
+	for s != nil && s.Ref != "" {
+		name := strings.TrimPrefix(s.Ref, "#/components/schemas/")
+		s = d.Components.Schemas[name]
+	}
+	return s
+}
+
+func (d *Document) checkRefs(s *Schema, seen map[*Schema]bool) error {
+	if s == nil || seen[s] {
+		return nil
+	}
+	seen[s] = true
+
+	if s.Ref != "" {
+		name, ok := strings.CutPrefix(s.Ref, "#/components/schemas/")
+		if !ok {
+			return fmt.Errorf("unsupported reference %q", s.Ref)
+		}
+		target, ok := d.Components.Schemas[name]
+		if !ok {
+			return fmt.Errorf("unknown schema %q", s.Ref)
+		}
+		return d.checkRefs(target, seen)
+	}
+
+	for _, p := range s.Properties {
+		if err := d.checkRefs(p, seen); err != nil {
+			return err
+		}
+	}
+	for _, branch := range s.OneOf {
+		if err := d.checkRefs(branch, seen); err != nil {
+			return err
+		}
+	}
+	if s.Discriminator != nil {
+		for value, ref := range s.Discriminator.Mapping {
+			if err := d.checkRefs(&Schema{Ref: ref}, seen); err != nil {
+				return fmt.Errorf("discriminator %s: %w", value, err)
+			}
+		}
+	}
+	return d.checkRefs(s.Items, seen)
+}
@@ -0,0 +1,394 @@
+package openapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"Vova4o/metrix/internal/handlers"
+)
+
+// maxBodyBytes caps the request bodies the validator reads
+const maxBodyBytes = 10 << 20
+
+// violation is one way a request breaks the document
+type violation struct {
+	pointer   string // JSON pointer into the body
+	parameter string // or the name of a parameter
+	detail    string
+	code      string
+}
+
+// Validate is a middleware that rejects requests whose parameters or JSON
+// body break the document, before they reach the handlers. Requests to
+// paths the document does not describe are passed through
+func (d *Document) Validate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		op, params := d.match(r.Method, r.URL.Path)
+		if op == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		violations := d.validateParameters(op, params, r)
+
+		if body := op.RequestBody; body != nil && len(violations) == 0 {
+			if content, ok := body.Content["application/json"]; ok && isJSON(r) {
+				data, err := io.ReadAll(io.LimitReader(r.Body, maxBodyBytes+1))
+				if err != nil {
+					handlers.WriteProblem(w, r, handlers.NewProblem(http.StatusBadRequest, handlers.CodeInvalidBody,
+						"failed to read request body").WithCause(err))
+					return
+				}
+				if len(data) > maxBodyBytes {
+					handlers.WriteProblem(w, r, handlers.NewProblem(http.StatusRequestEntityTooLarge, handlers.CodeInvalidBody,
+						fmt.Sprintf("request body is larger than %d bytes", maxBodyBytes)))
+					return
+				}
+				r.Body = io.NopCloser(bytes.NewReader(data))
+
+				if len(bytes.TrimSpace(data)) == 0 {
+					if body.Required {
+						handlers.WriteProblem(w, r, handlers.NewProblem(http.StatusBadRequest, handlers.CodeInvalidBody,
+							"request body is required"))
+						return
+					}
+				} else {
+					dec := json.NewDecoder(bytes.NewReader(data))
+					dec.UseNumber()
+					var value interface{}
+					if err := dec.Decode(&value); err != nil {
+						handlers.WriteProblem(w, r, handlers.DecodeProblem(err))
+						return
+					}
+					violations = d.validate(content.Schema, value, "")
+				}
+			}
+		}
+
+		if len(violations) > 0 {
+			handlers.WriteProblem(w, r, problemFor(violations))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// isJSON reports whether the body is sent as JSON. Clients that
+// do not set a Content-Type are taken to send JSON too
+func isJSON(r *http.Request) bool {
+	contentType := r.Header.Get("Content-Type")
+	if contentType == "" {
+		return true
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && (mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"))
+}
+
+func problemFor(violations []violation) *handlers.Problem {
+	first := violations[0]
+	location := first.pointer
+	if first.parameter != "" {
+		location = "parameter " + first.parameter
+	}
+	if location == "" {
+		location = "body"
+	}
+
+	p := handlers.NewProblem(http.StatusBadRequest, first.code, location+" "+first.detail)
+	for _, v := range violations {
+		if v.parameter != "" {
+			p.AtParameter(v.parameter, v.detail)
+		} else {
+			p.AtPointer(v.pointer, v.detail)
+		}
+	}
+	return p
+}
+
+// match finds the operation of the request and the values of its path
+// parameters. Literal segments win over parameters
+func (d *Document) match(method, path string) (*Operation, map[string]string) {
+	segments := strings.Split(path, "/")
+
+	var best *route
+	bestLiterals := -1
+	for i := range d.routes {
+		rt := &d.routes[i]
+		if rt.Method != method || len(rt.segments) != len(segments) {
+			continue
+		}
+		literals, ok := 0, true
+		for j, seg := range rt.segments {
+			if isParam(seg) {
+				if segments[j] == "" {
+					ok = false
+					break
+				}
+				continue
+			}
+			if seg != segments[j] {
+				ok = false
+				break
+			}
+			literals++
+		}
+		if ok && literals > bestLiterals {
+			best, bestLiterals = rt, literals
+		}
+	}
+	if best == nil {
+		return nil, nil
+	}
+
+	params := make(map[string]string)
+	for j, seg := range best.segments {
+		if isParam(seg) {
+			params[seg[1:len(seg)-1]] = segments[j]
+		}
+	}
+	return best.op, params
+}
+
+func isParam(segment string) bool {
+	return strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}")
+}
+
+func (d *Document) validateParameters(op *Operation, pathParams map[string]string, r *http.Request) []violation {
+	var violations []violation
+	query := r.URL.Query()
+
+	for _, p := range op.Parameters {
+		var raw string
+		var present bool
+		switch p.In {
+		case "path":
+			raw, present = pathParams[p.Name]
+		case "query":
+			present = query.Has(p.Name)
+			raw = query.Get(p.Name)
+		default:
+			continue
+		}
+
+		if !present {
+			if p.Required {
+				violations = append(violations, violation{parameter: p.Name, detail: "is required", code: handlers.CodeInvalidValue})
+			}
+			continue
+		}
+
+		for _, v := range d.validate(p.Schema, d.coerce(p.Schema, raw), "") {
+			v.parameter = p.Name
+			violations = append(violations, v)
+		}
+	}
+	return violations
+}
+
+// coerce turns a parameter into the JSON value its schema expects
+func (d *Document) coerce(s *Schema, raw string) interface{} {
+	s = d.resolve(s)
+	if s == nil {
+		return raw
+	}
+	switch s.Type {
+	case "integer", "number":
+		if _, err := strconv.ParseFloat(raw, 64); err == nil {
+			return json.Number(raw)
+		}
+	case "boolean":
+		if b, err := strconv.ParseBool(raw); err == nil {
+			return b
+		}
+	}
+	return raw
+}
+
+// validate checks a value decoded with UseNumber against the schema
+func (d *Document) validate(s *Schema, value interface{}, pointer string) []violation {
+	s = d.resolve(s)
+	if s == nil {
+		return nil
+	}
+	fail := func(format string, args ...interface{}) []violation {
+		code := s.ProblemCode
+		if code == "" {
+			code = handlers.CodeInvalidValue
+		}
+		return []violation{{pointer: pointer, detail: fmt.Sprintf(format, args...), code: code}}
+	}
+
+	if len(s.OneOf) > 0 {
+		return d.validateOneOf(s, value, pointer)
+	}
+
+	if s.Type != "" && !hasType(value, s.Type) {
+		return fail("must be %s", article(s.Type))
+	}
+
+	if len(s.Enum) > 0 {
+		allowed := make([]string, len(s.Enum))
+		found := false
+		for i, e := range s.Enum {
+			allowed[i] = fmt.Sprint(e)
+			if fmt.Sprint(e) == fmt.Sprint(value) {
+				found = true
+			}
+		}
+		if !found {
+			return fail("must be one of %s", strings.Join(allowed, ", "))
+		}
+	}
+
+	switch v := value.(type) {
+	case string:
+		if s.MinLength != nil && utf8.RuneCountInString(v) < *s.MinLength {
+			if *s.MinLength == 1 {
+				return fail("must not be empty")
+			}
+			return fail("must be at least %d characters long", *s.MinLength)
+		}
+
+	case json.Number:
+		if s.Minimum != nil {
+			if f, err := v.Float64(); err == nil && f < *s.Minimum {
+				return fail("must be at least %v", *s.Minimum)
+			}
+		}
+
+	case []interface{}:
+		if s.MinItems != nil && len(v) < *s.MinItems {
+			return fail("must have at least %d item(s)", *s.MinItems)
+		}
+		var violations []violation
+		for i, item := range v {
+			violations = append(violations, d.validate(s.Items, item, pointer+"/"+strconv.Itoa(i))...)
+		}
+		return violations
+
+	case map[string]interface{}:
+		var violations []violation
+		for _, name := range s.Required {
+			if _, ok := v[name]; !ok {
+				violations = append(violations, violation{
+					pointer: pointer + "/" + escapePointer(name),
+					detail:  "is required",
+					code:    handlers.CodeInvalidValue,
+				})
+			}
+		}
+		names := make([]string, 0, len(s.Properties))
+		for name := range s.Properties {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if prop, ok := v[name]; ok {
+				violations = append(violations, d.validate(s.Properties[name], prop, pointer+"/"+escapePointer(name))...)
+			}
+		}
+		return violations
+	}
+
+	return nil
+}
+
+// validateOneOf picks the branch by the discriminator when there is one,
+// so the errors describe that branch instead of every possible shape
+func (d *Document) validateOneOf(s *Schema, value interface{}, pointer string) []violation {
+	if disc := s.Discriminator; disc != nil {
+		obj, ok := value.(map[string]interface{})
+		if !ok {
+			return []violation{{pointer: pointer, detail: "must be an object", code: handlers.CodeInvalidValue}}
+		}
+		propPointer := pointer + "/" + escapePointer(disc.PropertyName)
+		raw, ok := obj[disc.PropertyName]
+		if !ok {
+			return []violation{{pointer: propPointer, detail: "is required", code: handlers.CodeInvalidValue}}
+		}
+
+		key, _ := raw.(string)
+		ref, ok := disc.Mapping[key]
+		if !ok {
+			allowed := make([]string, 0, len(disc.Mapping))
+			for k := range disc.Mapping {
+				allowed = append(allowed, k)
+			}
+			sort.Strings(allowed)
+			return []violation{{
+				pointer: propPointer,
+				detail:  "must be one of " + strings.Join(allowed, ", "),
+				code:    d.discriminatorCode(s),
+			}}
+		}
+		return d.validate(&Schema{Ref: ref}, value, pointer)
+	}
+
+	for _, branch := range s.OneOf {
+		if len(d.validate(branch, value, pointer)) == 0 {
+			return nil
+		}
+	}
+	return []violation{{pointer: pointer, detail: "does not match any of the allowed shapes", code: handlers.CodeInvalidValue}}
+}
+
+// discriminatorCode is the problem code of the discriminating property
+func (d *Document) discriminatorCode(s *Schema) string {
+	for _, branch := range s.OneOf {
+		if b := d.resolve(branch); b != nil {
+			if prop := d.resolve(b.Properties[s.Discriminator.PropertyName]); prop != nil && prop.ProblemCode != "" {
+				return prop.ProblemCode
+			}
+		}
+	}
+	return handlers.CodeInvalidValue
+}
+
+func hasType(value interface{}, typ string) bool {
+	switch typ {
+	case "object":
+		_, ok := value.(map[string]interface{})
+		return ok
+	case "array":
+		_, ok := value.([]interface{})
+		return ok
+	case "string":
+		_, ok := value.(string)
+		return ok
+	case "boolean":
+		_, ok := value.(bool)
+		return ok
+	case "number":
+		_, ok := value.(json.Number)
+		return ok
+	case "integer":
+		n, ok := value.(json.Number)
+		if !ok {
+			return false
+		}
+		_, err := strconv.ParseInt(n.String(), 10, 64)
+		return err == nil
+	}
+	return true
+}
+
+func article(typ string) string {
+	switch typ {
+	case "object", "array", "integer":
+		return "an " + typ
+	}
+	return "a " + typ
+}
+
+// escapePointer escapes a property name for a JSON pointer (RFC 6901)
+func escapePointer(name string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(name)
+}
@@ -0,0 +1,97 @@
+package openapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"Vova4o/metrix/internal/handlers"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoad(t *testing.T) {
+	d, err := Load()
+	require.NoError(t, err)
+	assert.Contains(t, d.Routes(), Route{Method: http.MethodPost, Path: "/update/"})
+	assert.Contains(t, d.Routes(), Route{Method: http.MethodGet, Path: "/openapi.json"})
+}
+
+func TestValidate(t *testing.T) {
+	d, err := Load()
+	require.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		method  string
+		target  string
+		body    string
+		status  int
+		code    string
+		pointer string
+		param   string
+	}{
+		{"valid gauge", http.MethodPost, "/update/", `{"id": "Alloc", "type": "gauge", "value": 1.5}`, http.StatusOK, "", "", ""},
+		{"valid counter", http.MethodPost, "/update/", `{"id": "PollCount", "type": "counter", "delta": 3}`, http.StatusOK, "", "", ""},
+		{"missing id", http.MethodPost, "/update/", `{"type": "gauge", "value": 1}`, http.StatusBadRequest, handlers.CodeInvalidValue, "/id", ""},
+		{"unknown type", http.MethodPost, "/update/", `{"id": "a", "type": "histogram"}`, http.StatusBadRequest, handlers.CodeUnknownType, "/type", ""},
+		{"gauge without value", http.MethodPost, "/update/", `{"id": "a", "type": "gauge"}`, http.StatusBadRequest, handlers.CodeInvalidValue, "/value", ""},
+		{"fractional delta", http.MethodPost, "/update/", `{"id": "a", "type": "counter", "delta": 1.5}`, http.StatusBadRequest, handlers.CodeInvalidValue, "/delta", ""},
+		{"malformed body", http.MethodPost, "/update/", `{"id": `, http.StatusBadRequest, handlers.CodeInvalidBody, "", ""},
+		{"empty body", http.MethodPost, "/value/", ``, http.StatusBadRequest, handlers.CodeInvalidBody, "", ""},
+		{"unknown path type", http.MethodGet, "/value/histogram/a", ``, http.StatusBadRequest, handlers.CodeUnknownType, "", "metricType"},
+		{"bad limit", http.MethodGet, "/api/v1/metrics?limit=0", ``, http.StatusBadRequest, handlers.CodeInvalidValue, "", "limit"},
+		{"missing expr", http.MethodGet, "/api/query", ``, http.StatusBadRequest, handlers.CodeInvalidValue, "", "expr"},
+		{"unknown path", http.MethodGet, "/nowhere", ``, http.StatusOK, "", "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reached := false
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				reached = true
+			})
+
+			req := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body))
+			rr := httptest.NewRecorder()
+			d.Validate(next).ServeHTTP(rr, req)
+
+			assert.Equal(t, tt.status, rr.Code)
+			if tt.code == "" {
+				assert.True(t, reached, "request did not reach the handler")
+				return
+			}
+			assert.False(t, reached, "invalid request reached the handler")
+			assert.Equal(t, handlers.ProblemContentType, rr.Header().Get("Content-Type"))
+
+			var p handlers.Problem
+			require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &p))
+			assert.Equal(t, tt.code, p.Code)
+			if tt.pointer != "" || tt.param != "" {
+				require.NotEmpty(t, p.Errors)
+				assert.Equal(t, tt.pointer, p.Errors[0].Pointer)
+				assert.Equal(t, tt.param, p.Errors[0].Parameter)
+			}
+		})
+	}
+}
+
+func TestValidateKeepsBody(t *testing.T) {
+	d, err := Load()
+	require.NoError(t, err)
+
+	body := `{"id": "Alloc", "type": "gauge", "value": 1.5}`
+	var got string
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var m handlers.MetricsJSON
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&m))
+		got = m.ID
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/update/", strings.NewReader(body))
+	d.Validate(next).ServeHTTP(httptest.NewRecorder(), req)
+	assert.Equal(t, "Alloc", got)
+}
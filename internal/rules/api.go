@@ -0,0 +1,14 @@
+package rules
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// HandleList returns the status of every recording rule
+func (m *Manager) HandleList() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(m.Statuses())
+	}
+}
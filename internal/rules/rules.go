@@ -0,0 +1,305 @@
+package rules
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"Vova4o/metrix/internal/expr"
+	"Vova4o/metrix/internal/handlers"
+	"Vova4o/metrix/internal/logger"
+	"Vova4o/metrix/internal/notifier"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Source is recorded as the source of the gauges written by rules
+const Source = "recording-rule"
+
+// Rule stores the result of an expression as a gauge
+type Rule struct {
+	Record string `json:"record"` // имя гауджа для результата
+	Expr   string `json:"expr"`
+}
+
+// Config lists the recording rules and how often they are evaluated
+type Config struct {
+	Interval notifier.Duration `json:"interval"`
+	Rules    []Rule            `json:"rules"`
+}
+
+// Status is the outcome of the last evaluation of a rule
+type Status struct {
+	Record        string    `json:"record"`
+	Expr          string    `json:"expr"`
+	LastEval      time.Time `json:"last_eval"`
+	LastValue     *float64  `json:"last_value,omitempty"`
+	LastError     string    `json:"last_error,omitempty"`
+	Failures      int       `json:"failures"` // ошибок подряд
+	EvalDurationS float64   `json:"eval_duration_seconds"`
+}
+
+// LoadConfig reads the recording rules from a JSON file
+func LoadConfig(path string) (Config, error) {
+	var cfg Config
+
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, fmt.Errorf("failed to read rules config %s: %w", path, err)
+	}
+	if err := json.Unmarshal(contents, &cfg); err != nil {
+		return cfg, fmt.Errorf("failed to parse rules config %s: %w", path, err)
+	}
+
+	if cfg.Interval.Duration <= 0 {
+		cfg.Interval.Duration = 15 * time.Second
+	}
+	return cfg, nil
+}
+
+type compiledRule struct {
+	Rule
+	node expr.Node
+}
+
+// Manager evaluates recording rules and writes their results to the storage
+type Manager struct {
+	storage  handlers.Storager
+	history  handlers.Historian
+	interval time.Duration
+	rules    []compiledRule // в порядке зависимостей
+
+	mu     sync.Mutex
+	status map[string]*Status
+}
+
+// New parses the rules and orders them so that a rule runs after the rules
+// it reads. It fails on invalid expressions, duplicate records and loops
+func New(cfg Config, s handlers.Storager, h handlers.Historian) (*Manager, error) {
+	m := &Manager{
+		storage:  s,
+		history:  h,
+		interval: cfg.Interval.Duration,
+		status:   make(map[string]*Status),
+	}
+
+	compiled := make([]compiledRule, 0, len(cfg.Rules))
+	for i, rule := range cfg.Rules {
+		if !validRecord(rule.Record) {
+			return nil, fmt.Errorf("rule %d: invalid record name %q", i, rule.Record)
+		}
+		if _, dup := m.status[rule.Record]; dup {
+			return nil, fmt.Errorf("rule %d: record %q is defined more than once", i, rule.Record)
+		}
+		node, err := expr.Parse(rule.Expr)
+		if err != nil {
+			return nil, fmt.Errorf("rule %q: %w", rule.Record, err)
+		}
+		compiled = append(compiled, compiledRule{Rule: rule, node: node})
+		m.status[rule.Record] = &Status{Record: rule.Record, Expr: node.String()}
+	}
+
+	ordered, err := order(compiled)
+	if err != nil {
+		return nil, err
+	}
+	m.rules = ordered
+	return m, nil
+}
+
+// Run evaluates the rules on every tick until the context is done
+func (m *Manager) Run(ctx context.Context) {
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+
+	m.EvalAll(time.Now())
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			m.EvalAll(now)
+		}
+	}
+}
+
+// EvalAll evaluates every rule once. A failing rule does not stop the others
+func (m *Manager) EvalAll(now time.Time) {
+	for _, rule := range m.rules {
+		start := time.Now()
+		value, err := m.eval(rule, now)
+
+		m.mu.Lock()
+		st := m.status[rule.Record]
+		st.LastEval = now
+		st.EvalDurationS = time.Since(start).Seconds()
+		if err != nil {
+			st.LastError = err.Error()
+			st.Failures++
+		} else {
+			st.LastError = ""
+			st.Failures = 0
+			st.LastValue = &value
+		}
+		m.mu.Unlock()
+
+		if err != nil {
+			logger.Log.WithFields(logrus.Fields{
+				"record": rule.Record,
+				"expr":   rule.Expr,
+			}).WithError(err).Warn("Recording rule failed")
+			continue
+		}
+
+		m.storage.SetGaugeWithSource(rule.Record, value, Source)
+	}
+}
+
+// errNoData is reported when the expression selects no metrics yet
+var errNoData = errors.New("expression returned no data")
+
+func (m *Manager) eval(rule compiledRule, now time.Time) (float64, error) {
+	result, err := expr.Eval(rule.node, expr.Env{Storage: m.storage, History: m.history, Now: now})
+	if err != nil {
+		return 0, err
+	}
+
+	var value float64
+	switch v := result.(type) {
+	case expr.Scalar:
+		value = float64(v)
+	case expr.Vector:
+		switch len(v) {
+		case 0:
+			return 0, errNoData
+		case 1:
+			value = float64(v[0].Value)
+		default:
+			return 0, fmt.Errorf("expression returned %d metrics, aggregate them into one", len(v))
+		}
+	}
+
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return 0, fmt.Errorf("expression returned %v", value)
+	}
+	return value, nil
+}
+
+// Statuses returns the outcome of the last evaluation of every rule,
+// in evaluation order
+func (m *Manager) Statuses() []Status {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	result := make([]Status, 0, len(m.rules))
+	for _, rule := range m.rules {
+		result = append(result, *m.status[rule.Record])
+	}
+	return result
+}
+
+// order sorts the rules so that every rule comes after the rules whose
+// records it reads, and reports a loop if there is no such order
+func order(rules []compiledRule) ([]compiledRule, error) {
+	deps := make(map[string][]string, len(rules))
+	for _, r := range rules {
+		for _, other := range rules {
+			if reads(r.node, other.Record) {
+				deps[r.Record] = append(deps[r.Record], other.Record)
+			}
+		}
+	}
+
+	byRecord := make(map[string]compiledRule, len(rules))
+	for _, r := range rules {
+		byRecord[r.Record] = r
+	}
+
+	const (
+		unvisited = iota
+		visiting
+		done
+	)
+	state := make(map[string]int, len(rules))
+	var ordered []compiledRule
+	var path []string
+
+	var visit func(record string) error
+	visit = func(record string) error {
+		switch state[record] {
+		case done:
+			return nil
+		case visiting:
+			start := 0
+			for i, r := range path {
+				if r == record {
+					start = i
+				}
+			}
+			loop := append(append([]string{}, path[start:]...), record)
+			return fmt.Errorf("recording rules form a loop: %s", strings.Join(loop, " -> "))
+		}
+
+		state[record] = visiting
+		path = append(path, record)
+		for _, dep := range deps[record] {
+			if err := visit(dep); err != nil {
+				return err
+			}
+		}
+		path = path[:len(path)-1]
+		state[record] = done
+		ordered = append(ordered, byRecord[record])
+		return nil
+	}
+
+	for _, r := range rules {
+		if err := visit(r.Record); err != nil {
+			return nil, err
+		}
+	}
+	return ordered, nil
+}
+
+// reads reports whether any selector of the expression
+// can match the gauge written under the record name
+func reads(node expr.Node, record string) bool {
+	m := handlers.MetricsJSON{ID: record, MType: "gauge"}
+	labels := handlers.MetricLabels(m, handlers.MetricMeta{Source: Source})
+
+	for _, sel := range expr.Selectors(node) {
+		if sel.Name != "" && sel.Name != record {
+			continue
+		}
+		matched := true
+		for _, matcher := range sel.Matchers {
+			if !matcher.Match(labels) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+// validRecord checks that the record can be used as a metric name in expressions
+func validRecord(name string) bool {
+	if name == "" {
+		return false
+	}
+	node, err := expr.Parse(name)
+	if err != nil {
+		return false
+	}
+	sel, ok := node.(*expr.Selector)
+	return ok && sel.Name == name && len(sel.Matchers) == 0
+}
@@ -0,0 +1,142 @@
+package rules
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"Vova4o/metrix/internal/logger"
+	"Vova4o/metrix/internal/notifier"
+	"Vova4o/metrix/internal/storage"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newConfig(rules ...Rule) Config {
+	return Config{Interval: notifier.Duration{Duration: time.Second}, Rules: rules}
+}
+
+func TestLoadConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{
+		"rules": [{"record": "HeapUtilization", "expr": "HeapInuse / HeapSys"}]
+	}`), 0o644))
+
+	cfg, err := LoadConfig(path)
+	require.NoError(t, err)
+	assert.Equal(t, 15*time.Second, cfg.Interval.Duration)
+	require.Len(t, cfg.Rules, 1)
+	assert.Equal(t, "HeapUtilization", cfg.Rules[0].Record)
+
+	_, err = LoadConfig(filepath.Join(t.TempDir(), "missing.json"))
+	assert.Error(t, err)
+}
+
+func TestNew_Errors(t *testing.T) {
+	tests := []struct {
+		name    string
+		rules   []Rule
+		message string
+	}{
+		{"invalid record", []Rule{{Record: "heap ratio", Expr: "1"}}, "invalid record name"},
+		{"duplicate", []Rule{{Record: "A", Expr: "1"}, {Record: "A", Expr: "2"}}, "more than once"},
+		{"parse error", []Rule{{Record: "A", Expr: "sum("}}, "parse error"},
+		{"self loop", []Rule{{Record: "A", Expr: "A + 1"}}, "loop: A -> A"},
+		{"loop", []Rule{
+			{Record: "A", Expr: "B * 2"},
+			{Record: "B", Expr: "C + 1"},
+			{Record: "C", Expr: "sum(A)"},
+		}, "loop: A -> B -> C -> A"},
+		{"loop through labels", []Rule{{Record: "GaugeCount", Expr: `count({type="gauge"})`}}, "loop"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := New(newConfig(tt.rules...), storage.NewMemStorage(), nil)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.message)
+		})
+	}
+
+	// Excluding the recorded gauges breaks the loop
+	_, err := New(newConfig(Rule{Record: "GaugeCount", Expr: `count({type="gauge", source!="recording-rule"})`}),
+		storage.NewMemStorage(), nil)
+	assert.NoError(t, err)
+}
+
+func TestManager_EvalAll(t *testing.T) {
+	_ = logger.New("test.log")
+
+	s := storage.NewMemStorage()
+	s.SetGauge("HeapInuse", 30)
+	s.SetGauge("HeapSys", 120)
+
+	// The dependent rule is listed first and still sees a fresh value
+	m, err := New(newConfig(
+		Rule{Record: "HeapUtilizationPercent", Expr: "HeapUtilization * 100"},
+		Rule{Record: "HeapUtilization", Expr: "HeapInuse / HeapSys"},
+		Rule{Record: "Broken", Expr: "Missing / 2"},
+		Rule{Record: "Many", Expr: "{host=\"\"}"},
+	), s, nil)
+	require.NoError(t, err)
+
+	before, _ := s.Version()
+	m.EvalAll(time.Now())
+
+	// Each recorded value is stored with its source as one change
+	after, _ := s.Version()
+	assert.Equal(t, before+2, after)
+
+	value, ok := s.GetGauge("HeapUtilization")
+	require.True(t, ok)
+	assert.Equal(t, 0.25, value)
+	value, ok = s.GetGauge("HeapUtilizationPercent")
+	require.True(t, ok)
+	assert.Equal(t, 25.0, value)
+
+	meta, ok := s.GetMeta("gauge", "HeapUtilization")
+	require.True(t, ok)
+	assert.Equal(t, Source, meta.Source)
+
+	_, ok = s.GetGauge("Broken")
+	assert.False(t, ok)
+
+	statuses := make(map[string]Status)
+	for _, st := range m.Statuses() {
+		statuses[st.Record] = st
+	}
+	assert.Empty(t, statuses["HeapUtilization"].LastError)
+	assert.Equal(t, 0.25, *statuses["HeapUtilization"].LastValue)
+	assert.Equal(t, errNoData.Error(), statuses["Broken"].LastError)
+	assert.Equal(t, 1, statuses["Broken"].Failures)
+	assert.Contains(t, statuses["Many"].LastError, "aggregate them")
+
+	m.EvalAll(time.Now())
+	for _, st := range m.Statuses() {
+		if st.Record == "Broken" {
+			assert.Equal(t, 2, st.Failures)
+		}
+	}
+}
+
+func TestManager_HandleList(t *testing.T) {
+	s := storage.NewMemStorage()
+	m, err := New(newConfig(Rule{Record: "Two", Expr: "1 + 1"}), s, nil)
+	require.NoError(t, err)
+	m.EvalAll(time.Now())
+
+	rr := httptest.NewRecorder()
+	m.HandleList().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/rules", nil))
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	var statuses []Status
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &statuses))
+	require.Len(t, statuses, 1)
+	assert.Equal(t, "Two", statuses[0].Record)
+	assert.Equal(t, 2.0, *statuses[0].LastValue)
+}
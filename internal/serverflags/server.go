@@ -5,6 +5,8 @@ import (
 	"os"
 	"strings"
 
+	"Vova4o/metrix/internal/flagargs"
+
 	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
 )
@@ -17,15 +19,53 @@ func init() {
 	flags.IntP("StoreInterval", "i", 300, "Interval in seconds to store the current server readings to disk")
 	flags.StringP("FileStoragePath", "f", "/tmp/metrics-db.json", "Full filename where current values are saved")
 	flags.BoolP("Restore", "r", true, "Whether to load previously saved values from the specified file at server startup")
+	flags.IntP("HistoryRetention", "t", 86400, "How long in seconds to keep metric history for the detail page charts")
+	flags.StringP("NotifierConfig", "n", "", "Path to the JSON file with alert notification routes and webhooks")
+	flags.String("RulesConfig", "", "Path to the JSON file with recording rules")
+	flags.String("TokensConfig", "", "Path to the JSON file with the hashed API tokens, the API is open without it")
+	flags.String("TenantsConfig", "", "Path to the JSON file with the tenants and their metric quotas")
+	flags.String("AuditFile", "", "Path to the JSON lines file the audit events of metric updates are appended to")
+	flags.Int("AuditFileMaxSize", 100, "Size in megabytes at which the audit file is rotated, 0 to never rotate")
+	flags.Int("AuditFileMaxBackups", 5, "Number of rotated audit files to keep")
+	flags.String("AuditURL", "", "URL the audit events of metric updates are posted to in JSON batches")
+	flags.Int("ShutdownTimeout", 30, "Seconds to let requests in flight finish on shutdown")
+	flags.Int("CompressionLevel", 0, "Response compression level from 1 (fastest) to 9 (smallest), 0 for the codec default")
+	flags.Int("CompressionMinSize", 1024, "Smallest response body in bytes that is compressed")
+	flags.StringP("Key", "k", "", "Key of the HMAC-SHA256 signatures of requests and responses")
+	flags.String("crypto-key", "", "Path to the PEM file with the RSA private key that decrypts agent payloads")
+	flags.String("TLSCert", "", "Path to the PEM certificate to serve HTTPS with")
+	flags.String("TLSKey", "", "Path to the PEM private key of the TLS certificate")
+	flags.String("TLSClientCA", "", "Path to the PEM bundle of CAs agents' client certificates must be signed by (mutual TLS)")
+	flags.String("trusted_subnet", "", "CIDR of the agents, changes from other X-Real-IP addresses are rejected")
+	flags.Bool("trusted_subnet_reads", false, "Restrict the read-only routes to the trusted subnet as well")
 
 	// Parse the command-line flags
-	flags.Parse(os.Args[1:])
+	flags.Parse(flagargs.Normalize(flags, os.Args[1:]))
 
 	// Bind the flags to viper
 	bindFlagToViper("ServerAddress")
 	bindFlagToViper("StoreInterval")
 	bindFlagToViper("FileStoragePath")
 	bindFlagToViper("Restore")
+	bindFlagToViper("HistoryRetention")
+	bindFlagToViper("NotifierConfig")
+	bindFlagToViper("RulesConfig")
+	bindFlagToViper("TokensConfig")
+	bindFlagToViper("TenantsConfig")
+	bindFlagToViper("AuditFile")
+	bindFlagToViper("AuditFileMaxSize")
+	bindFlagToViper("AuditFileMaxBackups")
+	bindFlagToViper("AuditURL")
+	bindFlagToViper("ShutdownTimeout")
+	bindFlagToViper("CompressionLevel")
+	bindFlagToViper("CompressionMinSize")
+	bindFlagToViper("Key")
+	bindFlagToViper("crypto-key")
+	bindFlagToViper("TLSCert")
+	bindFlagToViper("TLSKey")
+	bindFlagToViper("TLSClientCA")
+	bindFlagToViper("trusted_subnet")
+	bindFlagToViper("trusted_subnet_reads")
 
 	// Set the environment variable names
 	viper.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
@@ -33,6 +73,25 @@ func init() {
 	bindEnvToViper("StoreInterval", "STORE_INTERVAL")
 	bindEnvToViper("FileStoragePath", "FILE_STORAGE_PATH")
 	bindEnvToViper("Restore", "RESTORE")
+	bindEnvToViper("HistoryRetention", "HISTORY_RETENTION")
+	bindEnvToViper("NotifierConfig", "NOTIFIER_CONFIG")
+	bindEnvToViper("RulesConfig", "RULES_CONFIG")
+	bindEnvToViper("TokensConfig", "TOKENS_CONFIG")
+	bindEnvToViper("TenantsConfig", "TENANTS_CONFIG")
+	bindEnvToViper("AuditFile", "AUDIT_FILE")
+	bindEnvToViper("AuditFileMaxSize", "AUDIT_FILE_MAX_SIZE")
+	bindEnvToViper("AuditFileMaxBackups", "AUDIT_FILE_MAX_BACKUPS")
+	bindEnvToViper("AuditURL", "AUDIT_URL")
+	bindEnvToViper("ShutdownTimeout", "SHUTDOWN_TIMEOUT")
+	bindEnvToViper("CompressionLevel", "COMPRESSION_LEVEL")
+	bindEnvToViper("CompressionMinSize", "COMPRESSION_MIN_SIZE")
+	bindEnvToViper("Key", "KEY")
+	bindEnvToViper("crypto-key", "CRYPTO_KEY")
+	bindEnvToViper("TLSCert", "TLS_CERT")
+	bindEnvToViper("TLSKey", "TLS_KEY")
+	bindEnvToViper("TLSClientCA", "TLS_CLIENT_CA")
+	bindEnvToViper("trusted_subnet", "TRUSTED_SUBNET")
+	bindEnvToViper("trusted_subnet_reads", "TRUSTED_SUBNET_READS")
 
 	// Read the environment variables
 	viper.AutomaticEnv()
@@ -68,3 +127,83 @@ func GetFileStoragePath() string {
 func GetRestore() bool {
 	return viper.GetBool("Restore")
 }
+
+func GetHistoryRetention() int {
+	return viper.GetInt("HistoryRetention")
+}
+
+func GetNotifierConfig() string {
+	return viper.GetString("NotifierConfig")
+}
+
+func GetRulesConfig() string {
+	return viper.GetString("RulesConfig")
+}
+
+func GetTokensConfig() string {
+	return viper.GetString("TokensConfig")
+}
+
+func GetTenantsConfig() string {
+	return viper.GetString("TenantsConfig")
+}
+
+func GetAuditFile() string {
+	return viper.GetString("AuditFile")
+}
+
+func GetAuditFileMaxSize() int {
+	return viper.GetInt("AuditFileMaxSize")
+}
+
+func GetAuditFileMaxBackups() int {
+	return viper.GetInt("AuditFileMaxBackups")
+}
+
+func GetAuditURL() string {
+	return viper.GetString("AuditURL")
+}
+
+func GetShutdownTimeout() int {
+	timeout := viper.GetInt("ShutdownTimeout")
+	if timeout <= 0 {
+		timeout = 30
+	}
+	return timeout
+}
+
+func GetCompressionLevel() int {
+	return viper.GetInt("CompressionLevel")
+}
+
+func GetCompressionMinSize() int {
+	return viper.GetInt("CompressionMinSize")
+}
+
+func GetKey() string {
+	return viper.GetString("Key")
+}
+
+func GetCryptoKey() string {
+	return viper.GetString("crypto-key")
+}
+
+func GetTLSCert() string {
+	return viper.GetString("TLSCert")
+}
+
+func GetTLSKey() string {
+	return viper.GetString("TLSKey")
+}
+
+func GetTLSClientCA() string {
+	return viper.GetString("TLSClientCA")
+}
+
+func GetTrustedSubnet() string {
+	return viper.GetString("trusted_subnet")
+}
+
+func GetTrustedSubnetReads() bool {
+	return viper.GetBool("trusted_subnet_reads")
+}
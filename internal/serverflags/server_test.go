@@ -3,6 +3,8 @@ package serverflags
 import (
 	"os"
 	"testing"
+
+	"github.com/spf13/viper"
 )
 
 func TestParseFlags(t *testing.T) {
@@ -38,3 +40,14 @@ func TestParseFlags(t *testing.T) {
 	os.Unsetenv("FILE_STORAGE_PATH")
 	os.Unsetenv("RESTORE")
 }
+
+func TestGetShutdownTimeout(t *testing.T) {
+	defer viper.Set("ShutdownTimeout", nil)
+
+	for value, want := range map[int]int{10: 10, 0: 30, -1: 30} {
+		viper.Set("ShutdownTimeout", value)
+		if got := GetShutdownTimeout(); got != want {
+			t.Errorf("ShutdownTimeout %v: expected %v, got %v", value, want, got)
+		}
+	}
+}
@@ -0,0 +1,31 @@
+// Package signature signs the bodies the agent and the server exchange
+// with HMAC-SHA256 over a shared key
+package signature
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// Header carries the hex encoded signature of a request or response body
+const Header = "HashSHA256"
+
+// Sum returns the hex encoded HMAC-SHA256 of data
+func Sum(key, data []byte) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Valid reports whether sum is the signature of data. The comparison takes
+// the same time wherever the signatures differ
+func Valid(key, data []byte, sum string) bool {
+	got, err := hex.DecodeString(sum)
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+	return hmac.Equal(got, mac.Sum(nil))
+}
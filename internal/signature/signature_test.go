@@ -0,0 +1,27 @@
+package signature
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSum(t *testing.T) {
+	// RFC 4231, test case 2
+	got := Sum([]byte("Jefe"), []byte("what do ya want for nothing?"))
+	assert.Equal(t, "5bdcc146bf60754e6a042426089575c75a003f089d2739839dec58b964ec3843", got)
+}
+
+func TestValid(t *testing.T) {
+	key := []byte("secret")
+	body := []byte(`{"id":"Alloc","type":"gauge","value":1.5}`)
+	sum := Sum(key, body)
+
+	assert.True(t, Valid(key, body, sum))
+	assert.True(t, Valid(key, body, strings.ToUpper(sum)))
+	assert.False(t, Valid([]byte("other"), body, sum))
+	assert.False(t, Valid(key, []byte(`{}`), sum))
+	assert.False(t, Valid(key, body, ""))
+	assert.False(t, Valid(key, body, "not hex"))
+}
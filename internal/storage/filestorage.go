@@ -1,9 +1,12 @@
 package storage
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"os"
+	"path/filepath"
+	"sync"
 	"time"
 
 	"Vova4o/metrix/internal/handlers"
@@ -15,6 +18,25 @@ type FileStorage struct {
 	storeInterval   int
 	fileStoragePath string
 	restore         bool
+
+	mu       sync.Mutex
+	sections map[string]Section
+	loaded   map[string]json.RawMessage
+
+	lastSave    time.Time // время последней попытки сохранения
+	lastSaveErr error     // ошибка последней попытки сохранения
+
+	stop      chan struct{} // останавливает периодическое сохранение
+	stopped   chan struct{}
+	closeOnce sync.Once
+	closeErr  error
+}
+
+// Section is extra state saved in the snapshot file next to the metrics,
+// under its own top-level key
+type Section interface {
+	json.Marshaler
+	json.Unmarshaler
 }
 
 func NewFileStorage(s handlers.Storager, storeInterval int, fileStoragePath string, restore bool) (*FileStorage, error) {
@@ -30,6 +52,9 @@ func NewFileStorage(s handlers.Storager, storeInterval int, fileStoragePath stri
 		storeInterval:   storeInterval,
 		fileStoragePath: fileStoragePath,
 		restore:         restore,
+		sections:        make(map[string]Section),
+		stop:            make(chan struct{}),
+		stopped:         make(chan struct{}),
 	}
 
 	if fs.storeInterval <= 0 {
@@ -53,11 +78,8 @@ func NewFileStorage(s handlers.Storager, storeInterval int, fileStoragePath stri
 			return nil, err
 		}
 	}
-
 	// Save current metrics to the file at the specified interval
-	if fs.fileStoragePath != "" {
-		go fs.saveAtInterval()
-	}
+	go fs.saveAtInterval()
 
 	return fs, nil
 }
@@ -111,19 +133,73 @@ func (s *FileStorage) LoadFromFile() error {
 		return err
 	}
 
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(contents, &raw); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.loaded = raw
+	for name, section := range s.sections {
+		if err := restoreSection(name, section, raw); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
-func (s *FileStorage) Close() {
-	// Save the current metrics to the file before closing the storage
-	if err := s.SaveToFile(); err != nil {
-		logger.Log.WithError(err).Error("Failed to save metrics to file")
-		// Handle error
+// Attach adds a section to the snapshot file. If the section was present
+// in the file loaded at startup, it is restored right away
+func (s *FileStorage) Attach(name string, section Section) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sections[name] = section
+	return restoreSection(name, section, s.loaded)
+}
+
+func restoreSection(name string, section Section, raw map[string]json.RawMessage) error {
+	data, ok := raw[name]
+	if !ok || string(data) == "null" {
+		return nil
 	}
+	if err := section.UnmarshalJSON(data); err != nil {
+		return fmt.Errorf("failed to restore %s from file: %w", name, err)
+	}
+	return nil
+}
+
+// Close stops the periodic saving and saves the metrics one last time.
+// Later calls return the result of the first one
+func (s *FileStorage) Close() error {
+	s.closeOnce.Do(func() {
+		close(s.stop)
+		<-s.stopped
+
+		// Save the current metrics to the file before closing the storage
+		if err := s.SaveToFile(); err != nil {
+			logger.Log.WithError(err).Error("Failed to save metrics to file")
+			s.closeErr = err
+		}
+	})
+	return s.closeErr
 }
 
 func (s *FileStorage) SaveToFile() error {
-	data, err := json.MarshalIndent(s.Storager, "", "  ")
+	err := s.save()
+
+	s.mu.Lock()
+	s.lastSave, s.lastSaveErr = time.Now(), err
+	s.mu.Unlock()
+
+	return err
+}
+
+func (s *FileStorage) save() error {
+	data, err := s.marshal()
 	if err != nil {
 		return err
 	}
@@ -131,21 +207,115 @@ func (s *FileStorage) SaveToFile() error {
 	fmt.Println("Saving metrics to file:", s.fileStoragePath)
 	// fmt.Println("File contents:", string(data))
 
-	err = os.WriteFile(s.fileStoragePath, data, os.ModePerm)
+	return writeFileDurable(s.fileStoragePath, data)
+}
+
+// writeFileDurable replaces the file with data so that a crash leaves either
+// the old or the new snapshot: it writes a temporary file next to it,
+// syncs it to disk and renames it over the old one
+func writeFileDurable(path string, data []byte) error {
+	dir := filepath.Dir(path)
+	tmp, err := os.CreateTemp(dir, filepath.Base(path)+".tmp-*")
 	if err != nil {
 		return err
 	}
+	defer os.Remove(tmp.Name()) // no-op once renamed
 
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(0o644); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+
+	// The rename itself is durable only once the directory is synced.
+	// Not every platform can sync a directory, so this is best effort
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	return nil
+}
+
+// CheckWritable is a readiness check that the snapshot file can be
+// written, by creating and removing a file next to it
+func (s *FileStorage) CheckWritable(ctx context.Context) error {
+	f, err := os.CreateTemp(filepath.Dir(s.fileStoragePath), ".metrix-probe-*")
+	if err != nil {
+		return fmt.Errorf("snapshot directory is not writable: %w", err)
+	}
+	name := f.Name()
+	f.Close()
+	return os.Remove(name)
+}
+
+// CheckLastSave is a readiness check that fails while the latest
+// snapshot could not be saved
+func (s *FileStorage) CheckLastSave(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.lastSaveErr != nil {
+		return fmt.Errorf("last snapshot at %s failed: %w", s.lastSave.Format(time.RFC3339), s.lastSaveErr)
+	}
 	return nil
 }
 
+// marshal encodes the metrics and every attached section into one document
+func (s *FileStorage) marshal() ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.sections) == 0 {
+		return json.MarshalIndent(s.Storager, "", "  ")
+	}
+
+	metrics, err := json.Marshal(s.Storager)
+	if err != nil {
+		return nil, err
+	}
+
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal(metrics, &doc); err != nil {
+		return nil, err
+	}
+	for name, section := range s.sections {
+		data, err := section.MarshalJSON()
+		if err != nil {
+			return nil, fmt.Errorf("failed to save %s to file: %w", name, err)
+		}
+		doc[name] = data
+	}
+
+	return json.MarshalIndent(doc, "", "  ")
+}
+
 func (s *FileStorage) saveAtInterval() {
+	defer close(s.stopped)
+
 	ticker := time.NewTicker(time.Duration(s.storeInterval) * time.Second)
 	defer ticker.Stop()
 
-	for range ticker.C {
-		if err := s.SaveToFile(); err != nil {
-			logger.Log.WithError(err).Error("Failed to save metrics to file")
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			if err := s.SaveToFile(); err != nil {
+				logger.Log.WithError(err).Error("Failed to save metrics to file")
+			}
 		}
 	}
 }
@@ -1,6 +1,7 @@
 package storage
 
 import (
+	"context"
 	"encoding/json"
 	"os"
 	"testing"
@@ -181,3 +182,104 @@ func TestFileStorage(t *testing.T) {
 	assert.Equal(t, gaugeTest, gauge1)
 	assert.Equal(t, counterTest, counter1)
 }
+
+type testSection struct {
+	Values []string
+}
+
+func (ts *testSection) MarshalJSON() ([]byte, error) {
+	return json.Marshal(ts.Values)
+}
+
+func (ts *testSection) UnmarshalJSON(b []byte) error {
+	return json.Unmarshal(b, &ts.Values)
+}
+
+func TestFileStorage_Attach(t *testing.T) {
+	path := t.TempDir() + "/metrics-db.json"
+
+	fs, err := NewFileStorage(NewMemStorage(), 300, path, true)
+	assert.NoError(t, err)
+	fs.SetGauge("Alloc", 1.5)
+	assert.NoError(t, fs.Attach("Section", &testSection{Values: []string{"a", "b"}}))
+	assert.NoError(t, fs.SaveToFile())
+
+	// Metrics and the section both come back after a restart
+	restored, err := NewFileStorage(NewMemStorage(), 300, path, true)
+	assert.NoError(t, err)
+	section := &testSection{}
+	assert.NoError(t, restored.Attach("Section", section))
+	assert.Equal(t, []string{"a", "b"}, section.Values)
+
+	value, ok := restored.GetGauge("Alloc")
+	assert.True(t, ok)
+	assert.Equal(t, 1.5, value)
+}
+
+func TestFileStorage_Checks(t *testing.T) {
+	dir := t.TempDir()
+	path := dir + "/metrics-db.json"
+
+	fs, err := NewFileStorage(NewMemStorage(), 300, path, true)
+	assert.NoError(t, err)
+
+	ctx := context.Background()
+	assert.NoError(t, fs.CheckWritable(ctx))
+	// Nothing was saved yet, so nothing failed
+	assert.NoError(t, fs.CheckLastSave(ctx))
+
+	assert.NoError(t, fs.SaveToFile())
+	assert.NoError(t, fs.CheckLastSave(ctx))
+
+	// The probe file does not stay behind
+	entries, err := os.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+
+	// A snapshot that cannot be written fails the check until one succeeds
+	fs.fileStoragePath = dir + "/missing/metrics-db.json"
+	assert.Error(t, fs.SaveToFile())
+	assert.Error(t, fs.CheckLastSave(ctx))
+	assert.Error(t, fs.CheckWritable(ctx))
+
+	fs.fileStoragePath = path
+	assert.NoError(t, fs.SaveToFile())
+	assert.NoError(t, fs.CheckLastSave(ctx))
+}
+
+func TestFileStorage_Close(t *testing.T) {
+	path := t.TempDir() + "/metrics-db.json"
+
+	fs, err := NewFileStorage(NewMemStorage(), 300, path, true)
+	assert.NoError(t, err)
+	fs.SetGauge("Alloc", 1.5)
+
+	assert.NoError(t, fs.Close())
+	// The ticker goroutine is gone and a second Close does not save again
+	select {
+	case <-fs.stopped:
+	default:
+		t.Error("expected the periodic saving to stop")
+	}
+	assert.NoError(t, os.Remove(path))
+	assert.NoError(t, fs.Close())
+	_, err = os.Stat(path)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestWriteFileDurable(t *testing.T) {
+	dir := t.TempDir()
+	path := dir + "/metrics-db.json"
+
+	assert.NoError(t, writeFileDurable(path, []byte(`{"a":1}`)))
+	assert.NoError(t, writeFileDurable(path, []byte(`{"b":2}`)))
+
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"b":2}`, string(data))
+
+	// No temporary files are left next to the snapshot
+	entries, err := os.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+}
@@ -0,0 +1,112 @@
+package storage
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"Vova4o/metrix/internal/handlers"
+)
+
+// maxHistoryPoints caps the points kept per metric regardless of retention,
+// so a chatty agent cannot make the history grow without bound
+const maxHistoryPoints = 10000
+
+// HistoryStorage wraps a Storager and remembers the recent values
+// of every metric for the time given by retention
+type HistoryStorage struct {
+	handlers.Storager
+	retention time.Duration
+
+	mu     sync.RWMutex
+	series map[string][]handlers.HistoryPoint
+	now    func() time.Time
+}
+
+func NewHistoryStorage(s handlers.Storager, retention time.Duration) *HistoryStorage {
+	return &HistoryStorage{
+		Storager:  s,
+		retention: retention,
+		series:    make(map[string][]handlers.HistoryPoint),
+		now:       time.Now,
+	}
+}
+
+// SetGauge stores the gauge and records its new value
+func (hs *HistoryStorage) SetGauge(key string, value float64) {
+	hs.Storager.SetGauge(key, value)
+	hs.record("gauge", key, value)
+}
+
+// SetGaugeWithSource stores the gauge with its source and records its
+// new value
+func (hs *HistoryStorage) SetGaugeWithSource(key string, value float64, source string) {
+	hs.Storager.SetGaugeWithSource(key, value, source)
+	hs.record("gauge", key, value)
+}
+
+// SetCounter stores the counter and records its new total
+func (hs *HistoryStorage) SetCounter(key string, value int64) {
+	hs.Storager.SetCounter(key, value)
+	if total, ok := hs.Storager.GetCounter(key); ok {
+		hs.record("counter", key, float64(total))
+	}
+}
+
+// SetGaugeIfVersion stores the gauge if its version matches and records
+// the new value
+func (hs *HistoryStorage) SetGaugeIfVersion(key string, value float64, version uint64) bool {
+	if !hs.Storager.SetGaugeIfVersion(key, value, version) {
+		return false
+	}
+	hs.record("gauge", key, value)
+	return true
+}
+
+// Delete removes the metric together with its history, so a metric
+// created again under the same name starts from scratch
+func (hs *HistoryStorage) Delete(metricType, key string) bool {
+	exists := hs.Storager.Delete(metricType, key)
+
+	hs.mu.Lock()
+	delete(hs.series, metaKey(metricType, key))
+	hs.mu.Unlock()
+
+	return exists
+}
+
+// GetHistory returns the values recorded since the given time, oldest first
+func (hs *HistoryStorage) GetHistory(metricType, key string, since time.Time) []handlers.HistoryPoint {
+	hs.mu.RLock()
+	defer hs.mu.RUnlock()
+
+	points := hs.series[metaKey(metricType, key)]
+	start := sort.Search(len(points), func(i int) bool {
+		return !points[i].At.Before(since)
+	})
+
+	result := make([]handlers.HistoryPoint, len(points)-start)
+	copy(result, points[start:])
+	return result
+}
+
+func (hs *HistoryStorage) record(metricType, key string, value float64) {
+	now := hs.now()
+	k := metaKey(metricType, key)
+
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+
+	points := append(hs.series[k], handlers.HistoryPoint{At: now, Value: value})
+
+	cutoff := now.Add(-hs.retention)
+	drop := sort.Search(len(points), func(i int) bool {
+		return points[i].At.After(cutoff)
+	})
+	if len(points)-drop > maxHistoryPoints {
+		drop = len(points) - maxHistoryPoints
+	}
+	// Reslicing keeps the old points in the backing array only until the
+	// next append has to grow it, and that copies just the live points
+	hs.series[k] = points[drop:]
+}
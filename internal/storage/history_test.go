@@ -0,0 +1,93 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHistoryStorage_Record(t *testing.T) {
+	hs := NewHistoryStorage(NewMemStorage(), time.Hour)
+
+	now := time.Now()
+	hs.now = func() time.Time { return now }
+
+	hs.SetGauge("Alloc", 1)
+	now = now.Add(time.Minute)
+	hs.SetGauge("Alloc", 2)
+	hs.SetCounter("PollCount", 5)
+	now = now.Add(time.Minute)
+	hs.SetCounter("PollCount", 5)
+
+	// The wrapped storage still gets the values
+	value, ok := hs.GetGauge("Alloc")
+	assert.True(t, ok)
+	assert.Equal(t, 2.0, value)
+
+	gauges := hs.GetHistory("gauge", "Alloc", time.Time{})
+	assert.Len(t, gauges, 2)
+	assert.Equal(t, 1.0, gauges[0].Value)
+	assert.Equal(t, 2.0, gauges[1].Value)
+
+	// Counter history holds running totals, not deltas
+	counters := hs.GetHistory("counter", "PollCount", time.Time{})
+	assert.Len(t, counters, 2)
+	assert.Equal(t, 10.0, counters[1].Value)
+
+	assert.Len(t, hs.GetHistory("gauge", "Alloc", now.Add(-30*time.Second)), 0)
+	assert.Len(t, hs.GetHistory("gauge", "Alloc", now.Add(-90*time.Second)), 1)
+	assert.Empty(t, hs.GetHistory("gauge", "Missing", time.Time{}))
+}
+
+func TestHistoryStorage_Retention(t *testing.T) {
+	hs := NewHistoryStorage(NewMemStorage(), 10*time.Minute)
+
+	now := time.Now()
+	hs.now = func() time.Time { return now }
+
+	for i := 0; i < 30; i++ {
+		hs.SetGauge("Alloc", float64(i))
+		now = now.Add(time.Minute)
+	}
+
+	points := hs.GetHistory("gauge", "Alloc", time.Time{})
+	assert.Len(t, points, 10)
+	assert.Equal(t, 20.0, points[0].Value)
+	assert.Equal(t, 29.0, points[len(points)-1].Value)
+}
+
+func TestHistoryStorage_MaxPoints(t *testing.T) {
+	hs := NewHistoryStorage(NewMemStorage(), 24*time.Hour)
+
+	for i := 0; i < maxHistoryPoints+10; i++ {
+		hs.SetGauge("Alloc", float64(i))
+	}
+
+	points := hs.GetHistory("gauge", "Alloc", time.Time{})
+	assert.Len(t, points, maxHistoryPoints)
+	assert.Equal(t, 10.0, points[0].Value)
+}
+
+func TestHistoryStorage_Delete(t *testing.T) {
+	hs := NewHistoryStorage(NewMemStorage(), time.Hour)
+	hs.SetGauge("Alloc", 1)
+	hs.SetGauge("Alloc", 2)
+
+	assert.True(t, hs.Delete("gauge", "Alloc"))
+	assert.Empty(t, hs.GetHistory("gauge", "Alloc", time.Time{}))
+
+	_, ok := hs.GetGauge("Alloc")
+	assert.False(t, ok)
+	assert.False(t, hs.Delete("gauge", "Alloc"))
+}
+
+func TestHistoryStorage_SetGaugeIfVersion(t *testing.T) {
+	hs := NewHistoryStorage(NewMemStorage(), time.Hour)
+	hs.SetGauge("Alloc", 1)
+	meta, _ := hs.GetMeta("gauge", "Alloc")
+
+	assert.True(t, hs.SetGaugeIfVersion("Alloc", 2, meta.Version))
+	assert.False(t, hs.SetGaugeIfVersion("Alloc", 3, meta.Version))
+	assert.Len(t, hs.GetHistory("gauge", "Alloc", time.Time{}), 2)
+}
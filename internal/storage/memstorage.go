@@ -1,7 +1,9 @@
 package storage
 
 import (
+	"encoding/json"
 	"sync"
+	"time"
 
 	"Vova4o/metrix/internal/handlers"
 )
@@ -10,11 +12,23 @@ import (
 // that implements the StorageInterface
 // It uses a mutex to synchronize access to the maps
 // GaugeMetrics and CounterMetrics
+// Meta holds the last update time, source and version of every metric, keyed by type and name
 type MemStorage struct {
 	mu             sync.Mutex
 	GaugeMetrics   map[string]float64
 	CounterMetrics map[string]int64
+	Meta           map[string]handlers.MetricMeta
 	Err            error
+
+	// version is the latest version given to a change. It starts from
+	// the start time, so versions never repeat across restarts, and it is
+	// not saved: versions restored with Meta are older than any new one
+	version  uint64
+	modified time.Time
+
+	subMu     sync.Mutex
+	subs      map[uint64]func(handlers.MetricsJSON)
+	nextSubID uint64
 }
 
 // NewMemStorage creates a new MemStorage
@@ -24,30 +38,73 @@ func NewMemStorage() handlers.Storager {
 	return &MemStorage{
 		GaugeMetrics:   make(map[string]float64),
 		CounterMetrics: make(map[string]int64),
+		Meta:           make(map[string]handlers.MetricMeta),
 		Err:            nil,
+		version:        uint64(time.Now().UnixNano()),
 	}
 }
 
-// GetAllGauges returns a map of all gauge metrics
+// GetAllGauges returns a copy of all gauge metrics
 func (ms *MemStorage) GetAllGauges() map[string]float64 {
 	ms.mu.Lock()
 	defer ms.mu.Unlock()
-	return ms.GaugeMetrics
+	return copyMap(ms.GaugeMetrics)
 }
 
-// GetAllCounters returns a map of all counter metrics
+// GetAllCounters returns a copy of all counter metrics
 func (ms *MemStorage) GetAllCounters() map[string]int64 {
 	ms.mu.Lock()
 	defer ms.mu.Unlock()
-	return ms.CounterMetrics
+	return copyMap(ms.CounterMetrics)
 }
 
-// SetGauge sets the value of a gauge metric
+// SetGauge sets the value of a gauge metric and notifies subscribers
 func (ms *MemStorage) SetGauge(key string, value float64) {
 	ms.mu.Lock()
-	defer ms.mu.Unlock()
+	ms.GaugeMetrics[key] = value
+	ms.touch("gauge", key)
+	ms.mu.Unlock()
+
+	ms.notify(handlers.MetricsJSON{ID: key, MType: "gauge", Value: &value})
+}
+
+// SetGaugeWithSource sets the value of a gauge metric together with the
+// agent or rule it came from, as one change, and notifies subscribers
+func (ms *MemStorage) SetGaugeWithSource(key string, value float64, source string) {
+	ms.mu.Lock()
+	ms.GaugeMetrics[key] = value
+	ms.touch("gauge", key)
+	k := metaKey("gauge", key)
+	meta := ms.Meta[k]
+	meta.Source = source
+	ms.Meta[k] = meta
+	ms.mu.Unlock()
+
+	ms.notify(handlers.MetricsJSON{ID: key, MType: "gauge", Value: &value})
+}
 
+// SetGaugeIfVersion sets a gauge only if its version is still the given
+// one, so clients can update the value they read without losing a change
+// made in between
+func (ms *MemStorage) SetGaugeIfVersion(key string, value float64, version uint64) bool {
+	ms.mu.Lock()
+	if _, exists := ms.GaugeMetrics[key]; !exists || ms.Meta[metaKey("gauge", key)].Version != version {
+		ms.mu.Unlock()
+		return false
+	}
 	ms.GaugeMetrics[key] = value
+	ms.touch("gauge", key)
+	ms.mu.Unlock()
+
+	ms.notify(handlers.MetricsJSON{ID: key, MType: "gauge", Value: &value})
+	return true
+}
+
+// Version returns the version and time of the latest change
+func (ms *MemStorage) Version() (uint64, time.Time) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	return ms.version, ms.modified
 }
 
 // GetGauge returns the value of a gauge metric
@@ -59,12 +116,16 @@ func (ms *MemStorage) GetGauge(key string) (float64, bool) {
 	return value, exists
 }
 
-// SetCounter sets the value of a counter metric
+// SetCounter adds the value to a counter metric
+// and notifies subscribers of the new total
 func (ms *MemStorage) SetCounter(key string, value int64) {
 	ms.mu.Lock()
-	defer ms.mu.Unlock()
-
 	ms.CounterMetrics[key] += value
+	total := ms.CounterMetrics[key]
+	ms.touch("counter", key)
+	ms.mu.Unlock()
+
+	ms.notify(handlers.MetricsJSON{ID: key, MType: "counter", Delta: &total})
 }
 
 // GetCounter returns the value of a counter metric
@@ -81,15 +142,185 @@ func (ms *MemStorage) GetAllMetrics() map[string]interface{} {
 	defer ms.mu.Unlock()
 
 	return map[string]interface{}{
-		"Gauge":   ms.GaugeMetrics,
-		"Counter": ms.CounterMetrics,
+		"Gauge":   copyMap(ms.GaugeMetrics),
+		"Counter": copyMap(ms.CounterMetrics),
 	}
 }
 
-// func (ms *MemStorage) Delete(key string) {
-// 	ms.mu.Lock()
-// 	defer ms.mu.Unlock()
+// memSnapshot is how MemStorage is saved to the snapshot file
+type memSnapshot struct {
+	GaugeMetrics   map[string]float64
+	CounterMetrics map[string]int64
+	Meta           map[string]handlers.MetricMeta
+	Err            error
+}
+
+// MarshalJSON copies the metrics under the lock and encodes the copy,
+// so updates may go on while the snapshot is written
+func (ms *MemStorage) MarshalJSON() ([]byte, error) {
+	ms.mu.Lock()
+	snapshot := memSnapshot{
+		GaugeMetrics:   copyMap(ms.GaugeMetrics),
+		CounterMetrics: copyMap(ms.CounterMetrics),
+		Meta:           copyMap(ms.Meta),
+		Err:            ms.Err,
+	}
+	ms.mu.Unlock()
+
+	return json.Marshal(snapshot)
+}
+
+// UnmarshalJSON adds the metrics of a snapshot to the storage
+func (ms *MemStorage) UnmarshalJSON(data []byte) error {
+	var snapshot memSnapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return err
+	}
+
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	for key, value := range snapshot.GaugeMetrics {
+		ms.GaugeMetrics[key] = value
+	}
+	for key, delta := range snapshot.CounterMetrics {
+		ms.CounterMetrics[key] = delta
+	}
+	for key, meta := range snapshot.Meta {
+		ms.Meta[key] = meta
+	}
+	return nil
+}
+
+func copyMap[K comparable, V any](m map[K]V) map[K]V {
+	c := make(map[K]V, len(m))
+	for k, v := range m {
+		c[k] = v
+	}
+	return c
+}
 
-// 	delete(ms.GaugeMetrics, key)
-// 	delete(ms.CounterMetrics, key)
-// }
+// Range calls fn for every gauge, then every counter, while holding
+// the lock, so callers can filter the store without copying it
+func (ms *MemStorage) Range(fn func(handlers.MetricsJSON, handlers.MetricMeta) bool) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	for key, value := range ms.GaugeMetrics {
+		m := handlers.MetricsJSON{ID: key, MType: "gauge", Value: &value}
+		if !fn(m, ms.Meta[metaKey("gauge", key)]) {
+			return
+		}
+	}
+	for key, delta := range ms.CounterMetrics {
+		m := handlers.MetricsJSON{ID: key, MType: "counter", Delta: &delta}
+		if !fn(m, ms.Meta[metaKey("counter", key)]) {
+			return
+		}
+	}
+}
+
+// GetMeta returns the bookkeeping data of a metric
+func (ms *MemStorage) GetMeta(metricType, key string) (handlers.MetricMeta, bool) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	meta, exists := ms.Meta[metaKey(metricType, key)]
+	return meta, exists
+}
+
+// SetSource records which agent sent the last value of a metric
+func (ms *MemStorage) SetSource(metricType, key, source string) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	k := metaKey(metricType, key)
+	meta, exists := ms.Meta[k]
+	if !exists || meta.Source == source {
+		return
+	}
+	// The source is listed next to the value, so it is a change too
+	meta.Source = source
+	meta.Version = ms.bump()
+	ms.Meta[k] = meta
+}
+
+// Subscribe registers fn to be called after every gauge or counter update.
+// fn runs on the updating goroutine and must not block or call back into
+// the storage. The returned function removes the subscription
+func (ms *MemStorage) Subscribe(fn func(handlers.MetricsJSON)) func() {
+	ms.subMu.Lock()
+	defer ms.subMu.Unlock()
+
+	if ms.subs == nil {
+		ms.subs = make(map[uint64]func(handlers.MetricsJSON))
+	}
+	id := ms.nextSubID
+	ms.nextSubID++
+	ms.subs[id] = fn
+
+	return func() {
+		ms.subMu.Lock()
+		defer ms.subMu.Unlock()
+		delete(ms.subs, id)
+	}
+}
+
+func (ms *MemStorage) notify(change handlers.MetricsJSON) {
+	ms.subMu.Lock()
+	subs := make([]func(handlers.MetricsJSON), 0, len(ms.subs))
+	for _, fn := range ms.subs {
+		subs = append(subs, fn)
+	}
+	ms.subMu.Unlock()
+
+	for _, fn := range subs {
+		fn(change)
+	}
+}
+
+// touch records the update time of a metric, the caller must hold the lock
+func (ms *MemStorage) touch(metricType, key string) {
+	if ms.Meta == nil {
+		// Snapshots written before Meta existed leave it nil
+		ms.Meta = make(map[string]handlers.MetricMeta)
+	}
+	k := metaKey(metricType, key)
+	meta := ms.Meta[k]
+	meta.UpdatedAt = time.Now()
+	meta.Version = ms.bump()
+	ms.Meta[k] = meta
+}
+
+// bump starts a new version, the caller must hold the lock
+func (ms *MemStorage) bump() uint64 {
+	ms.version++
+	ms.modified = time.Now()
+	return ms.version
+}
+
+func metaKey(metricType, key string) string {
+	return metricType + "/" + key
+}
+
+// Delete removes a metric and its meta data. Subscribers are not
+// notified, a stream has no way to tell a value from its absence
+func (ms *MemStorage) Delete(metricType, key string) bool {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	var exists bool
+	switch metricType {
+	case "gauge":
+		_, exists = ms.GaugeMetrics[key]
+		delete(ms.GaugeMetrics, key)
+	case "counter":
+		_, exists = ms.CounterMetrics[key]
+		delete(ms.CounterMetrics, key)
+	}
+	delete(ms.Meta, metaKey(metricType, key))
+	if exists {
+		ms.bump()
+	}
+	return exists
+}
@@ -1,6 +1,14 @@
 package storage
 
-import "testing"
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"Vova4o/metrix/internal/handlers"
+)
 
 func TestMemStorage_GetAllGauges(t *testing.T) {
 	ms := NewMemStorage()
@@ -23,6 +31,12 @@ func TestMemStorage_GetAllGauges(t *testing.T) {
 	if gauges["gauge2"] != 4.56 {
 		t.Errorf("expected %v, got %v", 4.56, gauges["gauge2"])
 	}
+
+	// The returned map is a copy
+	gauges["gauge3"] = 7.89
+	if _, ok := ms.GetGauge("gauge3"); ok {
+		t.Errorf("expected changes to the returned map not to reach the storage")
+	}
 }
 
 func TestMemStorage_GetAllCounters(t *testing.T) {
@@ -182,3 +196,196 @@ func TestMemStorage_GetAllMetrics(t *testing.T) {
 		t.Errorf("expected %v, got %v", "map[string]int64", metrics["Counter"])
 	}
 }
+
+func TestMemStorage_GetMeta(t *testing.T) {
+	ms := NewMemStorage()
+
+	if _, exists := ms.GetMeta("gauge", "gauge1"); exists {
+		t.Errorf("expected no meta before the first update")
+	}
+
+	before := time.Now()
+	ms.SetGauge("gauge1", 1.23)
+	ms.SetCounter("gauge1", 1)
+
+	meta, exists := ms.GetMeta("gauge", "gauge1")
+	if !exists {
+		t.Fatalf("expected meta for gauge1")
+	}
+	if meta.UpdatedAt.Before(before) {
+		t.Errorf("expected update time after %v, got %v", before, meta.UpdatedAt)
+	}
+	if _, exists := ms.GetMeta("counter", "gauge1"); !exists {
+		t.Errorf("expected separate meta for the counter with the same name")
+	}
+}
+
+func TestMemStorage_Subscribe(t *testing.T) {
+	ms := NewMemStorage()
+
+	var changes []handlers.MetricsJSON
+	unsubscribe := ms.Subscribe(func(m handlers.MetricsJSON) {
+		changes = append(changes, m)
+	})
+
+	ms.SetGauge("gauge1", 1.5)
+	ms.SetCounter("counter1", 2)
+	ms.SetCounter("counter1", 3)
+	unsubscribe()
+	ms.SetGauge("gauge1", 2.5)
+
+	if len(changes) != 3 {
+		t.Fatalf("expected %v changes, got %v", 3, len(changes))
+	}
+	if changes[0].ID != "gauge1" || changes[0].MType != "gauge" || *changes[0].Value != 1.5 {
+		t.Errorf("unexpected gauge change: %+v", changes[0])
+	}
+	// Counter changes carry the running total
+	if changes[2].ID != "counter1" || changes[2].MType != "counter" || *changes[2].Delta != 5 {
+		t.Errorf("unexpected counter change: %+v", changes[2])
+	}
+}
+
+func TestMemStorage_Range(t *testing.T) {
+	ms := NewMemStorage()
+	ms.SetGauge("gauge1", 1.5)
+	ms.SetGauge("gauge2", 2.5)
+	ms.SetCounter("counter1", 3)
+
+	seen := make(map[string]handlers.MetricsJSON)
+	ms.Range(func(m handlers.MetricsJSON, meta handlers.MetricMeta) bool {
+		if meta.UpdatedAt.IsZero() {
+			t.Errorf("expected update time for %s", m.ID)
+		}
+		seen[m.MType+"/"+m.ID] = m
+		return true
+	})
+
+	if len(seen) != 3 {
+		t.Fatalf("expected %v metrics, got %v", 3, len(seen))
+	}
+	if *seen["gauge/gauge2"].Value != 2.5 || *seen["counter/counter1"].Delta != 3 {
+		t.Errorf("unexpected values: %+v", seen)
+	}
+
+	calls := 0
+	ms.Range(func(handlers.MetricsJSON, handlers.MetricMeta) bool {
+		calls++
+		return false
+	})
+	if calls != 1 {
+		t.Errorf("expected Range to stop after %v call, got %v", 1, calls)
+	}
+}
+
+func TestMemStorage_Delete(t *testing.T) {
+	ms := NewMemStorage()
+	ms.SetGauge("metric1", 1.5)
+	ms.SetCounter("metric1", 3)
+
+	if !ms.Delete("gauge", "metric1") {
+		t.Errorf("expected Delete to report the existing gauge")
+	}
+	if _, exists := ms.GetGauge("metric1"); exists {
+		t.Errorf("expected gauge metric1 to be deleted")
+	}
+	if _, exists := ms.GetMeta("gauge", "metric1"); exists {
+		t.Errorf("expected the meta of the gauge to be deleted")
+	}
+	// The counter with the same name is left alone
+	if value, exists := ms.GetCounter("metric1"); !exists || value != 3 {
+		t.Errorf("expected counter metric1 to be %v, got %v", 3, value)
+	}
+	if ms.Delete("gauge", "metric1") {
+		t.Errorf("expected Delete to report a missing gauge")
+	}
+}
+
+func TestMemStorage_Version(t *testing.T) {
+	ms := NewMemStorage()
+	start, _ := ms.Version()
+
+	ms.SetGauge("gauge1", 1)
+	meta, _ := ms.GetMeta("gauge", "gauge1")
+	version, modified := ms.Version()
+	if version <= start || meta.Version != version {
+		t.Errorf("expected the update to get a new version, got %v for gauge1 and %v in total after %v", meta.Version, version, start)
+	}
+	if modified.IsZero() {
+		t.Errorf("expected a modification time")
+	}
+
+	// A new source is a change, the same source again is not
+	ms.SetSource("gauge", "gauge1", "agent-1")
+	afterSource, _ := ms.Version()
+	ms.SetSource("gauge", "gauge1", "agent-1")
+	if again, _ := ms.Version(); afterSource != version+1 || again != afterSource {
+		t.Errorf("unexpected versions after setting the source: %v, %v", afterSource, again)
+	}
+
+	ms.Delete("gauge", "gauge1")
+	if deleted, _ := ms.Version(); deleted != afterSource+1 {
+		t.Errorf("expected Delete to start a new version, got %v", deleted)
+	}
+}
+
+func TestMemStorage_SetGaugeIfVersion(t *testing.T) {
+	ms := NewMemStorage()
+	if ms.SetGaugeIfVersion("gauge1", 1, 0) {
+		t.Errorf("expected a missing gauge not to be set")
+	}
+
+	ms.SetGauge("gauge1", 1)
+	meta, _ := ms.GetMeta("gauge", "gauge1")
+
+	if !ms.SetGaugeIfVersion("gauge1", 2, meta.Version) {
+		t.Fatalf("expected the gauge to be set at its current version")
+	}
+	// The version read before the last update is stale now
+	if ms.SetGaugeIfVersion("gauge1", 3, meta.Version) {
+		t.Errorf("expected a stale version to be refused")
+	}
+	if value, _ := ms.GetGauge("gauge1"); value != 2 {
+		t.Errorf("expected %v, got %v", 2, value)
+	}
+}
+
+func TestMemStorage_MarshalJSON(t *testing.T) {
+	ms := NewMemStorage()
+	ms.SetGauge("gauge1", 1.5)
+	ms.SetCounter("counter1", 3)
+
+	// Snapshots are taken while handlers keep writing
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			ms.SetGauge(fmt.Sprintf("busy%d", i), float64(i))
+		}
+	}()
+	for i := 0; i < 10; i++ {
+		if _, err := json.Marshal(ms); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	wg.Wait()
+
+	data, err := json.Marshal(ms)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	restored := NewMemStorage()
+	if err := json.Unmarshal(data, restored); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if value, _ := restored.GetGauge("gauge1"); value != 1.5 {
+		t.Errorf("expected %v, got %v", 1.5, value)
+	}
+	if delta, _ := restored.GetCounter("counter1"); delta != 3 {
+		t.Errorf("expected %v, got %v", 3, delta)
+	}
+	if _, ok := restored.GetMeta("gauge", "busy99"); !ok {
+		t.Errorf("expected the meta of busy99 to be restored")
+	}
+}
@@ -0,0 +1,69 @@
+package storage
+
+import (
+	"fmt"
+	"sync"
+
+	"Vova4o/metrix/internal/handlers"
+)
+
+// QuotaStorage wraps a Storager and caps the number of metrics in it.
+// Updates of existing metrics always pass, new ones only while there
+// is room. Admitted updates are stored one request at a time, so
+// requests running at the same time cannot overshoot the cap together
+type QuotaStorage struct {
+	handlers.Storager
+	maxMetrics int
+
+	mu sync.Mutex // держится от проверки квоты до конца записи
+}
+
+// NewQuotaStorage caps s at maxMetrics metrics, 0 leaves it unlimited
+func NewQuotaStorage(s handlers.Storager, maxMetrics int) *QuotaStorage {
+	return &QuotaStorage{Storager: s, maxMetrics: maxMetrics}
+}
+
+// Admit implements handlers.Limiter
+func (qs *QuotaStorage) Admit(updates []handlers.MetricsJSON, store func()) error {
+	if qs.maxMetrics <= 0 {
+		store()
+		return nil
+	}
+
+	qs.mu.Lock()
+	defer qs.mu.Unlock()
+
+	created := make(map[string]bool)
+	for _, m := range updates {
+		if !qs.exists(m.MType, m.ID) {
+			created[metaKey(m.MType, m.ID)] = true
+		}
+	}
+	if len(created) == 0 {
+		store()
+		return nil
+	}
+
+	count := len(created)
+	qs.Range(func(handlers.MetricsJSON, handlers.MetricMeta) bool {
+		count++
+		return count <= qs.maxMetrics
+	})
+	if count > qs.maxMetrics {
+		return fmt.Errorf("the updates create %d metrics, the quota of %d metrics does not leave room for them", len(created), qs.maxMetrics)
+	}
+	store()
+	return nil
+}
+
+func (qs *QuotaStorage) exists(metricType, key string) bool {
+	switch metricType {
+	case "gauge":
+		_, ok := qs.GetGauge(key)
+		return ok
+	case "counter":
+		_, ok := qs.GetCounter(key)
+		return ok
+	}
+	return false
+}
@@ -0,0 +1,78 @@
+package storage
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"Vova4o/metrix/internal/handlers"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func gaugeUpdate(name string) handlers.MetricsJSON {
+	value := 1.0
+	return handlers.MetricsJSON{ID: name, MType: "gauge", Value: &value}
+}
+
+// admit asks qs about the updates and reports whether it stored them
+func admit(qs *QuotaStorage, updates ...handlers.MetricsJSON) (bool, error) {
+	stored := false
+	err := qs.Admit(updates, func() { stored = true })
+	return stored, err
+}
+
+func TestQuotaStorage_Admit(t *testing.T) {
+	qs := NewQuotaStorage(NewMemStorage(), 2)
+	qs.SetGauge("Alloc", 1)
+
+	stored, err := admit(qs, gaugeUpdate("Alloc"))
+	assert.NoError(t, err, "existing metrics always pass")
+	assert.True(t, stored)
+	_, err = admit(qs, gaugeUpdate("Heap"))
+	assert.NoError(t, err)
+	_, err = admit(qs, gaugeUpdate("Heap"), gaugeUpdate("Heap"))
+	assert.NoError(t, err, "repeated names count once")
+	stored, err = admit(qs, gaugeUpdate("Heap"), gaugeUpdate("Sys"))
+	assert.Error(t, err)
+	assert.False(t, stored)
+
+	// The same name with another type is another metric
+	qs.SetGauge("Heap", 1)
+	_, err = admit(qs, handlers.MetricsJSON{ID: "Alloc", MType: "counter"})
+	assert.Error(t, err)
+	_, err = admit(qs, gaugeUpdate("Alloc"), gaugeUpdate("Heap"))
+	assert.NoError(t, err)
+
+	// Deleting a metric frees its place
+	qs.Delete("gauge", "Heap")
+	_, err = admit(qs, gaugeUpdate("Sys"))
+	assert.NoError(t, err)
+}
+
+func TestQuotaStorage_Concurrent(t *testing.T) {
+	qs := NewQuotaStorage(NewMemStorage(), 10)
+
+	// Each request creates its own metric, only ten of them fit
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			_ = qs.Admit([]handlers.MetricsJSON{gaugeUpdate(name)}, func() { qs.SetGauge(name, 1) })
+		}(fmt.Sprintf("gauge%d", i))
+	}
+	wg.Wait()
+
+	assert.Len(t, qs.GetAllGauges(), 10)
+}
+
+func TestQuotaStorage_Unlimited(t *testing.T) {
+	qs := NewQuotaStorage(NewMemStorage(), 0)
+	for _, name := range []string{"a", "b", "c"} {
+		qs.SetGauge(name, 1)
+	}
+	stored, err := admit(qs, gaugeUpdate("d"))
+	assert.NoError(t, err)
+	assert.True(t, stored)
+}
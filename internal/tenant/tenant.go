@@ -0,0 +1,207 @@
+// Package tenant keeps the metrics of teams sharing a server apart. Each
+// tenant has its own storage, snapshot file and quota on the number of
+// metrics. Requests without a tenant use the default storage
+package tenant
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"Vova4o/metrix/internal/handlers"
+	"Vova4o/metrix/internal/health"
+	"Vova4o/metrix/internal/storage"
+)
+
+// Header names the tenant of a request whose token does not
+const Header = "X-Tenant-ID"
+
+// validName keeps tenant names safe to put into file names
+var validName = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,62}$`)
+
+// Config lists the tenants of the server
+type Config struct {
+	MaxMetrics int            `json:"max_metrics"` // квота по умолчанию, 0 — без ограничения
+	Tenants    []TenantConfig `json:"tenants"`
+}
+
+// TenantConfig describes one tenant
+type TenantConfig struct {
+	Name       string `json:"name"`
+	MaxMetrics int    `json:"max_metrics"` // 0 — квота из Config
+}
+
+// Options are the storage settings the tenants share with the default storage
+type Options struct {
+	FileStoragePath  string // пустой — без снимков, иначе у каждого арендатора свой файл рядом
+	StoreInterval    int
+	Restore          bool
+	HistoryRetention time.Duration
+}
+
+// Tenant is the storage of one tenant
+type Tenant struct {
+	Name    string
+	Storage *storage.QuotaStorage
+	History *storage.HistoryStorage
+
+	file *storage.FileStorage // nil без снимков
+}
+
+// Registry holds the tenants of the server
+type Registry struct {
+	tenants map[string]*Tenant
+}
+
+// LoadConfig reads the tenants from a JSON file
+func LoadConfig(path string) (Config, error) {
+	var cfg Config
+
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, fmt.Errorf("failed to read tenants config %s: %w", path, err)
+	}
+	if err := json.Unmarshal(contents, &cfg); err != nil {
+		return cfg, fmt.Errorf("failed to parse tenants config %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// New opens the storage of every tenant and restores its snapshot.
+// It fails on invalid or repeated names
+func New(cfg Config, opts Options) (*Registry, error) {
+	reg := &Registry{tenants: make(map[string]*Tenant, len(cfg.Tenants))}
+
+	for _, tc := range cfg.Tenants {
+		if !validName.MatchString(tc.Name) {
+			reg.Close()
+			return nil, fmt.Errorf("tenant %q: name must be lowercase letters, digits, - and _", tc.Name)
+		}
+		if _, ok := reg.tenants[tc.Name]; ok {
+			reg.Close()
+			return nil, fmt.Errorf("tenant %s: name is used twice", tc.Name)
+		}
+
+		maxMetrics := tc.MaxMetrics
+		if maxMetrics == 0 {
+			maxMetrics = cfg.MaxMetrics
+		}
+		t, err := open(tc.Name, maxMetrics, opts)
+		if err != nil {
+			reg.Close()
+			return nil, fmt.Errorf("tenant %s: %w", tc.Name, err)
+		}
+		reg.tenants[tc.Name] = t
+	}
+	return reg, nil
+}
+
+func open(name string, maxMetrics int, opts Options) (*Tenant, error) {
+	t := &Tenant{Name: name}
+
+	metrics := storage.NewMemStorage()
+	if opts.FileStoragePath != "" {
+		var err error
+		t.file, err = storage.NewFileStorage(metrics, opts.StoreInterval, SnapshotPath(opts.FileStoragePath, name), opts.Restore)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	t.History = storage.NewHistoryStorage(metrics, opts.HistoryRetention)
+	t.Storage = storage.NewQuotaStorage(t.History, maxMetrics)
+	return t, nil
+}
+
+// SnapshotPath is the snapshot file of a tenant: the name goes before the
+// extension of the default file, /tmp/metrics-db.json becomes
+// /tmp/metrics-db.team-a.json
+func SnapshotPath(path, name string) string {
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + "." + name + ext
+}
+
+// Get returns the tenant with the given name
+func (reg *Registry) Get(name string) (*Tenant, bool) {
+	t, ok := reg.tenants[name]
+	return t, ok
+}
+
+// Checks returns the readiness checks of the tenants' snapshots by name
+func (reg *Registry) Checks() map[string]health.Check {
+	checks := make(map[string]health.Check)
+	for name, t := range reg.tenants {
+		if t.file != nil {
+			checks["last_snapshot_"+name] = t.file.CheckLastSave
+		}
+	}
+	return checks
+}
+
+// Close saves the final snapshot of every tenant
+func (reg *Registry) Close() error {
+	var errs []error
+	for name, t := range reg.tenants {
+		if t.file == nil {
+			continue
+		}
+		if err := t.file.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("tenant %s: %w", name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Handler serves a request with the handler build makes for the storage
+// of its tenant, or with def for requests without a tenant. The handlers
+// are built once per tenant, on first use
+func (reg *Registry) Handler(def http.Handler, build func(t *Tenant) http.Handler) http.Handler {
+	var (
+		mu    sync.Mutex
+		built = make(map[string]http.Handler)
+	)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name, ok := FromContext(r.Context())
+		if !ok || name == "" {
+			def.ServeHTTP(w, r)
+			return
+		}
+		t, ok := reg.Get(name)
+		if !ok {
+			handlers.WriteProblem(w, r, handlers.NewProblem(http.StatusInternalServerError, handlers.CodeInternal,
+				fmt.Sprintf("tenant %s is not open", name)))
+			return
+		}
+
+		mu.Lock()
+		h, ok := built[name]
+		if !ok {
+			h = build(t)
+			built[name] = h
+		}
+		mu.Unlock()
+
+		h.ServeHTTP(w, r)
+	})
+}
+
+type contextKey struct{}
+
+// WithTenant returns a copy of ctx that carries the tenant name
+func WithTenant(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, contextKey{}, name)
+}
+
+// FromContext returns the tenant a request was made for
+func FromContext(ctx context.Context) (string, bool) {
+	name, ok := ctx.Value(contextKey{}).(string)
+	return name, ok
+}
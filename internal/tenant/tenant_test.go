@@ -0,0 +1,125 @@
+package tenant
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"Vova4o/metrix/internal/handlers"
+	"Vova4o/metrix/internal/logger"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSnapshotPath(t *testing.T) {
+	assert.Equal(t, "/tmp/metrics-db.team-a.json", SnapshotPath("/tmp/metrics-db.json", "team-a"))
+	assert.Equal(t, "/var/lib/metrix/db.team-a", SnapshotPath("/var/lib/metrix/db", "team-a"))
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name    string
+		tenants []TenantConfig
+		err     string
+	}{
+		{"valid", []TenantConfig{{Name: "team-a"}, {Name: "team_b2"}}, ""},
+		{"empty name", []TenantConfig{{Name: ""}}, "name must be"},
+		{"path in name", []TenantConfig{{Name: "../etc"}}, "name must be"},
+		{"upper case", []TenantConfig{{Name: "TeamA"}}, "name must be"},
+		{"same name", []TenantConfig{{Name: "team-a"}, {Name: "team-a"}}, "used twice"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reg, err := New(Config{Tenants: tt.tenants}, Options{HistoryRetention: time.Hour})
+			if tt.err != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.err)
+				return
+			}
+			require.NoError(t, err)
+			for _, tc := range tt.tenants {
+				_, ok := reg.Get(tc.Name)
+				assert.True(t, ok)
+			}
+		})
+	}
+}
+
+func TestNew_Quotas(t *testing.T) {
+	reg, err := New(Config{MaxMetrics: 1, Tenants: []TenantConfig{{Name: "small"}, {Name: "big", MaxMetrics: 3}}},
+		Options{HistoryRetention: time.Hour})
+	require.NoError(t, err)
+
+	value := 1.0
+	small, _ := reg.Get("small")
+	small.Storage.SetGauge("Alloc", 1)
+	assert.Error(t, small.Storage.Admit([]handlers.MetricsJSON{{ID: "Heap", MType: "gauge", Value: &value}}, func() {}))
+
+	big, _ := reg.Get("big")
+	big.Storage.SetGauge("Alloc", 1)
+	assert.NoError(t, big.Storage.Admit([]handlers.MetricsJSON{{ID: "Heap", MType: "gauge", Value: &value}}, func() {}))
+}
+
+func TestRegistry_Snapshots(t *testing.T) {
+	_ = logger.New("test.log")
+	path := filepath.Join(t.TempDir(), "metrics-db.json")
+	opts := Options{FileStoragePath: path, StoreInterval: 300, Restore: true, HistoryRetention: time.Hour}
+
+	reg, err := New(Config{Tenants: []TenantConfig{{Name: "team-a"}, {Name: "team-b"}}}, opts)
+	require.NoError(t, err)
+	a, _ := reg.Get("team-a")
+	a.Storage.SetCounter("PollCount", 5)
+	assert.Len(t, reg.Checks(), 2)
+	require.NoError(t, reg.Close())
+
+	_, err = os.Stat(SnapshotPath(path, "team-b"))
+	require.NoError(t, err)
+
+	// A new server restores each tenant from its own file
+	reg, err = New(Config{Tenants: []TenantConfig{{Name: "team-a"}, {Name: "team-b"}}}, opts)
+	require.NoError(t, err)
+	defer reg.Close()
+
+	a, _ = reg.Get("team-a")
+	count, ok := a.Storage.GetCounter("PollCount")
+	require.True(t, ok)
+	assert.Equal(t, int64(5), count)
+
+	b, _ := reg.Get("team-b")
+	_, ok = b.Storage.GetCounter("PollCount")
+	assert.False(t, ok)
+}
+
+func TestRegistry_Handler(t *testing.T) {
+	reg, err := New(Config{Tenants: []TenantConfig{{Name: "team-a"}, {Name: "team-b"}}}, Options{HistoryRetention: time.Hour})
+	require.NoError(t, err)
+
+	builds := make(map[string]int)
+	def := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("default")) })
+	h := reg.Handler(def, func(tenant *Tenant) http.Handler {
+		builds[tenant.Name]++
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.Write([]byte(tenant.Name)) })
+	})
+
+	serve := func(name string) string {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if name != "-" {
+			req = req.WithContext(WithTenant(req.Context(), name))
+		}
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		return rr.Body.String()
+	}
+
+	assert.Equal(t, "default", serve("-"))
+	assert.Equal(t, "default", serve(""))
+	assert.Equal(t, "team-a", serve("team-a"))
+	assert.Equal(t, "team-a", serve("team-a"))
+	assert.Equal(t, "team-b", serve("team-b"))
+	assert.Equal(t, map[string]int{"team-a": 1, "team-b": 1}, builds, "handlers are built once per tenant")
+}